@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/airsss993/ocr-history/internal/auth"
+)
+
+// Выпускает API-ключ и печатает запись для auth.apiKeys в configs/main.yml.
+// Сам ключ нигде не сохраняется — его нужно передать клиенту сразу.
+func main() {
+	id := flag.String("id", "", "key id (default: owner)")
+	owner := flag.String("owner", "", "key owner, used for quotas")
+	scopes := flag.String("scopes", auth.ScopeOCRYandex, "comma-separated scopes: "+strings.Join(auth.AllScopes, ", "))
+	hashOnly := flag.String("hash", "", "print hash of an existing key and exit")
+	flag.Parse()
+
+	if *hashOnly != "" {
+		fmt.Println(auth.HashKey(*hashOnly))
+		return
+	}
+
+	if *owner == "" {
+		fmt.Fprintln(os.Stderr, "-owner is required")
+		os.Exit(2)
+	}
+	if *id == "" {
+		*id = *owner
+	}
+
+	scopeList := strings.Split(*scopes, ",")
+	for _, scope := range scopeList {
+		if !slices.Contains(auth.AllScopes, scope) {
+			fmt.Fprintf(os.Stderr, "unknown scope %q\n", scope)
+			os.Exit(2)
+		}
+	}
+
+	raw, err := auth.GenerateKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("key: %s\n\n", raw)
+	fmt.Println("auth:")
+	fmt.Println("  apiKeys:")
+	fmt.Printf("    - id: %q\n", *id)
+	fmt.Printf("      owner: %q\n", *owner)
+	fmt.Printf("      hash: %q\n", auth.HashKey(raw))
+	fmt.Printf("      scopes: [%s]\n", strings.Join(scopeList, ", "))
+}
@@ -1,7 +1,19 @@
 package main
 
-import "github.com/airsss993/ocr-history/internal/app"
+import (
+	"flag"
+	"os"
+
+	"github.com/airsss993/ocr-history/internal/app"
+)
 
 func main() {
+	checkConfig := flag.Bool("check-config", false, "validate configuration, print it with secrets masked and exit")
+	flag.Parse()
+
+	if *checkConfig {
+		os.Exit(app.CheckConfig(os.Stdout))
+	}
+
 	app.Run()
 }
@@ -2,72 +2,173 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/airsss993/ocr-history/internal/auth"
 	"github.com/airsss993/ocr-history/internal/config"
 	"github.com/airsss993/ocr-history/internal/handlers"
+	"github.com/airsss993/ocr-history/internal/pdf"
 	"github.com/airsss993/ocr-history/internal/server"
 	"github.com/airsss993/ocr-history/internal/storage"
+	"github.com/airsss993/ocr-history/internal/tracing"
 	"github.com/airsss993/ocr-history/pkg/logger"
 )
 
+// tracingFlushTimeout — сколько ждать отправки трасс после остановки сервера:
+// бюджет server.shutdownTimeout к этому моменту может быть исчерпан.
+const tracingFlushTimeout = 5 * time.Second
+
 func Run() {
 	cfg, err := config.Init()
 	if err != nil {
 		logger.Fatal(err)
 	}
 
+	if err := logger.Configure(cfg.Log.Level, cfg.Log.Format); err != nil {
+		logger.Fatal(err)
+	}
+	registerSecrets(cfg)
+	pdf.SetDecodeLimit(cfg.OCR.MaxDecodedBytes())
+
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if cfg.Tracing.Enabled {
+		logger.Info(fmt.Sprintf("OpenTelemetry tracing enabled (sample ratio: %.2f)", cfg.Tracing.SampleRatio))
+	}
+
 	logger.Info("Starting OCR backend with multi-provider support")
 	logger.Info("Available endpoints: /api/v1/ocr/gemini, /api/v1/ocr/yandex")
 
 	// Логируем конфигурацию Yandex (без полных ключей для безопасности)
-	if cfg.OCR.YandexAPIKey != "" {
+	if cfg.OCR.YandexKeyFile != "" {
+		logger.Info("Yandex auth: IAM tokens from service account key " + cfg.OCR.YandexKeyFile)
+	} else if cfg.OCR.YandexAPIKey != "" {
 		logger.Info(fmt.Sprintf("Yandex API Key: configured (length: %d)", len(cfg.OCR.YandexAPIKey)))
 	} else {
 		logger.Warn("Yandex API Key: not configured")
 	}
 
 	if cfg.OCR.YandexFolderID != "" {
-		logger.Info(fmt.Sprintf("Yandex Folder ID: %s", cfg.OCR.YandexFolderID))
+		logger.Info("Yandex Folder ID: configured")
 	} else {
 		logger.Warn("Yandex Folder ID: not configured")
 	}
 
 	logger.Info(fmt.Sprintf("Yandex Model: %s", cfg.OCR.YandexModel))
 
+	for _, state := range cfg.OCR.ProviderStates() {
+		if state.Enabled {
+			logger.Info(fmt.Sprintf("Provider %s: enabled", state.Name))
+		} else {
+			logger.Warn(fmt.Sprintf("Provider %s: disabled (%s)", state.Name, state.Reason))
+		}
+	}
+
 	historyStorage := storage.NewHistoryStorage(12 * time.Hour)
 	logger.Info("History storage initialized (TTL: 12 hours)")
 
-	handler := handlers.NewHandler(cfg, historyStorage)
+	keyStore, err := auth.NewKeyStore(cfg.Auth, cfg.OCR.GeminiAuthKey)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Info(fmt.Sprintf("API keys loaded: %d", len(keyStore.List())))
+
+	var (
+		userStore   *auth.UserStore
+		tokenIssuer = auth.NewTokenIssuer(cfg.Auth.JWTSecret, cfg.Auth.TokenTTL)
+	)
+	if tokenIssuer != nil {
+		userStore, err = auth.NewUserStore(cfg.Auth.UsersFile)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		logger.Info("User accounts enabled")
+	} else {
+		logger.Warn("AUTH_JWT_SECRET is not set, user accounts are disabled")
+	}
+
+	handler := handlers.NewHandler(cfg, historyStorage, keyStore, userStore, tokenIssuer)
 
 	router := handler.Init()
 
 	srv := server.NewServer(cfg, router)
 
 	go func() {
-		if err := srv.Run(); err != nil {
+		if err := srv.Run(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Fatal(err)
 		}
 	}()
 
 	logger.Info(fmt.Sprintf("ocr-backend started on port %s", cfg.Server.Port))
 
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+
+	err = config.Watch(watchCtx, func(next *config.Config) {
+		registerSecrets(next)
+		handler.Reload(next)
+	})
+	if err != nil {
+		logger.Error(fmt.Errorf("configuration hot reload is disabled: %w", err))
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	logger.Info("shutting down server...")
+	logger.Info(fmt.Sprintf("shutting down server, waiting up to %s for in-flight OCR jobs...", cfg.Server.ShutdownTimeout))
+	stopWatch()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	// Сначала /ready начинает отвечать 503 и новые пакеты отклоняются,
+	// затем ждём текущие пакеты и только после этого закрываем HTTP-сервер.
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancelDrain()
+
+	if err := handler.Drain(drainCtx); err != nil {
+		logger.Error(fmt.Errorf("in-flight OCR jobs did not finish: %w", err))
+	}
 
-	if err := srv.Stop(ctx); err != nil {
+	// Остальные запросы дорабатывают в пределах того же бюджета остановки.
+	if err := srv.Stop(drainCtx); err != nil {
 		logger.Error(fmt.Errorf("server forced to shutdown: %w", err))
 	}
 
+	handler.Close()
+	historyStorage.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+	defer cancel()
+
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error(fmt.Errorf("failed to flush traces: %w", err))
+	}
+
 	logger.Info("server exited")
 }
+
+// registerSecrets передаёт логгеру значения из конфигурации, которые
+// не должны появляться в логах и текстах ошибок.
+func registerSecrets(cfg *config.Config) {
+	logger.RegisterSecrets(
+		cfg.OCR.YandexAPIKey,
+		cfg.OCR.GeminiAPIKey,
+		cfg.OCR.GeminiAuthKey,
+		cfg.Auth.JWTSecret,
+	)
+
+	if cfg.OCR.GeminiProxyURL != "" {
+		if u, err := url.Parse(cfg.OCR.GeminiProxyURL); err == nil && u.User != nil {
+			password, _ := u.User.Password()
+			logger.RegisterSecrets(u.User.String(), password)
+		}
+	}
+}
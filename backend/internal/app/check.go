@@ -0,0 +1,42 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/airsss993/ocr-history/internal/config"
+)
+
+// CheckConfig печатает итоговую конфигурацию со скрытыми секретами,
+// состояние провайдеров и ошибки проверки. Возвращает код выхода процесса.
+func CheckConfig(w io.Writer) int {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(w, "failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	out, err := json.MarshalIndent(cfg.Masked(), "", "  ")
+	if err != nil {
+		fmt.Fprintf(w, "failed to render configuration: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(w, "%s\n\nproviders:\n", out)
+
+	for _, state := range cfg.OCR.ProviderStates() {
+		if state.Enabled {
+			fmt.Fprintf(w, "  %-7s enabled\n", state.Name)
+		} else {
+			fmt.Fprintf(w, "  %-7s disabled (%s)\n", state.Name, state.Reason)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(w, "\nconfiguration is invalid:\n%v\n", err)
+		return 1
+	}
+
+	fmt.Fprintln(w, "\nconfiguration is valid")
+	return 0
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"slices"
+)
+
+const (
+	ScopeOCRGemini    = "ocr:gemini"
+	ScopeOCRYandex    = "ocr:yandex"
+	ScopeHistoryRead  = "history:read"
+	ScopeHistoryWrite = "history:write"
+	ScopeAdmin        = "admin"
+)
+
+// AllScopes — известные области доступа.
+var AllScopes = []string{
+	ScopeOCRGemini,
+	ScopeOCRYandex,
+	ScopeHistoryRead,
+	ScopeHistoryWrite,
+	ScopeAdmin,
+}
+
+// Principal — тот, от чьего имени выполняется запрос.
+type Principal struct {
+	KeyID  string   `json:"key_id,omitempty"`
+	UserID string   `json:"user_id,omitempty"`
+	Owner  string   `json:"owner,omitempty"`
+	Scopes []string `json:"scopes"`
+}
+
+func (p *Principal) Anonymous() bool {
+	return p.KeyID == "" && p.UserID == ""
+}
+
+// UserOwner — владелец истории и квот для учётной записи пользователя.
+func UserOwner(userID string) string {
+	return "user:" + userID
+}
+
+// HasScope проверяет область доступа; admin разрешает всё.
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, ScopeAdmin)
+}
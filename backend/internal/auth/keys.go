@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/config"
+)
+
+const (
+	hashPrefix   = "sha256:"
+	rawKeyPrefix = "ocr_"
+
+	// LegacyGeminiKeyID — ключ из GEMINI_AUTH_KEY, оставлен для старых клиентов.
+	LegacyGeminiKeyID = "legacy-gemini"
+)
+
+var (
+	ErrInvalidKey  = errors.New("invalid api key")
+	ErrKeyNotFound = errors.New("api key not found")
+	// ErrConfigKey — ключ задан в конфигурации: замена ключа через API не
+	// пережила бы перезапуск, его меняют правкой конфигурации.
+	ErrConfigKey = errors.New("api key is defined in the configuration, rotate it by editing auth.apiKeys")
+)
+
+type APIKey struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	Hash      string    `json:"hash"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	Disabled  bool      `json:"disabled,omitempty"`
+}
+
+func (k *APIKey) active(now time.Time) bool {
+	return !k.Disabled && (k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt))
+}
+
+// HashKey возвращает хэш ключа в том виде, в котором он хранится в конфигурации.
+func HashKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// GenerateKey создаёт новый случайный ключ.
+func GenerateKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	return rawKeyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// KeyStore хранит хэши API-ключей. Ключи из конфигурации неизменяемы,
+// ключи, созданные через Generate/Rotate, сохраняются в keysFile.
+type KeyStore struct {
+	mu         sync.RWMutex
+	configKeys []APIKey
+	fileKeys   []APIKey
+	keysFile   string
+	now        func() time.Time
+}
+
+func NewKeyStore(cfg config.Auth, legacyGeminiKey string) (*KeyStore, error) {
+	s := &KeyStore{
+		keysFile: cfg.KeysFile,
+		now:      time.Now,
+	}
+
+	for _, k := range cfg.APIKeys {
+		key, err := keyFromConfig(k)
+		if err != nil {
+			return nil, err
+		}
+		s.configKeys = append(s.configKeys, key)
+	}
+
+	if legacyGeminiKey != "" {
+		s.configKeys = append(s.configKeys, APIKey{
+			ID:     LegacyGeminiKeyID,
+			Owner:  LegacyGeminiKeyID,
+			Hash:   HashKey(legacyGeminiKey),
+			Scopes: []string{ScopeOCRGemini},
+		})
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func keyFromConfig(k config.APIKey) (APIKey, error) {
+	if k.ID == "" {
+		return APIKey{}, fmt.Errorf("api key without id")
+	}
+	if !strings.HasPrefix(k.Hash, hashPrefix) {
+		return APIKey{}, fmt.Errorf("api key %q: hash must start with %q", k.ID, hashPrefix)
+	}
+	for _, scope := range k.Scopes {
+		if !slices.Contains(AllScopes, scope) {
+			return APIKey{}, fmt.Errorf("api key %q: unknown scope %q", k.ID, scope)
+		}
+	}
+
+	key := APIKey{
+		ID:       k.ID,
+		Owner:    k.Owner,
+		Hash:     k.Hash,
+		Scopes:   k.Scopes,
+		Disabled: k.Disabled,
+	}
+	if key.Owner == "" {
+		key.Owner = key.ID
+	}
+	if k.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, k.ExpiresAt)
+		if err != nil {
+			return APIKey{}, fmt.Errorf("api key %q: invalid expiresAt: %w", k.ID, err)
+		}
+		key.ExpiresAt = expiresAt
+	}
+	return key, nil
+}
+
+// Authenticate ищет активный ключ по его сырому значению.
+// Хэш сравнивается со всеми ключами за постоянное время.
+func (s *KeyStore) Authenticate(raw string) (*Principal, error) {
+	if raw == "" {
+		return nil, ErrInvalidKey
+	}
+	hash := []byte(HashKey(raw))
+	now := s.now()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var found *APIKey
+	for _, keys := range [][]APIKey{s.configKeys, s.fileKeys} {
+		for i := range keys {
+			if subtle.ConstantTimeCompare(hash, []byte(keys[i].Hash)) == 1 && found == nil {
+				found = &keys[i]
+			}
+		}
+	}
+
+	if found == nil || !found.active(now) {
+		return nil, ErrInvalidKey
+	}
+
+	return &Principal{
+		KeyID:  found.ID,
+		Owner:  found.Owner,
+		Scopes: slices.Clone(found.Scopes),
+	}, nil
+}
+
+// List возвращает все ключи без возможности восстановить их значения.
+func (s *KeyStore) List() []APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]APIKey, 0, len(s.configKeys)+len(s.fileKeys))
+	result = append(result, s.configKeys...)
+	result = append(result, s.fileKeys...)
+	return result
+}
+
+// Generate создаёт ключ и возвращает его сырое значение — единственный раз.
+func (s *KeyStore) Generate(owner string, scopes []string, ttl time.Duration) (string, APIKey, error) {
+	for _, scope := range scopes {
+		if !slices.Contains(AllScopes, scope) {
+			return "", APIKey{}, fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+
+	raw, err := GenerateKey()
+	if err != nil {
+		return "", APIKey{}, err
+	}
+
+	now := s.now()
+	key := APIKey{
+		ID:        fmt.Sprintf("key-%d", now.UnixNano()),
+		Owner:     owner,
+		Hash:      HashKey(raw),
+		Scopes:    slices.Clone(scopes),
+		CreatedAt: now,
+	}
+	if key.Owner == "" {
+		key.Owner = key.ID
+	}
+	if ttl > 0 {
+		key.ExpiresAt = now.Add(ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fileKeys = append(s.fileKeys, key)
+	if err := s.save(); err != nil {
+		s.fileKeys = s.fileKeys[:len(s.fileKeys)-1]
+		return "", APIKey{}, err
+	}
+	return raw, key, nil
+}
+
+// Rotate выпускает новый ключ с теми же владельцем, правами и сроком жизни,
+// а старый оставляет рабочим ещё grace, чтобы клиенты успели переключиться.
+// Ключи из конфигурации не ротируются (ErrConfigKey).
+func (s *KeyStore) Rotate(id string, grace time.Duration) (string, APIKey, error) {
+	s.mu.RLock()
+	old, ok := s.find(id)
+	fromConfig := slices.ContainsFunc(s.configKeys, func(k APIKey) bool { return k.ID == id })
+	s.mu.RUnlock()
+	if !ok {
+		return "", APIKey{}, ErrKeyNotFound
+	}
+	if fromConfig {
+		return "", APIKey{}, ErrConfigKey
+	}
+
+	var ttl time.Duration
+	if !old.ExpiresAt.IsZero() && old.ExpiresAt.After(old.CreatedAt) {
+		ttl = old.ExpiresAt.Sub(old.CreatedAt)
+	}
+
+	raw, key, err := s.Generate(old.Owner, old.Scopes, ttl)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+
+	if err := s.expire(id, s.now().Add(grace)); err != nil {
+		return "", APIKey{}, err
+	}
+	return raw, key, nil
+}
+
+// Revoke немедленно отключает ключ.
+func (s *KeyStore) Revoke(id string) error {
+	return s.expire(id, s.now())
+}
+
+func (s *KeyStore) expire(id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.fileKeys {
+		if s.fileKeys[i].ID == id {
+			s.fileKeys[i].ExpiresAt = at
+			return s.save()
+		}
+	}
+	for i := range s.configKeys {
+		if s.configKeys[i].ID == id {
+			// Ключи из конфигурации отключаются до перезапуска; навсегда — правкой конфига.
+			s.configKeys[i].ExpiresAt = at
+			return nil
+		}
+	}
+	return ErrKeyNotFound
+}
+
+func (s *KeyStore) find(id string) (APIKey, bool) {
+	for _, keys := range [][]APIKey{s.configKeys, s.fileKeys} {
+		for _, k := range keys {
+			if k.ID == id {
+				return k, true
+			}
+		}
+	}
+	return APIKey{}, false
+}
+
+func (s *KeyStore) load() error {
+	if s.keysFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.keysFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read api keys file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &s.fileKeys); err != nil {
+		return fmt.Errorf("failed to parse api keys file: %w", err)
+	}
+	return nil
+}
+
+// save записывает ключи во временный файл и атомарно подменяет keysFile. Вызывать под s.mu.
+func (s *KeyStore) save() error {
+	if s.keysFile == "" {
+		return fmt.Errorf("api keys file is not configured")
+	}
+
+	data, err := json.MarshalIndent(s.fileKeys, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal api keys: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.keysFile), 0o700); err != nil {
+		return fmt.Errorf("failed to create api keys directory: %w", err)
+	}
+
+	tmp := s.keysFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write api keys file: %w", err)
+	}
+	if err := os.Rename(tmp, s.keysFile); err != nil {
+		return fmt.Errorf("failed to replace api keys file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 8
+	maxUsernameLength = 64
+)
+
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+var (
+	ErrUserExists         = errors.New("user already exists")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrClientIDClaimed    = errors.New("client id is already claimed by another user")
+)
+
+type User struct {
+	ID           string    `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash"`
+	ClientIDs    []string  `json:"client_ids,omitempty"` // X-Client-ID, история которых перенесена к пользователю
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// UserStore хранит учётные записи в памяти и в JSON-файле usersFile.
+type UserStore struct {
+	mu      sync.RWMutex
+	users   map[string]*User // по ID
+	byName  map[string]*User
+	claimed map[string]string // client ID -> user ID
+	file    string
+}
+
+func NewUserStore(usersFile string) (*UserStore, error) {
+	s := &UserStore{
+		users:   make(map[string]*User),
+		byName:  make(map[string]*User),
+		claimed: make(map[string]string),
+		file:    usersFile,
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *UserStore) Register(username, password string) (*User, error) {
+	username = strings.TrimSpace(username)
+	if len(username) < 3 || len(username) > maxUsernameLength {
+		return nil, fmt.Errorf("username must be 3-%d characters", maxUsernameLength)
+	}
+	if len(password) < minPasswordLength {
+		return nil, fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.ToLower(username)
+	if _, ok := s.byName[key]; ok {
+		return nil, ErrUserExists
+	}
+
+	user := &User{
+		ID:           strconv.FormatInt(time.Now().UnixNano(), 10),
+		Username:     username,
+		PasswordHash: string(hash),
+		CreatedAt:    time.Now(),
+	}
+	s.users[user.ID] = user
+	s.byName[key] = user
+
+	if err := s.save(); err != nil {
+		delete(s.users, user.ID)
+		delete(s.byName, key)
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *UserStore) Authenticate(username, password string) (*User, error) {
+	s.mu.RLock()
+	user, ok := s.byName[strings.ToLower(strings.TrimSpace(username))]
+	s.mu.RUnlock()
+
+	if !ok {
+		// Сравниваем с фиктивным хэшем, чтобы время ответа не выдавало существование пользователя.
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+func (s *UserStore) Get(id string) (*User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *user
+	return &copied, true
+}
+
+// ClaimedBy возвращает пользователя, который забрал себе client ID.
+func (s *UserStore) ClaimedBy(clientID string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	userID, ok := s.claimed[clientID]
+	return userID, ok
+}
+
+// Claim закрепляет client ID за пользователем. После этого доступ к истории
+// по одному X-Client-ID закрыт. Повторный вызов тем же пользователем не ошибка.
+func (s *UserStore) Claim(userID, clientID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if owner, ok := s.claimed[clientID]; ok {
+		if owner == userID {
+			return nil
+		}
+		return ErrClientIDClaimed
+	}
+
+	user.ClientIDs = append(user.ClientIDs, clientID)
+	s.claimed[clientID] = userID
+
+	if err := s.save(); err != nil {
+		user.ClientIDs = user.ClientIDs[:len(user.ClientIDs)-1]
+		delete(s.claimed, clientID)
+		return err
+	}
+	return nil
+}
+
+func (s *UserStore) load() error {
+	if s.file == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read users file: %w", err)
+	}
+
+	var users []*User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return fmt.Errorf("failed to parse users file: %w", err)
+	}
+
+	for _, user := range users {
+		s.users[user.ID] = user
+		s.byName[strings.ToLower(user.Username)] = user
+		for _, clientID := range user.ClientIDs {
+			s.claimed[clientID] = user.ID
+		}
+	}
+	return nil
+}
+
+// save записывает пользователей во временный файл и атомарно подменяет file. Вызывать под s.mu.
+func (s *UserStore) save() error {
+	if s.file == "" {
+		return nil
+	}
+
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal users: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.file), 0o700); err != nil {
+		return fmt.Errorf("failed to create users directory: %w", err)
+	}
+
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write users file: %w", err)
+	}
+	if err := os.Rename(tmp, s.file); err != nil {
+		return fmt.Errorf("failed to replace users file: %w", err)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/airsss993/ocr-history/pkg/logger"
@@ -11,10 +12,16 @@ import (
 
 type (
 	Config struct {
-		Server    Server
-		Workers   Workers
-		OCR       OCR
-		RateLimit RateLimit
+		Server     Server
+		Workers    Workers
+		OCR        OCR
+		RateLimit  RateLimit
+		Quota      Quota
+		Auth       Auth
+		Metrics    Metrics
+		Tracing    Tracing
+		Log        Log
+		Preprocess Preprocess
 	}
 
 	Server struct {
@@ -24,6 +31,10 @@ type (
 		WriteTimeout   time.Duration
 		MaxHeaderBytes int
 		IdleTimeout    time.Duration
+
+		ShutdownTimeout    time.Duration `mapstructure:"shutdownTimeout"`    // сколько ждать завершения пакетов OCR при остановке
+		UnfinishedJobsFile string        `mapstructure:"unfinishedJobsFile"` // куда записать пакеты, не успевшие завершиться
+		TrustedProxies     []string      `mapstructure:"trustedProxies"`     // прокси, чьему X-Forwarded-For верить; пусто — никому
 	}
 
 	Workers struct {
@@ -31,34 +42,156 @@ type (
 	}
 
 	OCR struct {
-		Provider              string   `mapstructure:"provider"` // "yandex", "google" или "gemini"
-		MaxImagesPerRequest   int      `mapstructure:"maxImagesPerRequest"`
-		MaxImageSizeMB        int      `mapstructure:"maxImageSizeMB"`
-		SupportedFormats      []string `mapstructure:"supportedFormats"`
-		Languages             []string `mapstructure:"languages"`
-		YandexAPIKey          string   `mapstructure:"yandexApiKey"`
-		YandexFolderID        string   `mapstructure:"yandexFolderId"`
-		YandexModel           string   `mapstructure:"yandexModel"`          // "page" или "handwritten"
-		YandexRequestsPerSec  int      `mapstructure:"yandexRequestsPerSec"` // лимит запросов в секунду (default: 1)
-		GoogleCredentialsPath string   `mapstructure:"googleCredentialsPath"`
-		GeminiAPIKey          string   `mapstructure:"geminiApiKey"`
-		GeminiAuthKey         string   `mapstructure:"geminiAuthKey"`
-		GeminiModel           string   `mapstructure:"geminiModel"` // "gemini-2.0-flash-exp", "gemini-1.5-pro" и т.д.
-		GeminiProxyURL        string   `mapstructure:"geminiProxyUrl"`
+		Provider              string          `mapstructure:"provider"` // "yandex", "google" или "gemini"
+		MaxImagesPerRequest   int             `mapstructure:"maxImagesPerRequest"`
+		MaxImageSizeMB        int             `mapstructure:"maxImageSizeMB"`
+		SupportedFormats      []string        `mapstructure:"supportedFormats"`
+		MaxPagesPerFile       int             `mapstructure:"maxPagesPerFile"` // для PDF и многостраничных TIFF
+		Languages             []string        `mapstructure:"languages"`
+		YandexAPIKey          string          `mapstructure:"yandexApiKey"`
+		YandexKeyFile         string          `mapstructure:"yandexKeyFile"`     // авторизованный ключ сервисного аккаунта; задан — вместо yandexApiKey IAM-токены
+		YandexIAMTokenURL     string          `mapstructure:"yandexIamTokenUrl"` // обмен JWT на IAM-токен; пусто — адрес Yandex Cloud
+		YandexFolderID        string          `mapstructure:"yandexFolderId"`
+		YandexModel           string          `mapstructure:"yandexModel"`          // модель по умолчанию, см. YandexModels
+		YandexRequestsPerSec  int             `mapstructure:"yandexRequestsPerSec"` // лимит запросов в секунду (default: 1)
+		YandexEndpoints       YandexEndpoints `mapstructure:"yandexEndpoints"`
+		YandexAsync           YandexAsync     `mapstructure:"yandexAsync"`
+		GoogleCredentialsPath string          `mapstructure:"googleCredentialsPath"`
+		GeminiAPIKey          string          `mapstructure:"geminiApiKey"`
+		GeminiAuthKey         string          `mapstructure:"geminiAuthKey"`
+		GeminiModel           string          `mapstructure:"geminiModel"` // пусто — DefaultGeminiModel
+		GeminiProxyURL        string          `mapstructure:"geminiProxyUrl"`
+		GeminiPrompt          string          `mapstructure:"geminiPrompt"` // системная инструкция; пусто — встроенная
+	}
+
+	// YandexEndpoints — адреса API Yandex Vision OCR; пусто — адреса Yandex Cloud.
+	// Переопределяются для проверки на локальной заглушке.
+	YandexEndpoints struct {
+		Sync        string `mapstructure:"sync"`        // recognizeText
+		Async       string `mapstructure:"async"`       // recognizeTextAsync
+		Operations  string `mapstructure:"operations"`  // опрос операций, к адресу добавляется /<id>
+		Recognition string `mapstructure:"recognition"` // getRecognition
+	}
+
+	// YandexAsync — асинхронное распознавание PDF и крупных изображений.
+	YandexAsync struct {
+		Enabled      bool          `mapstructure:"enabled"`
+		MinSizeMB    int           `mapstructure:"minSizeMB"` // изображения от этого размера — асинхронно; 0 — только PDF
+		MaxPages     int           `mapstructure:"maxPages"`  // страниц PDF в одной операции (default: 200)
+		PollInterval time.Duration `mapstructure:"pollInterval"`
+		Timeout      time.Duration `mapstructure:"timeout"` // сколько ждать завершения операции
 	}
 
 	RateLimit struct {
 		MaxConcurrentUsers int `mapstructure:"maxConcurrentUsers"`
 		RequestsPerMinute  int `mapstructure:"requestsPerMinute"`
 	}
+
+	Quota struct {
+		Enabled bool                       `mapstructure:"enabled"`
+		Daily   QuotaLimits                `mapstructure:"daily"`
+		Monthly QuotaLimits                `mapstructure:"monthly"`
+		Clients []ClientQuota              `mapstructure:"clients"` // индивидуальные лимиты, перекрывают общие
+		Pricing map[string]ProviderPricing `mapstructure:"pricing"` // ключ — провайдер: "yandex", "gemini", "google"
+	}
+
+	// QuotaLimits — лимиты на период; 0 означает «без ограничений».
+	QuotaLimits struct {
+		Images int64   `mapstructure:"images"`
+		Pages  int64   `mapstructure:"pages"`
+		Tokens int64   `mapstructure:"tokens"`
+		Budget float64 `mapstructure:"budget"` // в валюте тарифов из pricing
+	}
+
+	ClientQuota struct {
+		ID      string      `mapstructure:"id"` // X-Client-ID или идентификатор API-ключа
+		Daily   QuotaLimits `mapstructure:"daily"`
+		Monthly QuotaLimits `mapstructure:"monthly"`
+	}
+
+	Metrics struct {
+		Enabled bool   `mapstructure:"enabled"`
+		Path    string `mapstructure:"path"`
+	}
+
+	Preprocess struct {
+		Enabled      bool                   `mapstructure:"enabled"`
+		DefaultSteps []string               `mapstructure:"defaultSteps"` // если в запросе нет поля preprocess
+		JPEGQuality  int                    `mapstructure:"jpegQuality"`
+		Limits       map[string]ImageLimits `mapstructure:"limits"` // ключ — провайдер
+	}
+
+	// ImageLimits — размеры, до которых уменьшается изображение на шаге downscale; 0 — без ограничения.
+	ImageLimits struct {
+		MaxSide   int `mapstructure:"maxSide"`
+		MaxPixels int `mapstructure:"maxPixels"`
+	}
+
+	Log struct {
+		Level  string `mapstructure:"level"`  // debug, info, warn, error
+		Format string `mapstructure:"format"` // console или json
+	}
+
+	Tracing struct {
+		Enabled     bool    `mapstructure:"enabled"`
+		Endpoint    string  `mapstructure:"endpoint"` // OTLP/HTTP, например http://localhost:4318; пусто — OTEL_EXPORTER_OTLP_ENDPOINT
+		Insecure    bool    `mapstructure:"insecure"`
+		ServiceName string  `mapstructure:"serviceName"`
+		SampleRatio float64 `mapstructure:"sampleRatio"`
+	}
+
+	Auth struct {
+		APIKeys         []APIKey `mapstructure:"apiKeys"`
+		KeysFile        string   `mapstructure:"keysFile"`        // ключи, выпущенные и ротированные во время работы
+		AnonymousScopes []string `mapstructure:"anonymousScopes"` // права запросов без ключа
+
+		JWTSecret  string        `mapstructure:"jwtSecret"` // пусто — учётные записи пользователей выключены
+		TokenTTL   time.Duration `mapstructure:"tokenTTL"`
+		UsersFile  string        `mapstructure:"usersFile"`
+		UserScopes []string      `mapstructure:"userScopes"` // права вошедших пользователей
+		// Registration открывает POST /auth/register; без него вход
+		// работает только для уже созданных учётных записей.
+		Registration bool `mapstructure:"registration"`
+	}
+
+	APIKey struct {
+		ID        string   `mapstructure:"id"`
+		Owner     string   `mapstructure:"owner"`
+		Hash      string   `mapstructure:"hash"` // "sha256:<hex>", см. cmd/apikey
+		Scopes    []string `mapstructure:"scopes"`
+		ExpiresAt string   `mapstructure:"expiresAt"` // RFC 3339, пусто — бессрочно
+		Disabled  bool     `mapstructure:"disabled"`
+	}
+
+	ProviderPricing struct {
+		PerPage          float64 `mapstructure:"perPage"`
+		PerMillionTokens float64 `mapstructure:"perMillionTokens"`
+	}
 )
 
+// Init загружает конфигурацию и проверяет её; при ошибках возвращает их все сразу.
 func Init() (*Config, error) {
-	err := godotenv.Load()
+	cfg, err := Load()
 	if err != nil {
-		logger.Warn("No .env file found, using system environment variables")
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
 	}
 
+	return cfg, nil
+}
+
+// Load читает configs/main.yml и переменные окружения и подставляет значения
+// по умолчанию, не проверяя результат.
+func Load() (*Config, error) {
+	dotenvOnce.Do(func() {
+		if err := godotenv.Load(); err != nil {
+			logger.Warn("No .env file found, using system environment variables")
+		}
+	})
+
 	if err := parseConfigFile("./configs"); err != nil {
 		logger.Error(err)
 		return nil, fmt.Errorf("failed to parse configuration file: %w", err)
@@ -76,6 +209,9 @@ func Init() (*Config, error) {
 	if apiKey := viper.GetString("YANDEX_API_KEY"); apiKey != "" {
 		cfg.OCR.YandexAPIKey = apiKey
 	}
+	if keyFile := viper.GetString("YANDEX_KEY_FILE"); keyFile != "" {
+		cfg.OCR.YandexKeyFile = keyFile
+	}
 	if folderID := viper.GetString("YANDEX_FOLDER_ID"); folderID != "" {
 		cfg.OCR.YandexFolderID = folderID
 	}
@@ -97,31 +233,52 @@ func Init() (*Config, error) {
 	if model := viper.GetString("YANDEX_MODEL"); model != "" {
 		cfg.OCR.YandexModel = model
 	}
-
-	if cfg.OCR.YandexRequestsPerSec <= 0 {
-		cfg.OCR.YandexRequestsPerSec = 1 // Yandex sync API limit: 1 req/sec
+	if level := viper.GetString("LOG_LEVEL"); level != "" {
+		cfg.Log.Level = level
+	}
+	if format := viper.GetString("LOG_FORMAT"); format != "" {
+		cfg.Log.Format = format
+	}
+	if secret := viper.GetString("AUTH_JWT_SECRET"); secret != "" {
+		cfg.Auth.JWTSecret = secret
 	}
 
+	cfg.applyDefaults()
+
 	return &cfg, nil
 }
 
+// dotenvOnce: .env читается один раз, godotenv всё равно не перезаписывает
+// уже установленные переменные при перезагрузке.
+var dotenvOnce sync.Once
+
+// viperOnce: пути поиска и привязки переменных окружения настраиваются один
+// раз, иначе каждая перезагрузка добавляла бы в viper ещё один путь.
+var viperOnce sync.Once
+
 func parseConfigFile(folder string) error {
-	viper.AddConfigPath(folder)
-	viper.SetConfigName("main")
-	viper.SetConfigType("yml")
-
-	// Явно привязываем переменные окружения
-	viper.BindEnv("OCR_PROVIDER")
-	viper.BindEnv("YANDEX_API_KEY")
-	viper.BindEnv("YANDEX_FOLDER_ID")
-	viper.BindEnv("YANDEX_MODEL")
-	viper.BindEnv("GOOGLE_CREDENTIALS_PATH")
-	viper.BindEnv("GEMINI_API_KEY")
-	viper.BindEnv("GEMINI_AUTH_KEY")
-	viper.BindEnv("GEMINI_MODEL")
-	viper.BindEnv("GEMINI_PROXY_URL")
-
-	viper.AutomaticEnv()
+	viperOnce.Do(func() {
+		viper.AddConfigPath(folder)
+		viper.SetConfigName("main")
+		viper.SetConfigType("yml")
+
+		// Явно привязываем переменные окружения
+		viper.BindEnv("OCR_PROVIDER")
+		viper.BindEnv("YANDEX_API_KEY")
+		viper.BindEnv("YANDEX_KEY_FILE")
+		viper.BindEnv("YANDEX_FOLDER_ID")
+		viper.BindEnv("YANDEX_MODEL")
+		viper.BindEnv("GOOGLE_CREDENTIALS_PATH")
+		viper.BindEnv("GEMINI_API_KEY")
+		viper.BindEnv("GEMINI_AUTH_KEY")
+		viper.BindEnv("GEMINI_MODEL")
+		viper.BindEnv("GEMINI_PROXY_URL")
+		viper.BindEnv("AUTH_JWT_SECRET")
+		viper.BindEnv("LOG_LEVEL")
+		viper.BindEnv("LOG_FORMAT")
+
+		viper.AutomaticEnv()
+	})
 
 	return viper.ReadInConfig()
 }
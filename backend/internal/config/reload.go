@@ -0,0 +1,168 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/airsss993/ocr-history/pkg/logger"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// reloadDebounce склеивает серию событий файловой системы от одного сохранения.
+const reloadDebounce = 500 * time.Millisecond
+
+// reloadableSections — секции, которые применяются без перезапуска.
+// Остальные (server, workers, auth, quota, metrics, tracing) читаются один раз при старте.
+var reloadableSections = []string{"ocr", "rateLimit", "log", "preprocess"}
+
+// Change — одно изменённое поле конфигурации; значения секретов замаскированы.
+type Change struct {
+	Path       string
+	Old        string
+	New        string
+	Reloadable bool
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Path, c.Old, c.New)
+}
+
+// Diff перечисляет поля, различающиеся в двух конфигурациях.
+func Diff(old, next *Config) []Change {
+	var changes []Change
+	diffValues("", reflect.ValueOf(*old), reflect.ValueOf(*next),
+		reflect.ValueOf(old.Masked()), reflect.ValueOf(next.Masked()), &changes)
+	return changes
+}
+
+func diffValues(path string, old, next, oldMasked, nextMasked reflect.Value, changes *[]Change) {
+	if old.Kind() == reflect.Struct {
+		for i := 0; i < old.NumField(); i++ {
+			field := old.Type().Field(i)
+			diffValues(joinPath(path, fieldName(field)),
+				old.Field(i), next.Field(i), oldMasked.Field(i), nextMasked.Field(i), changes)
+		}
+		return
+	}
+
+	if reflect.DeepEqual(old.Interface(), next.Interface()) {
+		return
+	}
+
+	*changes = append(*changes, Change{
+		Path:       path,
+		Old:        fmt.Sprint(oldMasked.Interface()),
+		New:        fmt.Sprint(nextMasked.Interface()),
+		Reloadable: isReloadable(path),
+	})
+}
+
+func fieldName(field reflect.StructField) string {
+	if tag := field.Tag.Get("mapstructure"); tag != "" {
+		return tag
+	}
+	if field.Name == strings.ToUpper(field.Name) {
+		return strings.ToLower(field.Name) // OCR -> ocr
+	}
+	return strings.ToLower(field.Name[:1]) + field.Name[1:]
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func isReloadable(path string) bool {
+	section, _, _ := strings.Cut(path, ".")
+	for _, s := range reloadableSections {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
+
+// WithReloadable возвращает копию текущей конфигурации, в которой
+// перезагружаемые секции взяты из next.
+func (c *Config) WithReloadable(next *Config) *Config {
+	merged := *c
+	merged.OCR = next.OCR
+	merged.RateLimit = next.RateLimit
+	merged.Log = next.Log
+	merged.Preprocess = next.Preprocess
+	return &merged
+}
+
+// Watch перечитывает конфигурацию при изменении configs/main.yml и по SIGHUP
+// и передаёт в apply прошедшую проверку версию. Некорректная конфигурация
+// отклоняется, работа продолжается со старой.
+func Watch(ctx context.Context, apply func(*Config)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config watcher: %w", err)
+	}
+
+	// Следим за каталогом: редакторы и ConfigMap в Kubernetes заменяют файл, а не пишут в него.
+	configFile := filepath.Clean(viper.ConfigFileUsed())
+	if err := watcher.Add(filepath.Dir(configFile)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch %s: %w", configFile, err)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer watcher.Close()
+		defer signal.Stop(hup)
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				logger.Info("SIGHUP received, reloading configuration")
+				reload(apply)
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == configFile &&
+					event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+					debounce = time.After(reloadDebounce)
+				}
+			case <-debounce:
+				debounce = nil
+				logger.Info("configuration file changed, reloading")
+				reload(apply)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Error(fmt.Errorf("config watcher: %w", err))
+			}
+		}
+	}()
+
+	return nil
+}
+
+func reload(apply func(*Config)) {
+	cfg, err := Init()
+	if err != nil {
+		logger.Error(fmt.Errorf("configuration reload rejected: %w", err))
+		return
+	}
+	apply(cfg)
+}
@@ -0,0 +1,382 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/preprocess"
+)
+
+const (
+	ProviderYandex = "yandex"
+	ProviderGemini = "gemini"
+	ProviderGoogle = "google"
+
+	// DefaultGeminiModel — модель Gemini, если в конфигурации она не задана.
+	DefaultGeminiModel = "gemini-3-pro-preview"
+)
+
+var (
+	knownProviders = []string{ProviderYandex, ProviderGemini, ProviderGoogle}
+	// routedProviders — провайдеры, у которых есть маршрут OCR; Google Vision
+	// настраивается, но запросы не обслуживает.
+	routedProviders = []string{ProviderYandex, ProviderGemini}
+	knownLogLevels  = []string{"debug", "info", "warn", "error"}
+	knownLogFormats = []string{"console", "json"}
+)
+
+// YandexModels — модели Yandex Vision OCR: общие и специализированные.
+var YandexModels = []string{
+	"page", "page-column-sort", "handwritten", "table", "markdown", "math-markdown",
+	"passport", "driver-license-front", "driver-license-back",
+	"vehicle-registration-front", "vehicle-registration-back", "license-plates",
+}
+
+// ProviderState — включён ли провайдер и почему; вычисляется по наличию учётных данных.
+type ProviderState struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// ProviderStates возвращает состояние всех известных провайдеров.
+func (o OCR) ProviderStates() []ProviderState {
+	states := make([]ProviderState, 0, len(knownProviders))
+	for _, name := range knownProviders {
+		states = append(states, o.providerState(name))
+	}
+	return states
+}
+
+// pdfDecodeRatio — во сколько раз распакованный поток PDF может быть больше
+// предельного размера файла: сканы в FlateDecode сжимаются в разы, не в сотни.
+const pdfDecodeRatio = 16
+
+// MaxDecodedBytes — предел распакованного потока PDF (см. pdf.SetDecodeLimit).
+func (o OCR) MaxDecodedBytes() int64 {
+	return int64(o.MaxImageSizeMB) << 20 * pdfDecodeRatio
+}
+
+// ProviderEnabled сообщает, настроены ли учётные данные провайдера.
+func (o OCR) ProviderEnabled(name string) bool {
+	return o.providerState(name).Enabled
+}
+
+func (o OCR) providerState(name string) ProviderState {
+	state := ProviderState{Name: name}
+	switch name {
+	case ProviderYandex:
+		switch {
+		case o.YandexKeyFile != "":
+			if _, err := os.Stat(o.YandexKeyFile); err != nil {
+				state.Reason = "yandexKeyFile is not readable"
+			} else if o.YandexFolderID == "" {
+				state.Reason = "yandexFolderId is not set"
+			} else {
+				state.Enabled = true
+			}
+		case o.YandexAPIKey == "":
+			state.Reason = "neither yandexApiKey nor yandexKeyFile is set"
+		case o.YandexFolderID == "":
+			state.Reason = "yandexFolderId is not set"
+		default:
+			state.Enabled = true
+		}
+	case ProviderGemini:
+		if o.GeminiAPIKey == "" {
+			state.Reason = "geminiApiKey is not set"
+		} else {
+			state.Enabled = true
+		}
+	case ProviderGoogle:
+		if o.GoogleCredentialsPath == "" {
+			state.Reason = "googleCredentialsPath is not set"
+		} else if _, err := os.Stat(o.GoogleCredentialsPath); err != nil {
+			state.Reason = "credentials file is not readable"
+		} else {
+			state.Enabled = true
+		}
+	default:
+		state.Reason = "unknown provider"
+	}
+	return state
+}
+
+// applyDefaults подставляет значения для незаданных параметров,
+// при которых сервис не может работать (например, нулевой пул воркеров).
+func (c *Config) applyDefaults() {
+	if c.Server.Port == "" {
+		c.Server.Port = "8100"
+	}
+	if c.Server.ReadTimeout == 0 {
+		c.Server.ReadTimeout = 120 * time.Second
+	}
+	if c.Server.WriteTimeout == 0 {
+		c.Server.WriteTimeout = 120 * time.Second
+	}
+	if c.Server.IdleTimeout == 0 {
+		c.Server.IdleTimeout = 240 * time.Second
+	}
+	if c.Server.MaxHeaderBytes == 0 {
+		c.Server.MaxHeaderBytes = 1 // в мегабайтах
+	}
+	if c.Server.ShutdownTimeout == 0 {
+		c.Server.ShutdownTimeout = 5 * time.Minute
+	}
+	if c.Server.UnfinishedJobsFile == "" {
+		c.Server.UnfinishedJobsFile = "./data/unfinished_jobs.json"
+	}
+
+	if c.Workers.MaxWorkers == 0 {
+		c.Workers.MaxWorkers = 10
+	}
+
+	if c.OCR.Provider == "" {
+		c.OCR.Provider = ProviderYandex
+	}
+	if c.OCR.MaxImagesPerRequest == 0 {
+		c.OCR.MaxImagesPerRequest = 10
+	}
+	if c.OCR.MaxImageSizeMB == 0 {
+		c.OCR.MaxImageSizeMB = 10
+	}
+	if len(c.OCR.SupportedFormats) == 0 {
+		c.OCR.SupportedFormats = []string{"jpg", "jpeg", "png", "webp", "pdf", "tif", "tiff"}
+	}
+	if c.OCR.MaxPagesPerFile == 0 {
+		c.OCR.MaxPagesPerFile = 50
+	}
+	if c.OCR.YandexModel == "" {
+		c.OCR.YandexModel = "page"
+	}
+	if c.OCR.YandexRequestsPerSec <= 0 {
+		c.OCR.YandexRequestsPerSec = 1 // Yandex sync API limit: 1 req/sec
+	}
+	if c.OCR.YandexAsync.MaxPages == 0 {
+		c.OCR.YandexAsync.MaxPages = 200
+	}
+	if c.OCR.YandexAsync.PollInterval == 0 {
+		c.OCR.YandexAsync.PollInterval = 2 * time.Second
+	}
+	if c.OCR.YandexAsync.Timeout == 0 {
+		c.OCR.YandexAsync.Timeout = 10 * time.Minute
+	}
+	if c.OCR.GeminiModel == "" {
+		c.OCR.GeminiModel = DefaultGeminiModel
+	}
+
+	if c.RateLimit.MaxConcurrentUsers == 0 {
+		c.RateLimit.MaxConcurrentUsers = 30
+	}
+	if c.RateLimit.RequestsPerMinute == 0 {
+		c.RateLimit.RequestsPerMinute = 60
+	}
+
+	if c.Log.Level == "" {
+		c.Log.Level = "info"
+	}
+	if c.Log.Format == "" {
+		c.Log.Format = "console"
+	}
+
+	if c.Preprocess.JPEGQuality == 0 {
+		c.Preprocess.JPEGQuality = 90
+	}
+
+	if c.Metrics.Path == "" {
+		c.Metrics.Path = "/metrics"
+	}
+	if c.Tracing.ServiceName == "" {
+		c.Tracing.ServiceName = "ocr-backend"
+	}
+
+	if c.Auth.TokenTTL == 0 {
+		c.Auth.TokenTTL = 7 * 24 * time.Hour
+	}
+}
+
+// Validate проверяет конфигурацию целиком и возвращает все найденные ошибки сразу.
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Workers.MaxWorkers < 1 {
+		add("workers.maxWorkers must be positive, got %d", c.Workers.MaxWorkers)
+	}
+	if c.Server.MaxHeaderBytes < 1 {
+		add("server.maxHeaderBytes must be positive, got %d", c.Server.MaxHeaderBytes)
+	}
+	if c.Server.ShutdownTimeout < 0 {
+		add("server.shutdownTimeout must not be negative")
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			add("server.trustedProxies: %q is neither an IP address nor a CIDR", proxy)
+		}
+	}
+
+	if !slices.Contains(knownProviders, c.OCR.Provider) {
+		add("ocr.provider must be one of %s, got %q", strings.Join(knownProviders, ", "), c.OCR.Provider)
+	}
+	if c.OCR.MaxImagesPerRequest < 1 {
+		add("ocr.maxImagesPerRequest must be positive, got %d", c.OCR.MaxImagesPerRequest)
+	}
+	if c.OCR.MaxImageSizeMB < 1 {
+		add("ocr.maxImageSizeMB must be positive, got %d", c.OCR.MaxImageSizeMB)
+	}
+	if c.OCR.MaxPagesPerFile < 1 {
+		add("ocr.maxPagesPerFile must be positive, got %d", c.OCR.MaxPagesPerFile)
+	}
+	for _, format := range c.OCR.SupportedFormats {
+		if format == "" || strings.HasPrefix(format, ".") || format != strings.ToLower(format) {
+			add("ocr.supportedFormats: %q must be a lowercase extension without a dot", format)
+		}
+	}
+	if !slices.Contains(YandexModels, c.OCR.YandexModel) {
+		add("ocr.yandexModel must be one of %s, got %q", strings.Join(YandexModels, ", "), c.OCR.YandexModel)
+	}
+	if c.OCR.GeminiProxyURL != "" {
+		if u, err := url.Parse(c.OCR.GeminiProxyURL); err != nil || u.Scheme == "" || u.Host == "" {
+			add("ocr.geminiProxyUrl must be an absolute URL")
+		}
+	}
+	yandexURLs := map[string]string{
+		"ocr.yandexIamTokenUrl":           c.OCR.YandexIAMTokenURL,
+		"ocr.yandexEndpoints.sync":        c.OCR.YandexEndpoints.Sync,
+		"ocr.yandexEndpoints.async":       c.OCR.YandexEndpoints.Async,
+		"ocr.yandexEndpoints.operations":  c.OCR.YandexEndpoints.Operations,
+		"ocr.yandexEndpoints.recognition": c.OCR.YandexEndpoints.Recognition,
+	}
+	for _, name := range slices.Sorted(maps.Keys(yandexURLs)) {
+		if raw := yandexURLs[name]; raw != "" {
+			if u, err := url.Parse(raw); err != nil || u.Scheme == "" || u.Host == "" {
+				add("%s must be an absolute URL", name)
+			}
+		}
+	}
+	if c.OCR.YandexAsync.MinSizeMB < 0 {
+		add("ocr.yandexAsync.minSizeMB must not be negative")
+	}
+	if c.OCR.YandexAsync.MaxPages < 1 {
+		add("ocr.yandexAsync.maxPages must be positive, got %d", c.OCR.YandexAsync.MaxPages)
+	}
+	if c.OCR.YandexAsync.PollInterval < 100*time.Millisecond {
+		add("ocr.yandexAsync.pollInterval must be at least 100ms, got %s", c.OCR.YandexAsync.PollInterval)
+	}
+	if c.OCR.YandexAsync.Timeout <= 0 {
+		add("ocr.yandexAsync.timeout must be positive")
+	}
+
+	enabled := 0
+	for _, name := range routedProviders {
+		if c.OCR.ProviderEnabled(name) {
+			enabled++
+		}
+	}
+	if enabled == 0 {
+		add("no OCR provider is configured: set YANDEX_API_KEY (or YANDEX_KEY_FILE) and YANDEX_FOLDER_ID, or GEMINI_API_KEY")
+	}
+
+	if c.RateLimit.MaxConcurrentUsers < 1 {
+		add("rateLimit.maxConcurrentUsers must be positive, got %d", c.RateLimit.MaxConcurrentUsers)
+	}
+	if c.RateLimit.RequestsPerMinute < 1 {
+		add("rateLimit.requestsPerMinute must be positive, got %d", c.RateLimit.RequestsPerMinute)
+	}
+
+	if !slices.Contains(knownLogLevels, strings.ToLower(c.Log.Level)) {
+		add("log.level must be one of %s, got %q", strings.Join(knownLogLevels, ", "), c.Log.Level)
+	}
+	if !slices.Contains(knownLogFormats, strings.ToLower(c.Log.Format)) {
+		add("log.format must be one of %s, got %q", strings.Join(knownLogFormats, ", "), c.Log.Format)
+	}
+
+	if !strings.HasPrefix(c.Metrics.Path, "/") {
+		add("metrics.path must start with /, got %q", c.Metrics.Path)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("tracing.sampleRatio must be within [0, 1], got %v", c.Tracing.SampleRatio)
+	}
+
+	for _, limits := range []struct {
+		name string
+		QuotaLimits
+	}{{"quota.daily", c.Quota.Daily}, {"quota.monthly", c.Quota.Monthly}} {
+		if limits.Images < 0 || limits.Pages < 0 || limits.Tokens < 0 || limits.Budget < 0 {
+			add("%s: limits must not be negative", limits.name)
+		}
+	}
+	for provider := range c.Quota.Pricing {
+		if !slices.Contains(knownProviders, provider) {
+			add("quota.pricing: unknown provider %q", provider)
+		}
+	}
+	for i, client := range c.Quota.Clients {
+		if client.ID == "" {
+			add("quota.clients[%d]: id is required", i)
+		}
+	}
+
+	if _, err := preprocess.ParseSteps(strings.Join(c.Preprocess.DefaultSteps, ",")); err != nil {
+		add("preprocess.defaultSteps: %v", err)
+	}
+	if c.Preprocess.JPEGQuality < 0 || c.Preprocess.JPEGQuality > 100 {
+		add("preprocess.jpegQuality must be within [0, 100], got %d", c.Preprocess.JPEGQuality)
+	}
+	for provider, limits := range c.Preprocess.Limits {
+		if !slices.Contains(knownProviders, provider) {
+			add("preprocess.limits: unknown provider %q", provider)
+		}
+		if limits.MaxSide < 0 || limits.MaxPixels < 0 {
+			add("preprocess.limits.%s: limits must not be negative", provider)
+		}
+	}
+
+	if c.Auth.TokenTTL < 0 {
+		add("auth.tokenTTL must not be negative")
+	}
+	if c.Auth.JWTSecret != "" && len(c.Auth.JWTSecret) < 32 {
+		add("auth.jwtSecret must be at least 32 characters")
+	}
+
+	return errors.Join(errs...)
+}
+
+// Masked возвращает копию конфигурации, в которой ключи, пароли
+// и учётные данные прокси заменены отметкой о том, что они заданы.
+func (c Config) Masked() Config {
+	c.OCR.YandexAPIKey = maskSecret(c.OCR.YandexAPIKey)
+	c.OCR.YandexFolderID = maskSecret(c.OCR.YandexFolderID)
+	c.OCR.GeminiAPIKey = maskSecret(c.OCR.GeminiAPIKey)
+	c.OCR.GeminiAuthKey = maskSecret(c.OCR.GeminiAuthKey)
+	if u, err := url.Parse(c.OCR.GeminiProxyURL); err == nil && u.User != nil {
+		u.User = url.User(maskSecret(u.User.Username()))
+		c.OCR.GeminiProxyURL = u.String()
+	}
+	c.Auth.JWTSecret = maskSecret(c.Auth.JWTSecret)
+
+	keys := make([]APIKey, len(c.Auth.APIKeys))
+	copy(keys, c.Auth.APIKeys)
+	for i := range keys {
+		keys[i].Hash = maskSecret(keys[i].Hash)
+	}
+	c.Auth.APIKeys = keys
+
+	return c
+}
+
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return fmt.Sprintf("***(%d chars)", len(s))
+}
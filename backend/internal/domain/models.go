@@ -6,14 +6,62 @@ import (
 )
 
 type OCRResult struct {
-	Filename string          `json:"filename"`
-	Text     json.RawMessage `json:"text"`
-	Error    string          `json:"error,omitempty"`
+	Filename      string          `json:"filename"`
+	Text          json.RawMessage `json:"text"`
+	Error         string          `json:"error,omitempty"`
+	Preprocessing []string        `json:"preprocessing,omitempty"` // применённые шаги предобработки
+	Pages         []PageResult    `json:"pages,omitempty"`         // для многостраничных файлов (PDF, TIFF) вместо text
+	Model         string          `json:"model,omitempty"`         // модель провайдера, выбранная для запроса
+	Tables        []Table         `json:"tables,omitempty"`
+	Entities      []Entity        `json:"entities,omitempty"`
+	Image         *SourceImage    `json:"-"`
+}
+
+// PageResult — результат распознавания одной страницы многостраничного файла.
+type PageResult struct {
+	Page          int             `json:"page"` // с 1
+	Text          json.RawMessage `json:"text"`
+	Error         string          `json:"error,omitempty"`
+	Preprocessing []string        `json:"preprocessing,omitempty"`
+	Tables        []Table         `json:"tables,omitempty"`
+	Entities      []Entity        `json:"entities,omitempty"`
+	Image         *SourceImage    `json:"-"`
+}
+
+// Table — таблица из результата распознавания (модель table Yandex
+// или таблица Markdown в ответе Gemini). Индексы строк и столбцов с 0.
+type Table struct {
+	Rows    int         `json:"rows"`
+	Columns int         `json:"columns"`
+	Cells   []TableCell `json:"cells"`
+}
+
+type TableCell struct {
+	Row     int    `json:"row"`
+	Column  int    `json:"column"`
+	RowSpan int    `json:"row_span"`
+	ColSpan int    `json:"col_span"`
+	Text    string `json:"text"`
+}
+
+// Entity — поле документа, извлечённое специализированной моделью
+// (например, surname в модели passport).
+type Entity struct {
+	Name string `json:"name"`
+	Text string `json:"text"`
+}
+
+// SourceImage — изображение, отправленное провайдеру (после предобработки).
+// Сохраняется только по запросу, для выгрузки с координатами текста.
+type SourceImage struct {
+	Data []byte
+	MIME string
 }
 
 type OCRResponse struct {
 	Results     []OCRResult `json:"results"`
 	TotalImages int         `json:"total_images"`
+	TotalPages  int         `json:"total_pages"` // страниц всего, с учётом многостраничных файлов
 	Successful  int         `json:"successful"`
 	Failed      int         `json:"failed"`
 	ProcessedAt time.Time   `json:"processed_at"`
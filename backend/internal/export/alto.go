@@ -0,0 +1,201 @@
+package export
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/airsss993/ocr-history/internal/layout"
+)
+
+const (
+	altoNamespace = "http://www.loc.gov/standards/alto/ns-v4#"
+	altoSchema    = "http://www.loc.gov/standards/alto/v4/alto-4-2.xsd"
+)
+
+type altoDocument struct {
+	XMLName        xml.Name        `xml:"alto"`
+	Xmlns          string          `xml:"xmlns,attr"`
+	XmlnsXSI       string          `xml:"xmlns:xsi,attr"`
+	SchemaLocation string          `xml:"xsi:schemaLocation,attr"`
+	Description    altoDescription `xml:"Description"`
+	Pages          []altoPage      `xml:"Layout>Page"`
+}
+
+type altoDescription struct {
+	MeasurementUnit string            `xml:"MeasurementUnit"`
+	FileName        string            `xml:"sourceImageInformation>fileName"`
+	OCRProcessing   altoOCRProcessing `xml:"OCRProcessing"`
+}
+
+// altoOCRProcessing — сведения о распознавании; ID в ALTO обязателен.
+type altoOCRProcessing struct {
+	ID       string `xml:"ID,attr"`
+	Software string `xml:"ocrProcessingStep>processingSoftware>softwareName"`
+}
+
+// altoBox — обязательные в ALTO 4 координаты блоков и строк.
+type altoBox struct {
+	HPos   int `xml:"HPOS,attr"`
+	VPos   int `xml:"VPOS,attr"`
+	Width  int `xml:"WIDTH,attr"`
+	Height int `xml:"HEIGHT,attr"`
+}
+
+type altoPage struct {
+	ID         string         `xml:"ID,attr"`
+	ImageNr    int            `xml:"PHYSICAL_IMG_NR,attr"`
+	Width      int            `xml:"WIDTH,attr,omitempty"`
+	Height     int            `xml:"HEIGHT,attr,omitempty"`
+	PrintSpace altoPrintSpace `xml:"PrintSpace"`
+}
+
+type altoPrintSpace struct {
+	altoBox
+	Blocks []altoTextBlock `xml:"TextBlock"`
+}
+
+type altoTextBlock struct {
+	ID string `xml:"ID,attr"`
+	altoBox
+	Lines []altoTextLine `xml:"TextLine"`
+}
+
+type altoTextLine struct {
+	ID string `xml:"ID,attr"`
+	altoBox
+	Items []interface{}
+}
+
+type altoString struct {
+	XMLName xml.Name `xml:"String"`
+	ID      string   `xml:"ID,attr"`
+	Content string   `xml:"CONTENT,attr"`
+	HPos    *int     `xml:"HPOS,attr"`
+	VPos    *int     `xml:"VPOS,attr"`
+	Width   *int     `xml:"WIDTH,attr"`
+	Height  *int     `xml:"HEIGHT,attr"`
+	WC      string   `xml:"WC,attr,omitempty"`
+}
+
+type altoSpace struct {
+	XMLName xml.Name `xml:"SP"`
+}
+
+// ALTO выгружает страницы в ALTO XML v4: блоки, строки и слова
+// с координатами в пикселях и уверенностью распознавания слова (WC).
+func ALTO(pages []Page) ([]byte, error) {
+	doc := altoDocument{
+		Xmlns:          altoNamespace,
+		XmlnsXSI:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: altoNamespace + " " + altoSchema,
+		Description: altoDescription{
+			MeasurementUnit: "pixel",
+			FileName:        pages[0].Source,
+			OCRProcessing:   altoOCRProcessing{ID: "ocr_processing_1", Software: "ocr-history"},
+		},
+	}
+
+	for i, page := range pages {
+		p := i + 1
+		lay := page.Layout
+		ap := altoPage{
+			ID:      fmt.Sprintf("page_%d", p),
+			ImageNr: p,
+			Width:   round(lay.Width),
+			Height:  round(lay.Height),
+		}
+
+		var printSpace layout.Box
+		for b, block := range lay.Blocks {
+			tb := altoTextBlock{ID: fmt.Sprintf("block_%d_%d", p, b+1), altoBox: altoBoxOf(blockBox(block))}
+			printSpace = printSpace.Union(blockBox(block))
+
+			for l, line := range block.Lines {
+				words := lineWords(line)
+				if len(words) == 0 {
+					// TextLine в ALTO содержит хотя бы один String.
+					continue
+				}
+				tl := altoTextLine{ID: fmt.Sprintf("line_%d_%d_%d", p, b+1, l+1), altoBox: altoBoxOf(line.Box)}
+				for w, word := range words {
+					if w > 0 {
+						tl.Items = append(tl.Items, altoSpace{})
+					}
+					s := altoString{
+						ID:      fmt.Sprintf("string_%d_%d_%d_%d", p, b+1, l+1, w+1),
+						Content: word.Text,
+					}
+					if !word.Box.Empty() {
+						box := altoBoxOf(word.Box)
+						s.HPos, s.VPos, s.Width, s.Height = &box.HPos, &box.VPos, &box.Width, &box.Height
+					}
+					if word.Confidence > 0 {
+						s.WC = fmt.Sprintf("%.2f", word.Confidence)
+					}
+					tl.Items = append(tl.Items, s)
+				}
+				tb.Lines = append(tb.Lines, tl)
+			}
+			ap.PrintSpace.Blocks = append(ap.PrintSpace.Blocks, tb)
+		}
+		if printSpace.Empty() && lay.Width > 0 {
+			printSpace = layout.Box{X1: lay.Width, Y1: lay.Height}
+		}
+		ap.PrintSpace.altoBox = altoBoxOf(printSpace)
+
+		doc.Pages = append(doc.Pages, ap)
+	}
+
+	return marshalXML(doc)
+}
+
+func altoBoxOf(b layout.Box) altoBox {
+	if b.Empty() {
+		return altoBox{}
+	}
+	return altoBox{HPos: round(b.X0), VPos: round(b.Y0), Width: round(b.Width()), Height: round(b.Height())}
+}
+
+// blockBox возвращает рамку блока, а если провайдер её не дал — объединение строк.
+func blockBox(block layout.Block) layout.Box {
+	if !block.Box.Empty() {
+		return block.Box
+	}
+	var box layout.Box
+	for _, line := range block.Lines {
+		box = box.Union(line.Box)
+	}
+	return box
+}
+
+// lineWords возвращает слова строки; если провайдер не разбил строку
+// на слова, они получаются разбиением текста по пробелам.
+func lineWords(line layout.Line) []layout.Word {
+	if len(line.Words) > 0 {
+		return line.Words
+	}
+	var words []layout.Word
+	for _, text := range strings.Fields(line.Text) {
+		words = append(words, layout.Word{Text: text})
+	}
+	return words
+}
+
+func round(f float64) int {
+	return int(math.Round(f))
+}
+
+func marshalXML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, fmt.Errorf("failed to encode XML: %w", err)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,151 @@
+package export
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/airsss993/ocr-history/internal/layout"
+)
+
+// testPages — две страницы: со словами и рамками, как у Yandex, и с одним
+// текстом строк без координат, как у Gemini.
+func testPages() []Page {
+	return []Page{
+		{
+			Source: "metric-book.jpg",
+			Layout: layout.Page{
+				Width: 1200, Height: 1600,
+				Blocks: []layout.Block{{
+					Box: layout.Box{X0: 100, Y0: 120, X1: 900, Y1: 260},
+					Lines: []layout.Line{
+						{
+							Text: "Родился Иван",
+							Box:  layout.Box{X0: 100, Y0: 120, X1: 600, Y1: 170},
+							Words: []layout.Word{
+								{Text: "Родился", Box: layout.Box{X0: 100, Y0: 120, X1: 320, Y1: 170}, Confidence: 0.97},
+								{Text: "Иван", Box: layout.Box{X0: 340, Y0: 120, X1: 600, Y1: 170}, Confidence: 0.81},
+							},
+						},
+						{
+							Text: "крестьянин <села> & прихода",
+							Box:  layout.Box{X0: 100, Y0: 200, X1: 900, Y1: 260},
+						},
+						{Text: "   "},
+					},
+				}},
+			},
+		},
+		{
+			Source: "letter.pdf",
+			Number: 2,
+			Layout: layout.Page{
+				Blocks: []layout.Block{{Lines: []layout.Line{{Text: "Милостивый государь"}}}},
+			},
+		},
+	}
+}
+
+// TestALTOSchema проверяет выгрузку по схеме ALTO 4.2 через xmllint:
+// по выдержке из testdata или по полной схеме из ALTO_XSD.
+func TestALTOSchema(t *testing.T) {
+	xmllint, err := exec.LookPath("xmllint")
+	if err != nil {
+		t.Skip("xmllint is not installed")
+	}
+	schema := os.Getenv("ALTO_XSD")
+	if schema == "" {
+		schema = filepath.Join("testdata", "alto-4-2.xsd")
+	}
+
+	data, err := ALTO(testPages())
+	if err != nil {
+		t.Fatalf("ALTO: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "alto.xml")
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(xmllint, "--noout", "--nonet", "--schema", schema, file).CombinedOutput()
+	if err != nil {
+		t.Fatalf("ALTO output does not validate against %s: %v\n%s\n%s", schema, err, out, data)
+	}
+}
+
+func TestALTOStructure(t *testing.T) {
+	data, err := ALTO(testPages())
+	if err != nil {
+		t.Fatalf("ALTO: %v", err)
+	}
+
+	var doc struct {
+		Processing []struct {
+			ID string `xml:"ID,attr"`
+		} `xml:"Description>OCRProcessing"`
+		Pages []struct {
+			ID      string `xml:"ID,attr"`
+			ImageNr string `xml:"PHYSICAL_IMG_NR,attr"`
+			Lines   []struct {
+				Strings []struct {
+					Content string  `xml:"CONTENT,attr"`
+					WC      *string `xml:"WC,attr"`
+				} `xml:"String"`
+			} `xml:"PrintSpace>TextBlock>TextLine"`
+		} `xml:"Layout>Page"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("ALTO output is not well-formed: %v", err)
+	}
+
+	if len(doc.Processing) != 1 || doc.Processing[0].ID == "" {
+		t.Errorf("OCRProcessing must carry an ID: %+v", doc.Processing)
+	}
+	if len(doc.Pages) != 2 {
+		t.Fatalf("got %d pages, want 2", len(doc.Pages))
+	}
+	for _, page := range doc.Pages {
+		if page.ID == "" || page.ImageNr == "" {
+			t.Errorf("page without ID or PHYSICAL_IMG_NR: %+v", page)
+		}
+		for _, line := range page.Lines {
+			if len(line.Strings) == 0 {
+				t.Errorf("page %s has a TextLine without String", page.ID)
+			}
+		}
+	}
+
+	first := doc.Pages[0].Lines
+	if len(first) != 2 {
+		t.Fatalf("got %d lines on page 1, want 2 (the blank line is dropped)", len(first))
+	}
+	if s := first[0].Strings[0]; s.Content != "Родился" || s.WC == nil || *s.WC != "0.97" {
+		t.Errorf("first word = %+v, want Родился with WC 0.97", s)
+	}
+	if got := first[1].Strings[1].Content; got != "<села>" {
+		t.Errorf("escaped word = %q, want <села>", got)
+	}
+
+	ids := map[string]bool{}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		if el, ok := tok.(xml.StartElement); ok {
+			for _, attr := range el.Attr {
+				if attr.Name.Local != "ID" {
+					continue
+				}
+				if ids[attr.Value] {
+					t.Errorf("duplicate ID %q", attr.Value)
+				}
+				ids[attr.Value] = true
+			}
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	"image/png"
+	"strings"
+
+	"github.com/airsss993/ocr-history/internal/filetype"
+	"github.com/airsss993/ocr-history/internal/multipage"
+	"github.com/airsss993/ocr-history/internal/preprocess"
+)
+
+// Текстовые документы (DOCX, ODT) строятся из общей структуры:
+// транскрипция Markdown (Gemini) или строки провайдера раскладываются
+// на заголовки, абзацы, списки и таблицы, а каждый формат их только
+// сериализует.
+
+type docKind int
+
+const (
+	docParagraph docKind = iota
+	docHeading
+	docListItem
+	docTable
+	docRule // разделитель фрагментов (--- в Markdown)
+)
+
+type docBlock struct {
+	Kind    docKind
+	Level   int  // уровень заголовка, 1–6
+	Ordered bool // нумерованный пункт списка
+	Runs    []docRun
+	Rows    [][][]docRun // ячейки таблицы
+}
+
+// docRun — фрагмент текста с одним оформлением. Break — перенос строки
+// перед фрагментом (строки одного абзаца в ответе провайдера).
+type docRun struct {
+	Text   string
+	Bold   bool
+	Italic bool
+	Break  bool
+}
+
+// docPage — страница документа: изображение (если просили и его удалось
+// подготовить) и текст.
+type docPage struct {
+	Image  *docImage
+	Blocks []docBlock
+}
+
+type docImage struct {
+	Data          []byte
+	Extension     string // png или jpeg
+	Width, Height int
+}
+
+// buildDocument раскладывает страницы выгрузки на блоки документа.
+func buildDocument(pages []Page, opts Options) []docPage {
+	result := make([]docPage, 0, len(pages))
+	for _, page := range pages {
+		var dp docPage
+		if opts.Images && len(page.Image) > 0 {
+			if img, err := prepareDocImage(page.Image, page.Format); err == nil && img.Width > 0 && img.Height > 0 {
+				dp.Image = img
+			}
+		}
+		for _, block := range page.Layout.Blocks {
+			lines := make([]string, 0, len(block.Lines))
+			for _, line := range block.Lines {
+				lines = append(lines, line.Text)
+			}
+			dp.Blocks = append(dp.Blocks, markdownBlocks(lines)...)
+		}
+		result = append(result, dp)
+	}
+	return result
+}
+
+// appendixBlocks — приложение с заметками и предупреждениями провайдера.
+func appendixBlocks(pages []Page) []docBlock {
+	meta := mergeMeta(pages)
+	if meta.Notes == "" && len(meta.Warnings) == 0 {
+		return nil
+	}
+
+	blocks := []docBlock{{Kind: docHeading, Level: 1, Runs: []docRun{{Text: "Приложение"}}}}
+	if meta.Notes != "" {
+		blocks = append(blocks, docBlock{Kind: docHeading, Level: 2, Runs: []docRun{{Text: "Примечания"}}})
+		for _, note := range strings.Split(meta.Notes, "\n") {
+			if note = strings.TrimSpace(note); note != "" {
+				blocks = append(blocks, docBlock{Kind: docParagraph, Runs: []docRun{{Text: note}}})
+			}
+		}
+	}
+	if len(meta.Warnings) > 0 {
+		blocks = append(blocks, docBlock{Kind: docHeading, Level: 2, Runs: []docRun{{Text: "Предупреждения"}}})
+		for _, w := range meta.Warnings {
+			blocks = append(blocks, docBlock{Kind: docListItem, Runs: []docRun{{Text: w}}})
+		}
+	}
+	return blocks
+}
+
+// markdownBlocks разбирает строки одного блока разметки. Строки подряд,
+// не являющиеся заголовками, пунктами списка или строками таблицы,
+// образуют один абзац с переносами строк.
+func markdownBlocks(lines []string) []docBlock {
+	var blocks []docBlock
+	var para *docBlock
+	var table *docBlock
+
+	flush := func() {
+		if para != nil {
+			blocks = append(blocks, *para)
+			para = nil
+		}
+		if table != nil {
+			blocks = append(blocks, *table)
+			table = nil
+		}
+	}
+
+	for _, text := range lines {
+		text = strings.TrimSpace(text)
+		switch {
+		case text == "":
+			flush()
+		case text == "---" || text == "***" || text == "___":
+			flush()
+			blocks = append(blocks, docBlock{Kind: docRule})
+		case markdownHeading.MatchString(text):
+			flush()
+			level := len(text) - len(strings.TrimLeft(text, "#"))
+			blocks = append(blocks, docBlock{Kind: docHeading, Level: level, Runs: markdownRuns(markdownHeading.FindStringSubmatch(text)[1])})
+		case strings.HasPrefix(text, "|"):
+			if para != nil {
+				flush()
+			}
+			if table == nil {
+				table = &docBlock{Kind: docTable}
+			}
+			if !markdownTableSeparator.MatchString(text) {
+				cells := tableCells(text)
+				row := make([][]docRun, len(cells))
+				for i, cell := range cells {
+					row[i] = markdownRuns(cell)
+				}
+				table.Rows = append(table.Rows, row)
+			}
+		case markdownListItem.MatchString(text):
+			flush()
+			ordered := text[0] >= '0' && text[0] <= '9'
+			blocks = append(blocks, docBlock{Kind: docListItem, Ordered: ordered, Runs: markdownRuns(markdownListItem.FindStringSubmatch(text)[1])})
+		default:
+			if table != nil {
+				flush()
+			}
+			runs := markdownRuns(text)
+			if para == nil {
+				para = &docBlock{Kind: docParagraph}
+			} else if len(runs) > 0 {
+				runs[0].Break = true
+			}
+			para.Runs = append(para.Runs, runs...)
+		}
+	}
+	flush()
+	return blocks
+}
+
+// markdownRuns разбирает выделение **жирным** и *курсивом*; пометки
+// ⟦...⟧ и *[Подпись]* остаются в тексте как есть.
+func markdownRuns(s string) []docRun {
+	var runs []docRun
+	var bold, italic bool
+	var cur strings.Builder
+
+	emit := func() {
+		if cur.Len() > 0 {
+			runs = append(runs, docRun{Text: cur.String(), Bold: bold, Italic: italic})
+			cur.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "*["):
+			// Пометка Gemini вида *[Подпись]* — не выделение.
+			end := strings.Index(s[i:], "]*")
+			if end < 0 {
+				cur.WriteString(s[i:])
+				i = len(s)
+				continue
+			}
+			cur.WriteString(s[i+1 : i+end+1])
+			i += end + 2
+		case strings.HasPrefix(s[i:], "**") && (bold || strings.Contains(s[i+2:], "**")):
+			emit()
+			bold = !bold
+			i += 2
+		case s[i] == '*' && (italic || strings.Contains(s[i+1:], "*")):
+			emit()
+			italic = !italic
+			i++
+		default:
+			cur.WriteByte(s[i])
+			i++
+		}
+	}
+	emit()
+	return runs
+}
+
+// prepareDocImage приводит изображение к JPEG или PNG, которые понимают
+// и Word, и LibreOffice; из многостраничного файла берётся первая страница.
+func prepareDocImage(data []byte, format filetype.Format) (*docImage, error) {
+	if multipage.IsMultipage(format) {
+		pages, err := multipage.Split(data, format, 1)
+		if err != nil {
+			return nil, err
+		}
+		if pages[0].Err != nil {
+			return nil, pages[0].Err
+		}
+		data, format = pages[0].Data, pages[0].Format
+	}
+
+	switch format.Name {
+	case filetype.JPEG.Name, filetype.PNG.Name:
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image: %w", err)
+		}
+		return &docImage{Data: data, Extension: format.Name, Width: cfg.Width, Height: cfg.Height}, nil
+	}
+
+	img, err := preprocess.DecodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	return &docImage{Data: buf.Bytes(), Extension: "png", Width: b.Dx(), Height: b.Dy()}, nil
+}
+
+// fitImage вписывает изображение в прямоугольник maxW × maxH, сохраняя пропорции.
+func fitImage(img *docImage, maxW, maxH float64) (float64, float64) {
+	w, h := float64(img.Width), float64(img.Height)
+	scale := min(maxW/w, maxH/h)
+	return w * scale, h * scale
+}
+
+// docEscape экранирует текст для XML документа и убирает управляющие
+// символы, недопустимые в XML 1.0.
+func docEscape(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' && r != '\n' {
+			return -1
+		}
+		return r
+	}, s)
+	return html.EscapeString(s)
+}
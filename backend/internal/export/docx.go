@@ -0,0 +1,284 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Размеры страницы DOCX (A4, поля 2 см) в twip; 1 twip = 635 EMU.
+const (
+	docxPageW      = 11906
+	docxPageH      = 16838
+	docxMargin     = 1134
+	docxTwipEMU    = 635
+	docxTextW      = docxPageW - 2*docxMargin
+	docxTextH      = docxPageH - 2*docxMargin - 600 // запас на пустой абзац разрыва страницы
+	docxNumBullet  = 1
+	docxNumDecimal = 2
+)
+
+// DOCX собирает документ Word (Office Open XML) без внешних зависимостей:
+// заголовки, абзацы, списки и таблицы из транскрипции, по запросу —
+// изображение страницы перед её текстом и приложение с заметками.
+func DOCX(pages []Page, opts Options) ([]byte, error) {
+	doc := buildDocument(pages, opts)
+
+	var body strings.Builder
+	var media []docxMedia
+	for i, page := range doc {
+		if i > 0 {
+			body.WriteString(docxPageBreak)
+		}
+		if page.Image != nil {
+			media = append(media, docxMedia{ID: len(media) + 1, Image: page.Image})
+			writeDocxImage(&body, media[len(media)-1])
+			body.WriteString(docxPageBreak)
+		}
+		writeDocxBlocks(&body, page.Blocks)
+	}
+	if opts.Notes {
+		if appendix := appendixBlocks(pages); len(appendix) > 0 {
+			body.WriteString(docxPageBreak)
+			writeDocxBlocks(&body, appendix)
+		}
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := []struct {
+		name string
+		data string
+	}{
+		{"[Content_Types].xml", docxContentTypes},
+		{"_rels/.rels", docxRootRels},
+		{"docProps/core.xml", docxCoreProps(mergeMeta(pages))},
+		{"word/document.xml", docxDocumentStart + body.String() + docxDocumentEnd},
+		{"word/styles.xml", docxStyles},
+		{"word/numbering.xml", docxNumbering},
+		{"word/_rels/document.xml.rels", docxDocumentRels(media)},
+	}
+	for _, f := range files {
+		if err := zipFile(zw, f.name, []byte(f.data)); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range media {
+		if err := zipFile(zw, "word/media/"+m.name(), m.Image.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type docxMedia struct {
+	ID    int
+	Image *docImage
+}
+
+func (m docxMedia) name() string {
+	return fmt.Sprintf("image%d.%s", m.ID, m.Image.Extension)
+}
+
+func (m docxMedia) relID() string {
+	return fmt.Sprintf("rIdImage%d", m.ID)
+}
+
+func zipFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+const docxPageBreak = `<w:p><w:r><w:br w:type="page"/></w:r></w:p>` + "\n"
+
+func writeDocxBlocks(b *strings.Builder, blocks []docBlock) {
+	for _, block := range blocks {
+		switch block.Kind {
+		case docHeading:
+			fmt.Fprintf(b, `<w:p><w:pPr><w:pStyle w:val="Heading%d"/></w:pPr>%s</w:p>`+"\n", block.Level, docxRuns(block.Runs))
+		case docListItem:
+			num := docxNumBullet
+			if block.Ordered {
+				num = docxNumDecimal
+			}
+			fmt.Fprintf(b, `<w:p><w:pPr><w:pStyle w:val="ListParagraph"/><w:numPr><w:ilvl w:val="0"/><w:numId w:val="%d"/></w:numPr></w:pPr>%s</w:p>`+"\n",
+				num, docxRuns(block.Runs))
+		case docTable:
+			writeDocxTable(b, block.Rows)
+		case docRule:
+			b.WriteString(`<w:p><w:pPr><w:pBdr><w:bottom w:val="single" w:sz="6" w:space="1" w:color="auto"/></w:pBdr></w:pPr></w:p>` + "\n")
+		default:
+			fmt.Fprintf(b, "<w:p>%s</w:p>\n", docxRuns(block.Runs))
+		}
+	}
+}
+
+func docxRuns(runs []docRun) string {
+	var b strings.Builder
+	for _, run := range runs {
+		if run.Break {
+			b.WriteString("<w:r><w:br/></w:r>")
+		}
+		b.WriteString("<w:r>")
+		if run.Bold || run.Italic {
+			b.WriteString("<w:rPr>")
+			if run.Bold {
+				b.WriteString("<w:b/>")
+			}
+			if run.Italic {
+				b.WriteString("<w:i/>")
+			}
+			b.WriteString("</w:rPr>")
+		}
+		fmt.Fprintf(&b, `<w:t xml:space="preserve">%s</w:t></w:r>`, docEscape(run.Text))
+	}
+	return b.String()
+}
+
+func writeDocxTable(b *strings.Builder, rows [][][]docRun) {
+	cols := 0
+	for _, row := range rows {
+		cols = max(cols, len(row))
+	}
+	if cols == 0 {
+		return
+	}
+	colW := docxTextW / cols
+
+	b.WriteString(`<w:tbl><w:tblPr><w:tblStyle w:val="TableGrid"/><w:tblW w:w="0" w:type="auto"/></w:tblPr><w:tblGrid>`)
+	for range cols {
+		fmt.Fprintf(b, `<w:gridCol w:w="%d"/>`, colW)
+	}
+	b.WriteString("</w:tblGrid>\n")
+	for _, row := range rows {
+		b.WriteString("<w:tr>")
+		for c := range cols {
+			var runs []docRun
+			if c < len(row) {
+				runs = row[c]
+			}
+			fmt.Fprintf(b, `<w:tc><w:tcPr><w:tcW w:w="%d" w:type="dxa"/></w:tcPr><w:p>%s</w:p></w:tc>`, colW, docxRuns(runs))
+		}
+		b.WriteString("</w:tr>\n")
+	}
+	b.WriteString("</w:tbl>\n")
+	// Word требует абзац между соседними таблицами и после таблицы в конце документа.
+	b.WriteString("<w:p/>\n")
+}
+
+func writeDocxImage(b *strings.Builder, m docxMedia) {
+	w, h := fitImage(m.Image, docxTextW*docxTwipEMU, docxTextH*docxTwipEMU)
+	cx, cy := int64(w), int64(h)
+	fmt.Fprintf(b, `<w:p><w:pPr><w:jc w:val="center"/></w:pPr><w:r><w:drawing>`+
+		`<wp:inline distT="0" distB="0" distL="0" distR="0"><wp:extent cx="%[1]d" cy="%[2]d"/>`+
+		`<wp:docPr id="%[3]d" name="Picture %[3]d"/>`+
+		`<a:graphic xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main">`+
+		`<a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/picture">`+
+		`<pic:pic xmlns:pic="http://schemas.openxmlformats.org/drawingml/2006/picture">`+
+		`<pic:nvPicPr><pic:cNvPr id="%[3]d" name="%[4]s"/><pic:cNvPicPr/></pic:nvPicPr>`+
+		`<pic:blipFill><a:blip r:embed="%[5]s"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>`+
+		`<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="%[1]d" cy="%[2]d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr>`+
+		`</pic:pic></a:graphicData></a:graphic></wp:inline></w:drawing></w:r></w:p>`+"\n",
+		cx, cy, m.ID, m.name(), m.relID())
+}
+
+func docxDocumentRels(media []docxMedia) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rIdStyles" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+<Relationship Id="rIdNumbering" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering" Target="numbering.xml"/>
+`)
+	for _, m := range media {
+		fmt.Fprintf(&b, `<Relationship Id="%s" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/image" Target="media/%s"/>`+"\n",
+			m.relID(), m.name())
+	}
+	b.WriteString("</Relationships>\n")
+	return b.String()
+}
+
+func docxCoreProps(meta Meta) string {
+	created := meta.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+<dc:title>%s</dc:title>
+<dc:description>%s</dc:description>
+<dc:creator>ocr-history</dc:creator>
+<dcterms:created xsi:type="dcterms:W3CDTF">%s</dcterms:created>
+</cp:coreProperties>
+`, docEscape(meta.Title), docEscape(meta.Summary), created.UTC().Format(time.RFC3339))
+}
+
+const docxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Default Extension="png" ContentType="image/png"/>
+<Default Extension="jpeg" ContentType="image/jpeg"/>
+<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>
+<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>
+<Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/>
+<Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>
+</Types>
+`
+
+const docxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties" Target="docProps/core.xml"/>
+</Relationships>
+`
+
+const docxDocumentStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships" xmlns:wp="http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing">
+<w:body>
+`
+
+var docxDocumentEnd = fmt.Sprintf(`<w:sectPr><w:pgSz w:w="%d" w:h="%d"/><w:pgMar w:top="%[3]d" w:right="%[3]d" w:bottom="%[3]d" w:left="%[3]d" w:header="709" w:footer="709" w:gutter="0"/></w:sectPr>
+</w:body>
+</w:document>
+`, docxPageW, docxPageH, docxMargin)
+
+var docxStyles = func() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Times New Roman" w:hAnsi="Times New Roman" w:cs="Times New Roman"/><w:sz w:val="24"/><w:lang w:val="ru-RU"/></w:rPr></w:rPrDefault>
+<w:pPrDefault><w:pPr><w:spacing w:after="120"/></w:pPr></w:pPrDefault></w:docDefaults>
+<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/></w:style>
+<w:style w:type="paragraph" w:styleId="ListParagraph"><w:name w:val="List Paragraph"/><w:basedOn w:val="Normal"/><w:pPr><w:ind w:left="720"/></w:pPr></w:style>
+<w:style w:type="table" w:styleId="TableGrid"><w:name w:val="Table Grid"/><w:tblPr><w:tblBorders>`)
+	for _, side := range []string{"top", "left", "bottom", "right", "insideH", "insideV"} {
+		fmt.Fprintf(&b, `<w:%s w:val="single" w:sz="4" w:space="0" w:color="auto"/>`, side)
+	}
+	b.WriteString("</w:tblBorders></w:tblPr></w:style>\n")
+	sizes := []int{32, 28, 26, 24, 24, 24}
+	for i, size := range sizes {
+		fmt.Fprintf(&b, `<w:style w:type="paragraph" w:styleId="Heading%[1]d"><w:name w:val="heading %[1]d"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/>`+
+			`<w:pPr><w:keepNext/><w:spacing w:before="240" w:after="120"/><w:outlineLvl w:val="%[2]d"/></w:pPr><w:rPr><w:b/><w:sz w:val="%[3]d"/></w:rPr></w:style>`+"\n",
+			i+1, i, size)
+	}
+	b.WriteString("</w:styles>\n")
+	return b.String()
+}()
+
+var docxNumbering = fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:numbering xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:abstractNum w:abstractNumId="0"><w:lvl w:ilvl="0"><w:start w:val="1"/><w:numFmt w:val="bullet"/><w:lvlText w:val="•"/><w:lvlJc w:val="left"/><w:pPr><w:ind w:left="720" w:hanging="360"/></w:pPr></w:lvl></w:abstractNum>
+<w:abstractNum w:abstractNumId="1"><w:lvl w:ilvl="0"><w:start w:val="1"/><w:numFmt w:val="decimal"/><w:lvlText w:val="%%1."/><w:lvlJc w:val="left"/><w:pPr><w:ind w:left="720" w:hanging="360"/></w:pPr></w:lvl></w:abstractNum>
+<w:num w:numId="%d"><w:abstractNumId w:val="0"/></w:num>
+<w:num w:numId="%d"><w:abstractNumId w:val="1"/></w:num>
+</w:numbering>
+`, docxNumBullet, docxNumDecimal)
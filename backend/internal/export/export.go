@@ -0,0 +1,193 @@
+// Package export формирует из результатов OCR файлы для архива.
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/airsss993/ocr-history/internal/filetype"
+	"github.com/airsss993/ocr-history/internal/layout"
+)
+
+// Page — одна страница выгрузки: изображение, которое видел провайдер,
+// и распознанная разметка.
+type Page struct {
+	Source string // имя исходного файла
+	Number int    // номер страницы в исходном файле, 0 — файл одностраничный
+	Image  []byte // может отсутствовать, если изображение не сохранилось
+	Format filetype.Format
+	Layout layout.Page
+	Meta   Meta
+}
+
+// Format — формат выгрузки.
+type Format struct {
+	Name          string
+	ContentType   string
+	Extension     string
+	RequiresImage bool // страницы без изображения пропускаются
+	// PerPage — файл формата описывает одну страницу (PAGE XML); несколько
+	// страниц выгружаются ZIP-архивом: изображения в корне, разметка в page/.
+	PerPage bool
+	Build   func(pages []Page, opts Options) ([]byte, error)
+}
+
+// Options — необязательные части документа; форматы, где их нет, их не учитывают.
+type Options struct {
+	Images bool // исходное изображение на странице перед текстом (DOCX, ODT)
+	Notes  bool // приложение с заметками и предупреждениями провайдера
+	Table  int  // номер таблицы (с 1) для CSV и XLSX; 0 — все таблицы
+}
+
+// pagesOnly приспосабливает построитель без параметров к Format.Build.
+func pagesOnly(build func(pages []Page) ([]byte, error)) func([]Page, Options) ([]byte, error) {
+	return func(pages []Page, _ Options) ([]byte, error) {
+		return build(pages)
+	}
+}
+
+// File — готовая выгрузка.
+type File struct {
+	Data        []byte
+	ContentType string
+	Extension   string
+}
+
+var formats = map[string]Format{
+	"pdf": {
+		Name:          "pdf",
+		ContentType:   "application/pdf",
+		Extension:     "pdf",
+		RequiresImage: true,
+		Build:         pagesOnly(SearchablePDF),
+	},
+	"alto": {
+		Name:        "alto",
+		ContentType: "application/xml; charset=utf-8",
+		Extension:   "xml",
+		Build:       pagesOnly(ALTO),
+	},
+	"hocr": {
+		Name:        "hocr",
+		ContentType: "application/xhtml+xml; charset=utf-8",
+		Extension:   "hocr",
+		Build:       pagesOnly(HOCR),
+	},
+	"tei": {
+		Name:        "tei",
+		ContentType: "application/tei+xml; charset=utf-8",
+		Extension:   "xml",
+		Build:       pagesOnly(TEI),
+	},
+	"docx": {
+		Name:        "docx",
+		ContentType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		Extension:   "docx",
+		Build:       DOCX,
+	},
+	"odt": {
+		Name:        "odt",
+		ContentType: "application/vnd.oasis.opendocument.text",
+		Extension:   "odt",
+		Build:       ODT,
+	},
+	"csv": {
+		Name:        "csv",
+		ContentType: "text/csv; charset=utf-8",
+		Extension:   "csv",
+		Build:       CSV,
+	},
+	"xlsx": {
+		Name:        "xlsx",
+		ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		Extension:   "xlsx",
+		Build:       XLSX,
+	},
+	"page": {
+		Name:        "page",
+		ContentType: "application/xml; charset=utf-8",
+		Extension:   "xml",
+		PerPage:     true,
+		Build:       pagesOnly(PageXML),
+	},
+}
+
+// Lookup возвращает формат выгрузки по имени.
+func Lookup(name string) (Format, bool) {
+	f, ok := formats[name]
+	return f, ok
+}
+
+// Names возвращает имена поддерживаемых форматов.
+func Names() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Write собирает выгрузку, отбрасывая страницы, непригодные для формата.
+func (f Format) Write(pages []Page, opts Options) (File, error) {
+	usable := make([]Page, 0, len(pages))
+	for _, page := range pages {
+		if f.RequiresImage && len(page.Image) == 0 {
+			continue
+		}
+		usable = append(usable, page)
+	}
+	if len(usable) == 0 {
+		return File{}, fmt.Errorf("no pages to export")
+	}
+	if f.PerPage && len(usable) > 1 {
+		return f.archive(usable, opts)
+	}
+
+	data, err := f.Build(usable, opts)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Data: data, ContentType: f.ContentType, Extension: f.Extension}, nil
+}
+
+// archive раскладывает страницы по файлам так, как их импортирует Transkribus:
+// 0001.jpg и page/0001.xml со ссылкой на изображение.
+func (f Format) archive(pages []Page, opts Options) (File, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for i, page := range pages {
+		base := fmt.Sprintf("%04d", i+1)
+		if len(page.Image) > 0 && len(page.Format.Extensions) > 0 {
+			name := base + "." + page.Format.Extensions[0]
+			w, err := zw.Create(name)
+			if err != nil {
+				return File{}, err
+			}
+			if _, err := w.Write(page.Image); err != nil {
+				return File{}, err
+			}
+			page.Source = name
+		}
+
+		data, err := f.Build([]Page{page}, opts)
+		if err != nil {
+			return File{}, fmt.Errorf("page %d: %w", i+1, err)
+		}
+		w, err := zw.Create("page/" + base + "." + f.Extension)
+		if err != nil {
+			return File{}, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return File{}, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return File{}, err
+	}
+	return File{Data: buf.Bytes(), ContentType: "application/zip", Extension: "zip"}, nil
+}
@@ -0,0 +1,98 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/airsss993/ocr-history/internal/layout"
+)
+
+const hocrCapabilities = "ocr_page ocr_carea ocr_par ocr_line ocrx_word ocrp_wconf"
+
+// HOCR выгружает страницы в hOCR 1.2: XHTML с классами ocr_page, ocr_carea,
+// ocr_par, ocr_line и ocrx_word, координатами в title (bbox) и уверенностью
+// распознавания слова в процентах (x_wconf).
+func HOCR(pages []Page) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="ru" lang="ru">
+<head>
+  <title></title>
+  <meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
+  <meta name="ocr-system" content="ocr-history"/>
+  <meta name="ocr-capabilities" content="` + hocrCapabilities + `"/>
+</head>
+<body>
+`)
+
+	for i, page := range pages {
+		p := i + 1
+		lay := page.Layout
+
+		pageProps := []string{fmt.Sprintf("image %q", hocrImageName(page)), fmt.Sprintf("ppageno %d", i)}
+		if lay.Width > 0 && lay.Height > 0 {
+			pageProps = append(pageProps, "bbox "+hocrBBox(layout.Box{X1: lay.Width, Y1: lay.Height}))
+		}
+		fmt.Fprintf(&buf, "  <div class=\"ocr_page\" id=\"page_%d\" title=\"%s\">\n", p, html.EscapeString(strings.Join(pageProps, "; ")))
+
+		for b, block := range lay.Blocks {
+			id := fmt.Sprintf("%d_%d", p, b+1)
+			box := hocrTitle(blockBox(block))
+			fmt.Fprintf(&buf, "    <div class=\"ocr_carea\" id=\"block_%s\"%s>\n", id, box)
+			fmt.Fprintf(&buf, "      <p class=\"ocr_par\" id=\"par_%s\"%s>\n", id, box)
+
+			for l, line := range block.Lines {
+				lineID := fmt.Sprintf("%s_%d", id, l+1)
+				fmt.Fprintf(&buf, "        <span class=\"ocr_line\" id=\"line_%s\"%s>", lineID, hocrTitle(line.Box))
+				for w, word := range lineWords(line) {
+					if w > 0 {
+						buf.WriteByte(' ')
+					}
+					var props []string
+					if !word.Box.Empty() {
+						props = append(props, "bbox "+hocrBBox(word.Box))
+					}
+					if word.Confidence > 0 {
+						props = append(props, fmt.Sprintf("x_wconf %d", round(word.Confidence*100)))
+					}
+					title := ""
+					if len(props) > 0 {
+						title = fmt.Sprintf(" title=\"%s\"", strings.Join(props, "; "))
+					}
+					fmt.Fprintf(&buf, "<span class=\"ocrx_word\" id=\"word_%s_%d\"%s>%s</span>",
+						lineID, w+1, title, html.EscapeString(word.Text))
+				}
+				buf.WriteString("</span>\n")
+			}
+
+			buf.WriteString("      </p>\n    </div>\n")
+		}
+		buf.WriteString("  </div>\n")
+	}
+
+	buf.WriteString("</body>\n</html>\n")
+	return buf.Bytes(), nil
+}
+
+// hocrImageName — имя изображения страницы для свойства image.
+func hocrImageName(page Page) string {
+	if page.Number > 0 {
+		return fmt.Sprintf("%s#page=%d", page.Source, page.Number)
+	}
+	return page.Source
+}
+
+func hocrBBox(b layout.Box) string {
+	return fmt.Sprintf("%d %d %d %d", round(b.X0), round(b.Y0), round(b.X1), round(b.Y1))
+}
+
+// hocrTitle возвращает атрибут title с bbox или пустую строку, если рамки нет.
+func hocrTitle(b layout.Box) string {
+	if b.Empty() {
+		return ""
+	}
+	return fmt.Sprintf(" title=\"bbox %s\"", hocrBBox(b))
+}
@@ -0,0 +1,165 @@
+package export
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// hocrParent — класс, внутри которого по спецификации hOCR лежит элемент.
+var hocrParent = map[string]string{
+	"ocr_page":  "",
+	"ocr_carea": "ocr_page",
+	"ocr_par":   "ocr_carea",
+	"ocr_line":  "ocr_par",
+	"ocrx_word": "ocr_line",
+}
+
+// hocrProps разбирает title: свойства через «;», имя отделено пробелом.
+func hocrProps(title string) map[string]string {
+	props := map[string]string{}
+	for _, prop := range strings.Split(title, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(prop), " ")
+		if name != "" {
+			props[name] = value
+		}
+	}
+	return props
+}
+
+func checkBBox(t *testing.T, id, value string) {
+	t.Helper()
+	fields := strings.Fields(value)
+	if len(fields) != 4 {
+		t.Errorf("%s: bbox %q must have 4 coordinates", id, value)
+		return
+	}
+	var c [4]int
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			t.Errorf("%s: bbox %q has a non-integer coordinate", id, value)
+			return
+		}
+		c[i] = n
+	}
+	if c[0] > c[2] || c[1] > c[3] {
+		t.Errorf("%s: bbox %q is not x0 y0 x1 y1", id, value)
+	}
+}
+
+func TestHOCRStructure(t *testing.T) {
+	data, err := HOCR(testPages())
+	if err != nil {
+		t.Fatalf("HOCR: %v", err)
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	dec.Entity = xml.HTMLEntity
+
+	var (
+		stack        []string // классы hOCR открытых элементов, "" — прочие
+		ids          = map[string]bool{}
+		seen         = map[string]int{}
+		capabilities string
+		words        []string
+		inWord       bool
+	)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch el := tok.(type) {
+		case xml.StartElement:
+			attrs := map[string]string{}
+			for _, a := range el.Attr {
+				attrs[a.Name.Local] = a.Value
+			}
+			if el.Name.Local == "meta" && attrs["name"] == "ocr-capabilities" {
+				capabilities = attrs["content"]
+			}
+
+			class := attrs["class"]
+			if _, ok := hocrParent[class]; !ok {
+				stack = append(stack, "")
+				continue
+			}
+			seen[class]++
+
+			id := attrs["id"]
+			if id == "" || ids[id] {
+				t.Errorf("%s element has a missing or duplicate id %q", class, id)
+			}
+			ids[id] = true
+
+			parent := ""
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i] != "" {
+					parent = stack[i]
+					break
+				}
+			}
+			if parent != hocrParent[class] {
+				t.Errorf("%s %s is inside %q, want %q", class, id, parent, hocrParent[class])
+			}
+
+			props := hocrProps(attrs["title"])
+			if bbox, ok := props["bbox"]; ok {
+				checkBBox(t, id, bbox)
+			}
+			if class == "ocr_page" {
+				if _, ok := props["image"]; !ok {
+					t.Errorf("%s has no image property", id)
+				}
+				if _, ok := props["ppageno"]; !ok {
+					t.Errorf("%s has no ppageno property", id)
+				}
+			}
+			if conf, ok := props["x_wconf"]; ok {
+				if n, err := strconv.Atoi(conf); err != nil || n < 0 || n > 100 {
+					t.Errorf("%s: x_wconf %q is not 0-100", id, conf)
+				}
+			}
+
+			stack = append(stack, class)
+			inWord = class == "ocrx_word"
+			if inWord {
+				words = append(words, "")
+			}
+		case xml.CharData:
+			if inWord {
+				words[len(words)-1] += string(el)
+			}
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+			inWord = false
+		}
+	}
+
+	for class := range hocrParent {
+		if seen[class] == 0 {
+			t.Errorf("no %s elements in the output", class)
+		}
+		if !strings.Contains(capabilities, class) {
+			t.Errorf("ocr-capabilities %q does not list %s", capabilities, class)
+		}
+	}
+	if seen["ocr_page"] != 2 {
+		t.Errorf("got %d ocr_page elements, want 2", seen["ocr_page"])
+	}
+
+	want := "Родился Иван крестьянин <села> & прихода Милостивый государь"
+	if got := strings.Join(words, " "); got != want {
+		t.Errorf("words = %q, want %q", got, want)
+	}
+	if !bytes.Contains(data, []byte(`x_wconf 97`)) {
+		t.Error("word confidence is not written as x_wconf percent")
+	}
+	if !bytes.Contains(data, []byte(`image &#34;letter.pdf#page=2&#34;`)) && !bytes.Contains(data, []byte(`image &quot;letter.pdf#page=2&quot;`)) {
+		t.Errorf("second page does not reference letter.pdf#page=2:\n%s", data)
+	}
+}
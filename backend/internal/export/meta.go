@@ -0,0 +1,46 @@
+package export
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Meta — сведения о документе для заголовков выгрузки (teiHeader и т.п.).
+type Meta struct {
+	EntryID  string    // ID записи истории, если выгрузка из истории
+	Created  time.Time // когда документ распознан
+	Title    string
+	Language string
+	Summary  string
+	Notes    string
+	Warnings []string
+}
+
+// GeminiMeta извлекает сведения о документе из ответа Gemini
+// (summary, language, document_title, notes, warnings). Для других
+// провайдеров возвращает пустую структуру.
+func GeminiMeta(text json.RawMessage) Meta {
+	var s string
+	if json.Unmarshal(text, &s) == nil {
+		text = json.RawMessage(strings.TrimSpace(s))
+	}
+
+	var resp struct {
+		Summary       string   `json:"summary"`
+		Language      string   `json:"language"`
+		DocumentTitle string   `json:"document_title"`
+		Notes         string   `json:"notes"`
+		Warnings      []string `json:"warnings"`
+	}
+	if json.Unmarshal(text, &resp) != nil {
+		return Meta{}
+	}
+	return Meta{
+		Title:    strings.TrimSpace(resp.DocumentTitle),
+		Language: strings.TrimSpace(resp.Language),
+		Summary:  strings.TrimSpace(resp.Summary),
+		Notes:    strings.TrimSpace(resp.Notes),
+		Warnings: resp.Warnings,
+	}
+}
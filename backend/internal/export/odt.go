@@ -0,0 +1,307 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Область текста ODT (A4, поля 2 см) в сантиметрах.
+const (
+	odtTextW = 17.0
+	odtTextH = 25.0
+)
+
+// ODT собирает документ OpenDocument Text с тем же содержимым, что и DOCX.
+func ODT(pages []Page, opts Options) ([]byte, error) {
+	doc := buildDocument(pages, opts)
+
+	var body strings.Builder
+	var pictures []string
+	var images []*docImage
+	breakNext := false
+	for i, page := range doc {
+		if i > 0 {
+			breakNext = true
+		}
+		if page.Image != nil {
+			name := fmt.Sprintf("Pictures/image%d.%s", len(images)+1, page.Image.Extension)
+			pictures, images = append(pictures, name), append(images, page.Image)
+			writeODTImage(&body, page.Image, name, len(images), breakNext)
+			breakNext = true
+		}
+		writeODTBlocks(&body, page.Blocks, &breakNext)
+	}
+	if opts.Notes {
+		if appendix := appendixBlocks(pages); len(appendix) > 0 {
+			breakNext = true
+			writeODTBlocks(&body, appendix, &breakNext)
+		}
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	// mimetype — первый файл архива и без сжатия, так требует ODF.
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte("application/vnd.oasis.opendocument.text")); err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name string
+		data string
+	}{
+		{"META-INF/manifest.xml", odtManifest(pictures)},
+		{"meta.xml", odtMeta(mergeMeta(pages))},
+		{"styles.xml", odtStyles},
+		{"content.xml", odtContentStart + body.String() + odtContentEnd},
+	}
+	for _, f := range files {
+		if err := zipFile(zw, f.name, []byte(f.data)); err != nil {
+			return nil, err
+		}
+	}
+	for i, img := range images {
+		if err := zipFile(zw, pictures[i], img.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeODTBlocks пишет блоки; breakNext — первый абзац начинается с новой страницы.
+func writeODTBlocks(b *strings.Builder, blocks []docBlock, breakNext *bool) {
+	// Разрыв страницы задаётся стилем абзаца, поэтому перед таблицей
+	// или списком он выносится в отдельный пустой абзац.
+	paragraphStyle := func(base string) string {
+		if *breakNext {
+			*breakNext = false
+			return base + "_Break"
+		}
+		return base
+	}
+	standaloneBreak := func() {
+		if *breakNext {
+			*breakNext = false
+			b.WriteString(`<text:p text:style-name="Standard_Break"/>` + "\n")
+		}
+	}
+
+	for i := 0; i < len(blocks); i++ {
+		block := blocks[i]
+		switch block.Kind {
+		case docHeading:
+			fmt.Fprintf(b, `<text:h text:style-name="%s" text:outline-level="%d">%s</text:h>`+"\n",
+				paragraphStyle(fmt.Sprintf("Heading_20_%d", block.Level)), block.Level, odtRuns(block.Runs))
+		case docListItem:
+			standaloneBreak()
+			style := "L_Bullet"
+			if block.Ordered {
+				style = "L_Number"
+			}
+			fmt.Fprintf(b, `<text:list text:style-name="%s">`+"\n", style)
+			for ; i < len(blocks) && blocks[i].Kind == docListItem && blocks[i].Ordered == block.Ordered; i++ {
+				fmt.Fprintf(b, `<text:list-item><text:p text:style-name="Standard">%s</text:p></text:list-item>`+"\n", odtRuns(blocks[i].Runs))
+			}
+			i--
+			b.WriteString("</text:list>\n")
+		case docTable:
+			standaloneBreak()
+			writeODTTable(b, block.Rows)
+		case docRule:
+			fmt.Fprintf(b, `<text:p text:style-name="%s"/>`+"\n", paragraphStyle("P_Rule"))
+		default:
+			fmt.Fprintf(b, `<text:p text:style-name="%s">%s</text:p>`+"\n", paragraphStyle("Standard"), odtRuns(block.Runs))
+		}
+	}
+}
+
+func writeODTTable(b *strings.Builder, rows [][][]docRun) {
+	cols := 0
+	for _, row := range rows {
+		cols = max(cols, len(row))
+	}
+	if cols == 0 {
+		return
+	}
+
+	b.WriteString(`<table:table table:style-name="Tbl">` + "\n")
+	fmt.Fprintf(b, `<table:table-column table:number-columns-repeated="%d"/>`+"\n", cols)
+	for _, row := range rows {
+		b.WriteString("<table:table-row>")
+		for c := range cols {
+			var runs []docRun
+			if c < len(row) {
+				runs = row[c]
+			}
+			fmt.Fprintf(b, `<table:table-cell table:style-name="Tbl_Cell" office:value-type="string"><text:p text:style-name="Standard">%s</text:p></table:table-cell>`, odtRuns(runs))
+		}
+		b.WriteString("</table:table-row>\n")
+	}
+	b.WriteString("</table:table>\n")
+}
+
+func odtRuns(runs []docRun) string {
+	var b strings.Builder
+	for _, run := range runs {
+		if run.Break {
+			b.WriteString("<text:line-break/>")
+		}
+		text := odtSpaces(docEscape(run.Text))
+		switch {
+		case run.Bold && run.Italic:
+			fmt.Fprintf(&b, `<text:span text:style-name="T_BoldItalic">%s</text:span>`, text)
+		case run.Bold:
+			fmt.Fprintf(&b, `<text:span text:style-name="T_Bold">%s</text:span>`, text)
+		case run.Italic:
+			fmt.Fprintf(&b, `<text:span text:style-name="T_Italic">%s</text:span>`, text)
+		default:
+			b.WriteString(text)
+		}
+	}
+	return b.String()
+}
+
+// odtSpaces сохраняет табуляции и повторные пробелы: в ODF они
+// схлопываются, если не записаны элементами text:tab и text:s.
+func odtSpaces(s string) string {
+	s = strings.ReplaceAll(s, "\t", "<text:tab/>")
+	var b strings.Builder
+	spaces := 0
+	flush := func() {
+		if spaces > 0 {
+			b.WriteByte(' ')
+		}
+		if spaces > 1 {
+			fmt.Fprintf(&b, `<text:s text:c="%d"/>`, spaces-1)
+		}
+		spaces = 0
+	}
+	for _, r := range s {
+		if r == ' ' {
+			spaces++
+			continue
+		}
+		flush()
+		b.WriteRune(r)
+	}
+	flush()
+	return b.String()
+}
+
+func writeODTImage(b *strings.Builder, img *docImage, name string, n int, pageBreak bool) {
+	w, h := fitImage(img, odtTextW, odtTextH)
+	style := "P_Center"
+	if pageBreak {
+		style = "P_Center_Break"
+	}
+	fmt.Fprintf(b, `<text:p text:style-name="%s"><draw:frame draw:name="Image%d" text:anchor-type="as-char" svg:width="%.3fcm" svg:height="%.3fcm" draw:z-index="0">`+
+		`<draw:image xlink:href="%s" xlink:type="simple" xlink:show="embed" xlink:actuate="onLoad"/></draw:frame></text:p>`+"\n",
+		style, n, w, h, name)
+}
+
+func odtManifest(pictures []string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" manifest:version="1.2">
+<manifest:file-entry manifest:full-path="/" manifest:version="1.2" manifest:media-type="application/vnd.oasis.opendocument.text"/>
+<manifest:file-entry manifest:full-path="content.xml" manifest:media-type="text/xml"/>
+<manifest:file-entry manifest:full-path="styles.xml" manifest:media-type="text/xml"/>
+<manifest:file-entry manifest:full-path="meta.xml" manifest:media-type="text/xml"/>
+`)
+	for _, p := range pictures {
+		mime := "image/png"
+		if strings.HasSuffix(p, ".jpeg") {
+			mime = "image/jpeg"
+		}
+		fmt.Fprintf(&b, `<manifest:file-entry manifest:full-path="%s" manifest:media-type="%s"/>`+"\n", p, mime)
+	}
+	b.WriteString("</manifest:manifest>\n")
+	return b.String()
+}
+
+func odtMeta(meta Meta) string {
+	created := meta.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<office:document-meta xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:meta="urn:oasis:names:tc:opendocument:xmlns:meta:1.0" xmlns:dc="http://purl.org/dc/elements/1.1/" office:version="1.2">
+<office:meta>
+<meta:generator>ocr-history</meta:generator>
+<dc:title>%s</dc:title>
+<dc:description>%s</dc:description>
+<meta:creation-date>%s</meta:creation-date>
+</office:meta>
+</office:document-meta>
+`, docEscape(meta.Title), docEscape(meta.Summary), created.UTC().Format("2006-01-02T15:04:05"))
+}
+
+const odtNamespaces = `xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:style="urn:oasis:names:tc:opendocument:xmlns:style:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0" xmlns:draw="urn:oasis:names:tc:opendocument:xmlns:drawing:1.0" xmlns:fo="urn:oasis:names:tc:opendocument:xmlns:xsl-fo-compatible:1.0" xmlns:xlink="http://www.w3.org/1999/xlink" xmlns:svg="urn:oasis:names:tc:opendocument:xmlns:svg-compatible:1.0" office:version="1.2"`
+
+var odtContentStart = func() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content ` + odtNamespaces + `>
+<office:automatic-styles>
+<style:style style:name="T_Bold" style:family="text"><style:text-properties fo:font-weight="bold"/></style:style>
+<style:style style:name="T_Italic" style:family="text"><style:text-properties fo:font-style="italic"/></style:style>
+<style:style style:name="T_BoldItalic" style:family="text"><style:text-properties fo:font-weight="bold" fo:font-style="italic"/></style:style>
+<style:style style:name="Standard_Break" style:family="paragraph" style:parent-style-name="Standard"><style:paragraph-properties fo:break-before="page"/></style:style>
+<style:style style:name="P_Center" style:family="paragraph" style:parent-style-name="Standard"><style:paragraph-properties fo:text-align="center"/></style:style>
+<style:style style:name="P_Center_Break" style:family="paragraph" style:parent-style-name="Standard"><style:paragraph-properties fo:text-align="center" fo:break-before="page"/></style:style>
+<style:style style:name="P_Rule" style:family="paragraph" style:parent-style-name="Standard"><style:paragraph-properties fo:border-bottom="0.5pt solid #000000"/></style:style>
+<style:style style:name="P_Rule_Break" style:family="paragraph" style:parent-style-name="Standard"><style:paragraph-properties fo:border-bottom="0.5pt solid #000000" fo:break-before="page"/></style:style>
+<style:style style:name="Tbl" style:family="table"><style:table-properties style:width="17cm" table:align="margins"/></style:style>
+<style:style style:name="Tbl_Cell" style:family="table-cell"><style:table-cell-properties fo:padding="0.1cm" fo:border="0.5pt solid #000000"/></style:style>
+`)
+	for level := 1; level <= 6; level++ {
+		fmt.Fprintf(&b, `<style:style style:name="Heading_20_%[1]d_Break" style:family="paragraph" style:parent-style-name="Heading_20_%[1]d"><style:paragraph-properties fo:break-before="page"/></style:style>`+"\n", level)
+	}
+	b.WriteString(`<text:list-style style:name="L_Bullet"><text:list-level-style-bullet text:level="1" text:bullet-char="•"><style:list-level-properties text:list-level-position-and-space-mode="label-alignment"><style:list-level-label-alignment text:label-followed-by="listtab" fo:text-indent="-0.635cm" fo:margin-left="1.27cm"/></style:list-level-properties></text:list-level-style-bullet></text:list-style>
+<text:list-style style:name="L_Number"><text:list-level-style-number text:level="1" style:num-format="1" style:num-suffix="."><style:list-level-properties text:list-level-position-and-space-mode="label-alignment"><style:list-level-label-alignment text:label-followed-by="listtab" fo:text-indent="-0.635cm" fo:margin-left="1.27cm"/></style:list-level-properties></text:list-level-style-number></text:list-style>
+</office:automatic-styles>
+<office:body>
+<office:text>
+`)
+	return b.String()
+}()
+
+const odtContentEnd = `</office:text>
+</office:body>
+</office:document-content>
+`
+
+var odtStyles = func() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<office:document-styles ` + odtNamespaces + `>
+<office:styles>
+<style:default-style style:family="paragraph"><style:paragraph-properties fo:margin-bottom="0.2cm"/><style:text-properties style:font-name="Times New Roman" fo:font-family="'Times New Roman'" fo:font-size="12pt" fo:language="ru" fo:country="RU"/></style:default-style>
+<style:style style:name="Standard" style:family="paragraph" style:class="text"/>
+`)
+	sizes := []string{"16pt", "14pt", "13pt", "12pt", "12pt", "12pt"}
+	for i, size := range sizes {
+		fmt.Fprintf(&b, `<style:style style:name="Heading_20_%[1]d" style:display-name="Heading %[1]d" style:family="paragraph" style:parent-style-name="Standard" style:next-style-name="Standard" style:default-outline-level="%[1]d" style:class="text">`+
+			`<style:paragraph-properties fo:margin-top="0.4cm" fo:margin-bottom="0.2cm" fo:keep-with-next="always"/><style:text-properties fo:font-size="%[2]s" fo:font-weight="bold"/></style:style>`+"\n",
+			i+1, size)
+	}
+	b.WriteString(`</office:styles>
+<office:automatic-styles>
+<style:page-layout style:name="PL_A4"><style:page-layout-properties fo:page-width="21cm" fo:page-height="29.7cm" fo:margin-top="2cm" fo:margin-bottom="2cm" fo:margin-left="2cm" fo:margin-right="2cm"/></style:page-layout>
+</office:automatic-styles>
+<office:master-styles>
+<style:master-page style:name="Standard" style:page-layout-name="PL_A4"/>
+</office:master-styles>
+</office:document-styles>
+`)
+	return b.String()
+}()
@@ -0,0 +1,505 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/airsss993/ocr-history/internal/layout"
+)
+
+const (
+	pageNamespace = "http://schema.primaresearch.org/PAGE/gts/pagecontent/2019-07-15"
+	pageSchema    = "http://schema.primaresearch.org/PAGE/gts/pagecontent/2019-07-15/pagecontent.xsd"
+)
+
+// defaultPageSize — размер страницы в пикселях (A4, 300 dpi), если ни
+// провайдер, ни изображение его не сообщают: в PAGE XML он обязателен.
+var defaultPageSize = [2]float64{2480, 3508}
+
+// ErrNotPageXML — документ не является PAGE XML.
+var ErrNotPageXML = errors.New("not a PAGE XML document")
+
+// Элементы PAGE XML. Одни и те же структуры служат для записи и чтения;
+// поля, которые выгрузка не заполняет (вложенные и табличные регионы,
+// Point из PAGE 2010), при записи пропускаются.
+type pcGts struct {
+	XMLName        xml.Name     `xml:"PcGts"`
+	Xmlns          string       `xml:"xmlns,attr,omitempty"`
+	XmlnsXSI       string       `xml:"xmlns:xsi,attr,omitempty"`
+	SchemaLocation string       `xml:"xsi:schemaLocation,attr,omitempty"`
+	Metadata       pageMetadata `xml:"Metadata"`
+	Page           *pageElement `xml:"Page"`
+}
+
+type pageMetadata struct {
+	Creator    string `xml:"Creator"`
+	Created    string `xml:"Created"`
+	LastChange string `xml:"LastChange"`
+}
+
+type pageElement struct {
+	ImageFilename string            `xml:"imageFilename,attr"`
+	ImageWidth    int               `xml:"imageWidth,attr"`
+	ImageHeight   int               `xml:"imageHeight,attr"`
+	ReadingOrder  *pageReadingOrder `xml:"ReadingOrder"`
+	Regions       []pageTextRegion  `xml:"TextRegion"`
+	Tables        []pageTableRegion `xml:"TableRegion"`
+}
+
+type pageReadingOrder struct {
+	Group pageOrderedGroup `xml:"OrderedGroup"`
+}
+
+type pageOrderedGroup struct {
+	ID   string          `xml:"id,attr"`
+	Refs []pageRegionRef `xml:"RegionRefIndexed"`
+}
+
+type pageRegionRef struct {
+	Index     int    `xml:"index,attr"`
+	RegionRef string `xml:"regionRef,attr"`
+}
+
+type pageTableRegion struct {
+	Regions []pageTextRegion `xml:"TextRegion"`
+}
+
+type pageTextRegion struct {
+	ID        string           `xml:"id,attr"`
+	Coords    pageCoords       `xml:"Coords"`
+	Regions   []pageTextRegion `xml:"TextRegion"`
+	Lines     []pageTextLine   `xml:"TextLine"`
+	TextEquiv []pageTextEquiv  `xml:"TextEquiv"`
+}
+
+type pageTextLine struct {
+	ID        string          `xml:"id,attr"`
+	Coords    pageCoords      `xml:"Coords"`
+	Baseline  *pageCoords     `xml:"Baseline"`
+	Words     []pageWord      `xml:"Word"`
+	TextEquiv []pageTextEquiv `xml:"TextEquiv"`
+}
+
+type pageWord struct {
+	ID        string          `xml:"id,attr"`
+	Coords    pageCoords      `xml:"Coords"`
+	TextEquiv []pageTextEquiv `xml:"TextEquiv"`
+}
+
+type pageCoords struct {
+	Points string      `xml:"points,attr,omitempty"`
+	Point  []pagePoint `xml:"Point"`
+}
+
+type pagePoint struct {
+	X float64 `xml:"x,attr"`
+	Y float64 `xml:"y,attr"`
+}
+
+type pageTextEquiv struct {
+	Index   string `xml:"index,attr,omitempty"`
+	Conf    string `xml:"conf,attr,omitempty"`
+	Unicode string `xml:"Unicode"`
+}
+
+// PageXML выгружает страницу в PAGE XML 2019 (Transkribus, eScriptorium):
+// TextRegion → TextLine с Baseline → Word, у каждого Coords и TextEquiv.
+// Формат описывает одну страницу, поэтому несколько страниц пакуются
+// в ZIP (см. Format.PerPage).
+func PageXML(pages []Page) ([]byte, error) {
+	if len(pages) != 1 {
+		return nil, fmt.Errorf("PAGE XML describes a single page, got %d", len(pages))
+	}
+	page := pages[0]
+
+	lay := page.Layout
+	width, height := lay.Width, lay.Height
+	if width <= 0 || height <= 0 {
+		width, height = pageSize(page)
+	}
+	if !lay.HasBoxes() {
+		lay = withSyntheticBoxes(lay, width, height)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	doc := pcGts{
+		Xmlns:          pageNamespace,
+		XmlnsXSI:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: pageNamespace + " " + pageSchema,
+		Metadata:       pageMetadata{Creator: "ocr-history", Created: now, LastChange: now},
+		Page: &pageElement{
+			ImageFilename: page.Source,
+			ImageWidth:    round(width),
+			ImageHeight:   round(height),
+		},
+	}
+
+	order := pageOrderedGroup{ID: "ro_1"}
+	for b, block := range lay.Blocks {
+		regionID := fmt.Sprintf("r%d", b+1)
+		region := pageTextRegion{ID: regionID}
+		var regionBox layout.Box
+		var regionText []string
+
+		for l, line := range block.Lines {
+			box := lineBox(line)
+			if box.Empty() {
+				continue
+			}
+			lineID := fmt.Sprintf("%sl%d", regionID, l+1)
+			tl := pageTextLine{
+				ID:        lineID,
+				Coords:    pageCoordsOf(box),
+				Baseline:  &pageCoords{Points: pagePoints([][2]float64{{box.X0, box.Y1}, {box.X1, box.Y1}})},
+				TextEquiv: []pageTextEquiv{{Unicode: line.Text}},
+			}
+			for w, word := range distributeWords(layout.Line{Text: line.Text, Box: box, Words: lineWords(line)}) {
+				pw := pageWord{
+					ID:        fmt.Sprintf("%sw%d", lineID, w+1),
+					Coords:    pageCoordsOf(word.Box),
+					TextEquiv: []pageTextEquiv{{Unicode: word.Text}},
+				}
+				if word.Confidence > 0 {
+					pw.TextEquiv[0].Conf = strconv.FormatFloat(word.Confidence, 'f', 2, 64)
+				}
+				tl.Words = append(tl.Words, pw)
+			}
+			region.Lines = append(region.Lines, tl)
+			regionBox = regionBox.Union(box)
+			regionText = append(regionText, line.Text)
+		}
+		if len(region.Lines) == 0 {
+			continue
+		}
+
+		if !block.Box.Empty() {
+			regionBox = block.Box
+		}
+		region.Coords = pageCoordsOf(regionBox)
+		region.TextEquiv = []pageTextEquiv{{Unicode: strings.Join(regionText, "\n")}}
+		order.Refs = append(order.Refs, pageRegionRef{Index: len(order.Refs), RegionRef: regionID})
+		doc.Page.Regions = append(doc.Page.Regions, region)
+	}
+	if len(order.Refs) > 0 {
+		doc.Page.ReadingOrder = &pageReadingOrder{Group: order}
+	}
+
+	return marshalXML(doc)
+}
+
+// pageSize возвращает размер изображения страницы, а без него — A4.
+func pageSize(page Page) (float64, float64) {
+	if len(page.Image) > 0 {
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(page.Image)); err == nil && cfg.Width > 0 {
+			return float64(cfg.Width), float64(cfg.Height)
+		}
+	}
+	var box layout.Box
+	for _, block := range page.Layout.Blocks {
+		box = box.Union(blockBox(block))
+	}
+	if !box.Empty() {
+		return box.X1, box.Y1
+	}
+	return defaultPageSize[0], defaultPageSize[1]
+}
+
+// lineBox возвращает рамку строки, а если провайдер её не дал — объединение слов.
+func lineBox(line layout.Line) layout.Box {
+	if !line.Box.Empty() {
+		return line.Box
+	}
+	var box layout.Box
+	for _, word := range line.Words {
+		box = box.Union(word.Box)
+	}
+	return box
+}
+
+// distributeWords возвращает слова строки с рамками. Слова без рамок
+// раскладываются по ширине строки пропорционально числу символов.
+func distributeWords(line layout.Line) []layout.Word {
+	words := line.Words
+	withBoxes := len(words) > 0
+	for _, word := range words {
+		if word.Box.Empty() {
+			withBoxes = false
+			break
+		}
+	}
+	if withBoxes || line.Box.Empty() {
+		return words
+	}
+
+	// Пробел между словами считается за один символ.
+	total := -1
+	for _, word := range words {
+		total += utf8.RuneCountInString(word.Text) + 1
+	}
+	if total <= 0 {
+		return words
+	}
+	unit := line.Box.Width() / float64(total)
+
+	result := make([]layout.Word, len(words))
+	x := line.Box.X0
+	for i, word := range words {
+		w := float64(utf8.RuneCountInString(word.Text)) * unit
+		word.Box = layout.Box{X0: x, Y0: line.Box.Y0, X1: x + w, Y1: line.Box.Y1}
+		result[i] = word
+		x += w + unit
+	}
+	return result
+}
+
+func pageCoordsOf(b layout.Box) pageCoords {
+	return pageCoords{Points: pagePoints([][2]float64{{b.X0, b.Y0}, {b.X1, b.Y0}, {b.X1, b.Y1}, {b.X0, b.Y1}})}
+}
+
+func pagePoints(points [][2]float64) string {
+	parts := make([]string, len(points))
+	for i, p := range points {
+		parts[i] = fmt.Sprintf("%d,%d", max(round(p[0]), 0), max(round(p[1]), 0))
+	}
+	return strings.Join(parts, " ")
+}
+
+// ImportPageXML разбирает исправленную разметку: один файл PAGE XML
+// или ZIP-архив (выгрузка Transkribus, eScriptorium или наша), где
+// страницы идут в порядке имён файлов. Прочие XML в архиве
+// (mets.xml, metadata.xml) пропускаются.
+func ImportPageXML(data []byte) ([]layout.Page, error) {
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		page, err := ParsePageXML(data)
+		if err != nil {
+			return nil, err
+		}
+		return []layout.Page{page}, nil
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ZIP archive: %w", err)
+	}
+	files := make([]*zip.File, 0, len(zr.File))
+	for _, f := range zr.File {
+		if strings.EqualFold(path.Ext(f.Name), ".xml") && !f.FileInfo().IsDir() {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	var pages []layout.Page
+	for _, f := range files {
+		content, err := readZipFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		page, err := ParsePageXML(content)
+		if errors.Is(err, ErrNotPageXML) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		pages = append(pages, page)
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("archive contains no PAGE XML files")
+	}
+	return pages, nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// ParsePageXML разбирает документ PAGE XML (версии схемы 2010–2019).
+// Текст строки берётся из её TextEquiv — его правят в Transkribus, —
+// а слова строки сохраняют рамки, только если их число не изменилось.
+func ParsePageXML(data []byte) (layout.Page, error) {
+	var doc pcGts
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		var syntaxErr *xml.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return layout.Page{}, fmt.Errorf("invalid XML: %w", err)
+		}
+		return layout.Page{}, ErrNotPageXML
+	}
+	if doc.Page == nil {
+		return layout.Page{}, fmt.Errorf("PAGE XML has no Page element")
+	}
+
+	page := layout.Page{Width: float64(doc.Page.ImageWidth), Height: float64(doc.Page.ImageHeight)}
+	for _, region := range orderedRegions(doc.Page) {
+		block := layout.Block{Box: region.Coords.box()}
+		for _, tl := range region.Lines {
+			if line, ok := parsePageLine(tl); ok {
+				block.Lines = append(block.Lines, line)
+			}
+		}
+		if len(block.Lines) > 0 {
+			page.Blocks = append(page.Blocks, block)
+		}
+	}
+	return page, nil
+}
+
+// orderedRegions возвращает текстовые регионы страницы (включая вложенные
+// и ячейки таблиц) в порядке чтения; регионы вне ReadingOrder идут
+// следом в порядке документа.
+func orderedRegions(page *pageElement) []pageTextRegion {
+	var all []pageTextRegion
+	var walk func(regions []pageTextRegion)
+	walk = func(regions []pageTextRegion) {
+		for _, r := range regions {
+			all = append(all, r)
+			walk(r.Regions)
+		}
+	}
+	walk(page.Regions)
+	for _, table := range page.Tables {
+		walk(table.Regions)
+	}
+
+	if page.ReadingOrder == nil || len(page.ReadingOrder.Group.Refs) == 0 {
+		return all
+	}
+	refs := append([]pageRegionRef(nil), page.ReadingOrder.Group.Refs...)
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].Index < refs[j].Index })
+
+	byID := make(map[string]int, len(all))
+	for i, r := range all {
+		byID[r.ID] = i
+	}
+	used := make([]bool, len(all))
+	ordered := make([]pageTextRegion, 0, len(all))
+	for _, ref := range refs {
+		if i, ok := byID[ref.RegionRef]; ok && !used[i] {
+			used[i] = true
+			ordered = append(ordered, all[i])
+		}
+	}
+	for i, r := range all {
+		if !used[i] {
+			ordered = append(ordered, r)
+		}
+	}
+	return ordered
+}
+
+func parsePageLine(tl pageTextLine) (layout.Line, bool) {
+	var words []layout.Word
+	for _, pw := range tl.Words {
+		equiv, _ := textEquiv(pw.TextEquiv)
+		word := layout.Word{Text: strings.TrimSpace(equiv.Unicode), Box: pw.Coords.box()}
+		if word.Text == "" {
+			continue
+		}
+		word.Confidence, _ = strconv.ParseFloat(equiv.Conf, 64)
+		words = append(words, word)
+	}
+
+	text := ""
+	if equiv, ok := textEquiv(tl.TextEquiv); ok {
+		text = strings.TrimSpace(equiv.Unicode)
+	} else {
+		parts := make([]string, len(words))
+		for i, w := range words {
+			parts[i] = w.Text
+		}
+		text = strings.Join(parts, " ")
+	}
+	if text == "" {
+		return layout.Line{}, false
+	}
+
+	box := tl.Coords.box()
+	for _, w := range words {
+		box = box.Union(w.Box)
+	}
+	line := layout.Line{Text: text, Box: box}
+
+	fields := strings.Fields(text)
+	if len(fields) == len(words) {
+		for i, word := range words {
+			if word.Text != fields[i] {
+				word.Text, word.Confidence = fields[i], 0
+			}
+			line.Words = append(line.Words, word)
+		}
+		return line, true
+	}
+
+	// Строку правили так, что слова разошлись со старой разметкой:
+	// раскладываем новые слова по рамке строки.
+	for _, field := range fields {
+		line.Words = append(line.Words, layout.Word{Text: field})
+	}
+	line.Words = distributeWords(line)
+	return line, true
+}
+
+// textEquiv выбирает основной вариант текста — с наименьшим index.
+func textEquiv(equivs []pageTextEquiv) (pageTextEquiv, bool) {
+	if len(equivs) == 0 {
+		return pageTextEquiv{}, false
+	}
+	best, bestIndex := equivs[0], math.MaxInt
+	for _, e := range equivs {
+		index, err := strconv.Atoi(e.Index)
+		if err != nil {
+			index = 0
+		}
+		if index < bestIndex {
+			best, bestIndex = e, index
+		}
+	}
+	return best, true
+}
+
+// box возвращает рамку многоугольника из points ("x,y x,y ...") или Point.
+func (c pageCoords) box() layout.Box {
+	var points [][2]float64
+	for _, pair := range strings.Fields(c.Points) {
+		xs, ys, ok := strings.Cut(pair, ",")
+		if !ok {
+			continue
+		}
+		x, errX := strconv.ParseFloat(xs, 64)
+		y, errY := strconv.ParseFloat(ys, 64)
+		if errX == nil && errY == nil {
+			points = append(points, [2]float64{x, y})
+		}
+	}
+	for _, p := range c.Point {
+		points = append(points, [2]float64{p.X, p.Y})
+	}
+	if len(points) == 0 {
+		return layout.Box{}
+	}
+
+	box := layout.Box{X0: math.Inf(1), Y0: math.Inf(1), X1: math.Inf(-1), Y1: math.Inf(-1)}
+	for _, p := range points {
+		box.X0, box.X1 = math.Min(box.X0, p[0]), math.Max(box.X1, p[0])
+		box.Y0, box.Y1 = math.Min(box.Y0, p[1]), math.Max(box.Y1, p[1])
+	}
+	return box
+}
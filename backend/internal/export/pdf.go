@@ -0,0 +1,354 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/airsss993/ocr-history/internal/filetype"
+	"github.com/airsss993/ocr-history/internal/layout"
+	"github.com/airsss993/ocr-history/internal/multipage"
+	"github.com/airsss993/ocr-history/internal/pdf"
+	"github.com/airsss993/ocr-history/internal/preprocess"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+)
+
+// pageLongSide — длинная сторона страницы в пунктах (A4): разрешение
+// снимков неизвестно, поэтому страница масштабируется под A4.
+const pageLongSide = 842.0
+
+// glyphWidth — ширина всех глифов невидимого шрифта в долях кегля.
+const glyphWidth = 0.5
+
+// SearchablePDF собирает PDF, в котором каждая страница — исходное
+// изображение с невидимым текстовым слоем по координатам слов,
+// так что текст можно искать и выделять.
+func SearchablePDF(pages []Page) ([]byte, error) {
+	w := pdf.NewWriter()
+	fonts := newGlyphFonts(pages)
+	fontRefs := fonts.write(w)
+
+	pagesRef := w.Alloc()
+	kids := make(pdf.Array, 0, len(pages))
+	for i, page := range pages {
+		ref, err := writeSearchablePage(w, page, pagesRef, fonts, fontRefs)
+		if err != nil {
+			return nil, fmt.Errorf("page %d (%s): %w", i+1, page.Source, err)
+		}
+		kids = append(kids, ref)
+	}
+
+	w.Set(pagesRef, pdf.Dict{"Type": pdf.Name("Pages"), "Kids": kids, "Count": len(kids)})
+	root := w.Add(pdf.Dict{"Type": pdf.Name("Catalog"), "Pages": pagesRef})
+
+	return w.Finish(root, pdf.Dict{
+		"Producer":     pdf.String("ocr-history"),
+		"CreationDate": pdf.String(time.Now().UTC().Format("D:20060102150405Z")),
+	})
+}
+
+func writeSearchablePage(w *pdf.Writer, page Page, parent pdf.Ref, fonts *glyphFonts, fontRefs pdf.Dict) (pdf.Ref, error) {
+	xobject, width, height, err := imageXObject(page.Image, page.Format)
+	if err != nil {
+		return pdf.Ref{}, err
+	}
+	imageRef := w.Add(xobject)
+
+	scale := pageLongSide / float64(max(width, height))
+	pageW, pageH := float64(width)*scale, float64(height)*scale
+
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "q %s 0 0 %s 0 0 cm /Im0 Do Q\n", pdf.FormatNumber(pageW), pdf.FormatNumber(pageH))
+
+	// Координаты разметки относятся к изображению, отправленному провайдеру;
+	// если он сообщил его размер, приводим к фактическому изображению.
+	lay := page.Layout
+	if !lay.HasBoxes() {
+		lay = withSyntheticBoxes(lay, float64(width), float64(height))
+	}
+	sx, sy := scale, scale
+	if lay.Width > 0 && lay.Height > 0 {
+		sx = pageW / lay.Width
+		sy = pageH / lay.Height
+	}
+	writeTextLayer(&content, lay, fonts, sx, sy, pageH)
+
+	contentRef := w.Add(pdf.FlateStream(nil, content.Bytes()))
+	return w.Add(pdf.Dict{
+		"Type":     pdf.Name("Page"),
+		"Parent":   parent,
+		"MediaBox": pdf.Array{0, 0, pageW, pageH},
+		"Contents": contentRef,
+		"Resources": pdf.Dict{
+			"XObject": pdf.Dict{"Im0": imageRef},
+			"Font":    fontRefs,
+		},
+	}), nil
+}
+
+// writeTextLayer пишет слова в режиме отрисовки 3 (невидимый текст),
+// растягивая каждое слово по ширине его прямоугольника.
+func writeTextLayer(content *bytes.Buffer, lay layout.Page, fonts *glyphFonts, sx, sy, pageH float64) {
+	content.WriteString("BT 3 Tr\n")
+	for _, block := range lay.Blocks {
+		for _, line := range block.Lines {
+			words := line.Words
+			if len(words) == 0 || words[0].Box.Empty() {
+				words = []layout.Word{{Text: line.Text, Box: line.Box}}
+			}
+
+			for i, word := range words {
+				n := utf8.RuneCountInString(word.Text)
+				if n == 0 || word.Box.Empty() {
+					continue
+				}
+				text := word.Text
+				if i < len(words)-1 {
+					text += " " // пробел между словами для копирования и поиска
+				}
+
+				size := word.Box.Height() * sy
+				x := word.Box.X0 * sx
+				y := pageH - word.Box.Y1*sy + size*0.2 // базовая линия чуть выше нижней границы
+				hscale := 100 * word.Box.Width() * sx / (float64(n) * glyphWidth * size)
+
+				fmt.Fprintf(content, "%s Tz 1 0 0 1 %s %s Tm",
+					pdf.FormatNumber(hscale), pdf.FormatNumber(x), pdf.FormatNumber(y))
+				for _, run := range fonts.encode(text) {
+					fmt.Fprintf(content, " /F%d %s Tf <%X> Tj", run.font, pdf.FormatNumber(size), run.codes)
+				}
+				content.WriteByte('\n')
+			}
+		}
+	}
+	content.WriteString("ET\n")
+}
+
+// withSyntheticBoxes раскладывает строки без координат (например, ответ Gemini)
+// равномерно сверху вниз, чтобы текст всё равно был в документе.
+func withSyntheticBoxes(lay layout.Page, width, height float64) layout.Page {
+	var lines int
+	for _, block := range lay.Blocks {
+		lines += len(block.Lines)
+	}
+	if lines == 0 {
+		return lay
+	}
+
+	lineHeight := min(height*0.9/float64(lines), height*0.03)
+	result := layout.Page{Width: width, Height: height}
+	y := height * 0.05
+	for _, block := range lay.Blocks {
+		var out layout.Block
+		for _, line := range block.Lines {
+			box := layout.Box{X0: width * 0.05, Y0: y, X1: width * 0.95, Y1: y + lineHeight}
+			out.Lines = append(out.Lines, layout.Line{Text: line.Text, Box: box})
+			y += lineHeight
+		}
+		result.Blocks = append(result.Blocks, out)
+	}
+	return result
+}
+
+// imageXObject встраивает изображение: JPEG как есть, остальное — без потерь через Flate.
+func imageXObject(data []byte, format filetype.Format) (*pdf.Stream, int, int, error) {
+	if multipage.IsMultipage(format) {
+		pages, err := multipage.Split(data, format, 0)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if pages[0].Err != nil {
+			return nil, 0, 0, pages[0].Err
+		}
+		data, format = pages[0].Data, pages[0].Format
+	}
+
+	dict := pdf.Dict{
+		"Type":             pdf.Name("XObject"),
+		"Subtype":          pdf.Name("Image"),
+		"BitsPerComponent": 8,
+	}
+
+	if format.Name == filetype.JPEG.Name {
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to read JPEG: %w", err)
+		}
+		dict["Width"], dict["Height"] = cfg.Width, cfg.Height
+		dict["Filter"] = pdf.Name("DCTDecode")
+		switch cfg.ColorModel {
+		case color.GrayModel:
+			dict["ColorSpace"] = pdf.Name("DeviceGray")
+		case color.CMYKModel:
+			// JPEG в CMYK пишут с инвертированными каналами (Adobe).
+			dict["ColorSpace"] = pdf.Name("DeviceCMYK")
+			dict["Decode"] = pdf.Array{1, 0, 1, 0, 1, 0, 1, 0}
+		default:
+			dict["ColorSpace"] = pdf.Name("DeviceRGB")
+		}
+		return &pdf.Stream{Dict: dict, Raw: data}, cfg.Width, cfg.Height, nil
+	}
+
+	img, err := preprocess.DecodeImage(data)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	b := img.Bounds()
+	dict["Width"], dict["Height"] = b.Dx(), b.Dy()
+
+	var pixels []byte
+	if gray, ok := img.(*image.Gray); ok {
+		dict["ColorSpace"] = pdf.Name("DeviceGray")
+		pixels = make([]byte, 0, b.Dx()*b.Dy())
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			offset := gray.PixOffset(b.Min.X, y)
+			pixels = append(pixels, gray.Pix[offset:offset+b.Dx()]...)
+		}
+	} else {
+		// Прозрачные области кладём на белый фон.
+		dict["ColorSpace"] = pdf.Name("DeviceRGB")
+		pixels = make([]byte, 0, 3*b.Dx()*b.Dy())
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, g, bl, a := img.At(x, y).RGBA()
+				white := 0xffff - a
+				pixels = append(pixels, byte((r+white)>>8), byte((g+white)>>8), byte((bl+white)>>8))
+			}
+		}
+	}
+	return pdf.FlateStream(dict, pixels), b.Dx(), b.Dy(), nil
+}
+
+// glyphFonts — невидимые шрифты Type3 с пустыми глифами. Каждая руна
+// получает однобайтовый код, а таблица ToUnicode восстанавливает текст
+// при поиске и копировании. В одном шрифте не больше 255 символов.
+type glyphFonts struct {
+	codes map[rune]glyphCode
+	fonts [][]rune // руны шрифта по коду-1
+}
+
+type glyphCode struct {
+	font int
+	code byte
+}
+
+type glyphRun struct {
+	font  int
+	codes []byte
+}
+
+func newGlyphFonts(pages []Page) *glyphFonts {
+	f := &glyphFonts{codes: make(map[rune]glyphCode)}
+	f.add(' ')
+	for _, page := range pages {
+		for _, block := range page.Layout.Blocks {
+			for _, line := range block.Lines {
+				for _, r := range line.Text {
+					f.add(r)
+				}
+				for _, word := range line.Words {
+					for _, r := range word.Text {
+						f.add(r)
+					}
+				}
+			}
+		}
+	}
+	return f
+}
+
+func (f *glyphFonts) add(r rune) {
+	if _, ok := f.codes[r]; ok {
+		return
+	}
+	if len(f.fonts) == 0 || len(f.fonts[len(f.fonts)-1]) == 255 {
+		f.fonts = append(f.fonts, nil)
+	}
+	font := len(f.fonts) - 1
+	f.fonts[font] = append(f.fonts[font], r)
+	f.codes[r] = glyphCode{font: font, code: byte(len(f.fonts[font]))}
+}
+
+// encode разбивает текст на отрезки по шрифтам.
+func (f *glyphFonts) encode(text string) []glyphRun {
+	var runs []glyphRun
+	for _, r := range text {
+		gc, ok := f.codes[r]
+		if !ok {
+			continue
+		}
+		if len(runs) == 0 || runs[len(runs)-1].font != gc.font {
+			runs = append(runs, glyphRun{font: gc.font})
+		}
+		runs[len(runs)-1].codes = append(runs[len(runs)-1].codes, gc.code)
+	}
+	return runs
+}
+
+// write записывает шрифты и возвращает словарь ресурсов /Font.
+func (f *glyphFonts) write(w *pdf.Writer) pdf.Dict {
+	glyph := w.Add(&pdf.Stream{Dict: pdf.Dict{}, Raw: []byte(fmt.Sprintf("%d 0 0 0 0 0 d1", int(glyphWidth*1000)))})
+
+	refs := make(pdf.Dict, len(f.fonts))
+	for i, runes := range f.fonts {
+		procs := make(pdf.Dict, len(runes))
+		differences := pdf.Array{1}
+		widths := make(pdf.Array, len(runes))
+		for j := range runes {
+			name := pdf.Name(fmt.Sprintf("g%d", j+1))
+			procs[name] = glyph
+			differences = append(differences, name)
+			widths[j] = int(glyphWidth * 1000)
+		}
+
+		toUnicode := w.Add(pdf.FlateStream(nil, toUnicodeCMap(runes)))
+		refs[pdf.Name(fmt.Sprintf("F%d", i))] = w.Add(pdf.Dict{
+			"Type":       pdf.Name("Font"),
+			"Subtype":    pdf.Name("Type3"),
+			"FontBBox":   pdf.Array{0, 0, 0, 0},
+			"FontMatrix": pdf.Array{0.001, 0, 0, 0.001, 0, 0},
+			"CharProcs":  procs,
+			"Encoding":   pdf.Dict{"Type": pdf.Name("Encoding"), "Differences": differences},
+			"FirstChar":  1,
+			"LastChar":   len(runes),
+			"Widths":     widths,
+			"Resources":  pdf.Dict{},
+			"ToUnicode":  toUnicode,
+		})
+	}
+	return refs
+}
+
+func toUnicodeCMap(runes []rune) []byte {
+	var b strings.Builder
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<00> <FF>\nendcodespacerange\n")
+
+	// В одном блоке bfchar допускается не больше 100 записей.
+	for start := 0; start < len(runes); start += 100 {
+		end := min(start+100, len(runes))
+		fmt.Fprintf(&b, "%d beginbfchar\n", end-start)
+		for i := start; i < end; i++ {
+			fmt.Fprintf(&b, "<%02X> <", i+1)
+			for _, unit := range utf16.Encode([]rune{runes[i]}) {
+				fmt.Fprintf(&b, "%04X", unit)
+			}
+			b.WriteString(">\n")
+		}
+		b.WriteString("endbfchar\n")
+	}
+
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return []byte(b.String())
+}
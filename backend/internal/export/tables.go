@@ -0,0 +1,60 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+
+	"github.com/airsss993/ocr-history/internal/layout"
+)
+
+// PageTable — таблица вместе со страницей, на которой она найдена.
+type PageTable struct {
+	Page  Page
+	Table layout.Table
+}
+
+// Tables возвращает таблицы всех страниц по порядку.
+func Tables(pages []Page) []PageTable {
+	var tables []PageTable
+	for _, page := range pages {
+		for _, table := range page.Layout.Tables {
+			tables = append(tables, PageTable{Page: page, Table: table})
+		}
+	}
+	return tables
+}
+
+// selectTables возвращает таблицу с номером n (с 1) или все, если n = 0.
+func selectTables(pages []Page, n int) ([]PageTable, error) {
+	tables := Tables(pages)
+	switch {
+	case len(tables) == 0:
+		return nil, fmt.Errorf("no tables found in OCR results")
+	case n == 0:
+		return tables, nil
+	case n < 0 || n > len(tables):
+		return nil, fmt.Errorf("table %d not found, result has %d tables", n, len(tables))
+	}
+	return tables[n-1 : n], nil
+}
+
+// CSV выгружает одну таблицу в CSV (UTF-8 с BOM, чтобы Excel узнал
+// кодировку). Если таблиц несколько, номер нужной задаёт Options.Table.
+func CSV(pages []Page, opts Options) ([]byte, error) {
+	tables, err := selectTables(pages, opts.Table)
+	if err != nil {
+		return nil, err
+	}
+	if len(tables) > 1 {
+		return nil, fmt.Errorf("result has %d tables, choose one with table=1..%d", len(tables), len(tables))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\uFEFF")
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(tables[0].Table.Grid()); err != nil {
+		return nil, fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return buf.Bytes(), nil
+}
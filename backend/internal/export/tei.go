@@ -0,0 +1,434 @@
+package export
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const teiNamespace = "http://www.tei-c.org/ns/1.0"
+
+var (
+	// teiMarker — пометка подписи или печати в ответе Gemini: *[Подпись]*: ...
+	teiMarker = regexp.MustCompile(`\*{0,2}\[(Подпись|Печать)\]\*{0,2}:?\s*`)
+	// markdownHeading — заголовок Markdown.
+	markdownHeading = regexp.MustCompile(`^#{1,6}\s+(.*)$`)
+	// markdownListItem — пункт маркированного или нумерованного списка Markdown.
+	markdownListItem = regexp.MustCompile(`^(?:[-*+]|\d+[.)])\s+(.*)$`)
+	// markdownTableSeparator — строка-разделитель заголовка таблицы Markdown.
+	markdownTableSeparator = regexp.MustCompile(`^\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?$`)
+	// teiVariants — разделители вариантов в ⟦возможн.: а / б⟧.
+	teiVariants = regexp.MustCompile(`\s*(?:/|;|\||\sили\s)\s*`)
+)
+
+// TEI выгружает транскрипцию в TEI P5 для дипломатического издания.
+// Пометки Gemini становятся элементами TEI: *[Подпись]* — <signed>,
+// *[Печать]* — <stamp>, ⟦неразборчиво⟧ — <gap>, ⟦возможн.: ...⟧ —
+// <unclear> или <choice> из нескольких <unclear>. Разметка Markdown
+// (заголовки, списки, таблицы, выделение) переводится в <head>, <list>,
+// <table>, <hi>. teiHeader заполняется из сведений о документе (Meta).
+func TEI(pages []Page) ([]byte, error) {
+	var buf strings.Builder
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&buf, "<TEI xmlns=%q>\n", teiNamespace)
+	writeTEIHeader(&buf, pages)
+
+	body := &teiBody{}
+	for i, page := range pages {
+		body.pageBreak(i+1, page)
+		for _, block := range page.Layout.Blocks {
+			for _, line := range block.Lines {
+				body.line(line.Text)
+			}
+			body.flush()
+		}
+	}
+	body.closeDiv()
+
+	buf.WriteString("  <text>\n    <body>\n")
+	buf.WriteString(body.buf.String())
+	buf.WriteString("    </body>\n  </text>\n</TEI>\n")
+	return []byte(buf.String()), nil
+}
+
+func writeTEIHeader(buf *strings.Builder, pages []Page) {
+	meta := mergeMeta(pages)
+	title := meta.Title
+	if title == "" {
+		title = pages[0].Source
+	}
+	created := meta.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+
+	buf.WriteString("  <teiHeader>\n    <fileDesc>\n")
+	fmt.Fprintf(buf, "      <titleStmt>\n        <title>%s</title>\n      </titleStmt>\n", teiEscape(title))
+	fmt.Fprintf(buf, "      <publicationStmt>\n        <p>Транскрипция подготовлена ocr-history %s.</p>\n      </publicationStmt>\n",
+		created.Format("2006-01-02"))
+
+	if meta.Notes != "" || len(meta.Warnings) > 0 {
+		buf.WriteString("      <notesStmt>\n")
+		if meta.Notes != "" {
+			fmt.Fprintf(buf, "        <note type=\"transcription\">%s</note>\n", teiEscape(meta.Notes))
+		}
+		for _, w := range meta.Warnings {
+			fmt.Fprintf(buf, "        <note type=\"warning\">%s</note>\n", teiEscape(w))
+		}
+		buf.WriteString("      </notesStmt>\n")
+	}
+
+	buf.WriteString("      <sourceDesc>\n        <bibl>\n")
+	sources := make([]string, 0, len(pages))
+	for _, page := range pages {
+		if len(sources) == 0 || sources[len(sources)-1] != page.Source {
+			sources = append(sources, page.Source)
+		}
+	}
+	for _, source := range sources {
+		fmt.Fprintf(buf, "          <idno type=\"filename\">%s</idno>\n", teiEscape(source))
+	}
+	if meta.EntryID != "" {
+		fmt.Fprintf(buf, "          <idno type=\"history\">%s</idno>\n", teiEscape(meta.EntryID))
+	}
+	buf.WriteString("        </bibl>\n      </sourceDesc>\n    </fileDesc>\n")
+
+	buf.WriteString("    <encodingDesc>\n      <appInfo>\n")
+	buf.WriteString("        <application ident=\"ocr-history\" version=\"1\">\n          <label>ocr-history</label>\n        </application>\n")
+	buf.WriteString("      </appInfo>\n    </encodingDesc>\n")
+
+	if meta.Summary != "" || meta.Language != "" {
+		buf.WriteString("    <profileDesc>\n")
+		if meta.Summary != "" {
+			fmt.Fprintf(buf, "      <abstract>\n        <p>%s</p>\n      </abstract>\n", teiEscape(meta.Summary))
+		}
+		if meta.Language != "" {
+			fmt.Fprintf(buf, "      <langUsage>\n        <language ident=%q>%s</language>\n      </langUsage>\n",
+				languageTag(meta.Language), teiEscape(meta.Language))
+		}
+		buf.WriteString("    </profileDesc>\n")
+	}
+
+	fmt.Fprintf(buf, "    <revisionDesc>\n      <change when=%q>Автоматическое распознавание</change>\n    </revisionDesc>\n",
+		created.Format("2006-01-02"))
+	buf.WriteString("  </teiHeader>\n")
+}
+
+// mergeMeta собирает сведения о документе со всех страниц: первые
+// непустые заголовок, язык и описание, все заметки и предупреждения.
+func mergeMeta(pages []Page) Meta {
+	var meta Meta
+	var notes []string
+	for _, page := range pages {
+		m := page.Meta
+		if meta.EntryID == "" {
+			meta.EntryID = m.EntryID
+		}
+		if meta.Created.IsZero() {
+			meta.Created = m.Created
+		}
+		if meta.Title == "" {
+			meta.Title = m.Title
+		}
+		if meta.Language == "" {
+			meta.Language = m.Language
+		}
+		if meta.Summary == "" {
+			meta.Summary = m.Summary
+		}
+		if m.Notes != "" {
+			notes = append(notes, m.Notes)
+		}
+		meta.Warnings = append(meta.Warnings, m.Warnings...)
+	}
+	meta.Notes = strings.Join(notes, "\n")
+	return meta
+}
+
+// languageTag приводит описание языка от Gemini («русский, дореформенная
+// орфография») к коду для атрибута ident.
+func languageTag(language string) string {
+	l := strings.ToLower(language)
+	switch {
+	case strings.Contains(l, "рус"), strings.Contains(l, "russian"):
+		return "ru"
+	case strings.Contains(l, "нем"), strings.Contains(l, "german"):
+		return "de"
+	case strings.Contains(l, "франц"), strings.Contains(l, "french"):
+		return "fr"
+	case strings.Contains(l, "латин"), strings.Contains(l, "latin"):
+		return "la"
+	case strings.Contains(l, "польск"), strings.Contains(l, "polish"):
+		return "pl"
+	case strings.Contains(l, "англ"), strings.Contains(l, "english"):
+		return "en"
+	}
+	return "und"
+}
+
+// teiBody собирает <body>. Текст идёт в <div>; подписи и печати в конце
+// фрагмента — в <closer>, после которого по схеме TEI в том же <div>
+// текста быть не может, поэтому дальнейший текст открывает новый <div>.
+type teiBody struct {
+	buf        strings.Builder
+	inDiv      bool
+	hasContent bool // в <div> уже есть основной текст
+	closed     bool // <div> завершён блоком <closer>
+	inCloser   bool
+
+	para  []string   // строки текущего абзаца
+	list  []string   // пункты текущего списка
+	table [][]string // строки текущей таблицы
+}
+
+func (t *teiBody) pageBreak(n int, page Page) {
+	t.flush()
+	t.closeDiv()
+	facs := page.Source
+	if page.Number > 0 {
+		facs = fmt.Sprintf("%s#page=%d", page.Source, page.Number)
+	}
+	fmt.Fprintf(&t.buf, "      <pb n=\"%d\" facs=\"%s\"/>\n", n, teiEscape(facs))
+}
+
+func (t *teiBody) line(text string) {
+	text = strings.TrimSpace(text)
+
+	if loc := teiMarker.FindStringSubmatchIndex(text); loc != nil {
+		if before := strings.TrimSpace(text[:loc[0]]); before != "" {
+			t.line(before)
+		}
+		kind, rest := text[loc[2]:loc[3]], strings.TrimSpace(text[loc[1]:])
+		t.flush()
+		if kind == "Подпись" {
+			t.closer(teiElement("signed", rest))
+		} else if t.inCloser {
+			t.closer(teiElement("stamp", rest))
+		} else {
+			t.common("<p>" + teiElement("stamp", rest) + "</p>")
+		}
+		return
+	}
+
+	if t.inCloser {
+		t.endCloser()
+	}
+
+	switch {
+	case text == "---" || text == "***" || text == "___":
+		// Gemini разделяет так фрагменты документа.
+		t.flush()
+		t.closeDiv()
+	case markdownHeading.MatchString(text):
+		t.flush()
+		if t.hasContent || t.closed {
+			t.closeDiv()
+		}
+		t.openDiv()
+		fmt.Fprintf(&t.buf, "        <head>%s</head>\n", teiInline(markdownHeading.FindStringSubmatch(text)[1]))
+	case strings.HasPrefix(text, "|"):
+		if len(t.para) > 0 || len(t.list) > 0 {
+			t.flush()
+		}
+		if !markdownTableSeparator.MatchString(text) {
+			t.table = append(t.table, tableCells(text))
+		}
+	case markdownListItem.MatchString(text):
+		if len(t.para) > 0 || len(t.table) > 0 {
+			t.flush()
+		}
+		t.list = append(t.list, teiInline(markdownListItem.FindStringSubmatch(text)[1]))
+	default:
+		if len(t.list) > 0 || len(t.table) > 0 {
+			t.flush()
+		}
+		t.para = append(t.para, teiInline(text))
+	}
+}
+
+// flush записывает накопленный абзац, список или таблицу.
+func (t *teiBody) flush() {
+	switch {
+	case len(t.para) > 0:
+		t.common("<p>" + strings.Join(t.para, "<lb/>\n          ") + "</p>")
+		t.para = nil
+	case len(t.list) > 0:
+		var b strings.Builder
+		b.WriteString("<list>\n")
+		for _, item := range t.list {
+			fmt.Fprintf(&b, "          <item>%s</item>\n", item)
+		}
+		b.WriteString("        </list>")
+		t.common(b.String())
+		t.list = nil
+	case len(t.table) > 0:
+		var b strings.Builder
+		b.WriteString("<table>\n")
+		for _, row := range t.table {
+			b.WriteString("          <row>")
+			for _, cell := range row {
+				fmt.Fprintf(&b, "<cell>%s</cell>", teiInline(cell))
+			}
+			b.WriteString("</row>\n")
+		}
+		b.WriteString("        </table>")
+		t.common(b.String())
+		t.table = nil
+	}
+}
+
+// common пишет элемент основного текста фрагмента.
+func (t *teiBody) common(element string) {
+	if t.inCloser {
+		t.endCloser()
+	}
+	if t.closed {
+		t.closeDiv()
+	}
+	t.openDiv()
+	t.buf.WriteString("        " + element + "\n")
+	t.hasContent = true
+}
+
+// closer добавляет подпись или печать в <closer> фрагмента.
+func (t *teiBody) closer(element string) {
+	if t.closed {
+		t.closeDiv()
+	}
+	if !t.inCloser {
+		t.openDiv()
+		if !t.hasContent {
+			// <closer> по схеме не может быть единственным содержимым <div>.
+			t.buf.WriteString("        <p/>\n")
+			t.hasContent = true
+		}
+		t.buf.WriteString("        <closer>\n")
+		t.inCloser = true
+	}
+	t.buf.WriteString("          " + element + "\n")
+}
+
+func (t *teiBody) endCloser() {
+	t.buf.WriteString("        </closer>\n")
+	t.inCloser, t.closed = false, true
+}
+
+func (t *teiBody) openDiv() {
+	if !t.inDiv {
+		t.buf.WriteString("      <div>\n")
+		t.inDiv = true
+	}
+}
+
+func (t *teiBody) closeDiv() {
+	if t.inCloser {
+		t.endCloser()
+	}
+	if !t.inDiv {
+		return
+	}
+	if !t.hasContent {
+		// Фрагмент из одного заголовка.
+		t.buf.WriteString("        <p/>\n")
+	}
+	t.buf.WriteString("      </div>\n")
+	t.inDiv, t.hasContent, t.closed = false, false, false
+}
+
+func tableCells(row string) []string {
+	row = strings.TrimSpace(row)
+	row = strings.TrimSuffix(strings.TrimPrefix(row, "|"), "|")
+	cells := strings.Split(row, "|")
+	for i, cell := range cells {
+		cells[i] = strings.TrimSpace(cell)
+	}
+	return cells
+}
+
+// teiElement оборачивает строку с inline-разметкой в элемент; пустая — пустой элемент.
+func teiElement(name, text string) string {
+	if text == "" {
+		return "<" + name + "/>"
+	}
+	return "<" + name + ">" + teiInline(text) + "</" + name + ">"
+}
+
+// teiInline переводит пометки ⟦...⟧ и выделение Markdown внутри строки.
+func teiInline(s string) string {
+	var b strings.Builder
+	for s != "" {
+		switch {
+		case strings.HasPrefix(s, "⟦"):
+			end := strings.Index(s, "⟧")
+			if end < 0 {
+				b.WriteString(teiEscape(s))
+				return b.String()
+			}
+			b.WriteString(teiUncertain(strings.TrimSpace(s[len("⟦"):end])))
+			s = s[end+len("⟧"):]
+		case strings.HasPrefix(s, "**"):
+			end := strings.Index(s[2:], "**")
+			if end <= 0 {
+				b.WriteString("**")
+				s = s[2:]
+				continue
+			}
+			b.WriteString(`<hi rend="bold">` + teiInline(s[2:2+end]) + "</hi>")
+			s = s[4+end:]
+		case strings.HasPrefix(s, "*"):
+			end := strings.Index(s[1:], "*")
+			if end <= 0 {
+				b.WriteString("*")
+				s = s[1:]
+				continue
+			}
+			b.WriteString(`<hi rend="italic">` + teiInline(s[1:1+end]) + "</hi>")
+			s = s[2+end:]
+		default:
+			next := strings.IndexAny(s, "⟦*")
+			if next < 0 {
+				next = len(s)
+			}
+			b.WriteString(teiEscape(s[:next]))
+			s = s[next:]
+		}
+	}
+	return b.String()
+}
+
+// teiUncertain переводит содержимое ⟦...⟧: неразборчивое место — <gap>,
+// предполагаемое чтение — <unclear>, несколько вариантов — <choice>.
+func teiUncertain(inner string) string {
+	lower := strings.ToLower(inner)
+	if strings.HasPrefix(lower, "возможн") {
+		variants := ""
+		if _, after, ok := strings.Cut(inner, ":"); ok {
+			variants = after
+		} else if i := strings.IndexAny(inner, " ."); i >= 0 {
+			variants = inner[i+1:]
+		}
+		var readings []string
+		for _, v := range teiVariants.Split(strings.TrimSpace(variants), -1) {
+			if v = strings.TrimSpace(v); v != "" {
+				readings = append(readings, "<unclear>"+teiEscape(v)+"</unclear>")
+			}
+		}
+		switch len(readings) {
+		case 0:
+			return `<gap reason="illegible"/>`
+		case 1:
+			return readings[0]
+		}
+		return "<choice>" + strings.Join(readings, "") + "</choice>"
+	}
+	if strings.Contains(lower, "неразборчив") {
+		return `<gap reason="illegible"/>`
+	}
+	return "<unclear>" + teiEscape(inner) + "</unclear>"
+}
+
+func teiEscape(s string) string {
+	return html.EscapeString(s)
+}
@@ -0,0 +1,145 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// xlsxNumber — текст ячейки, который можно записать числом без потерь
+// (без ведущих нулей и с точкой в дробной части).
+var xlsxNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]{0,14})(\.[0-9]+)?$`)
+
+// XLSX выгружает таблицы в книгу Excel: по листу на таблицу, объединённые
+// ячейки сохраняются. Options.Table оставляет одну таблицу.
+func XLSX(pages []Page, opts Options) ([]byte, error) {
+	tables, err := selectTables(pages, opts.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	var sheets, rels, overrides strings.Builder
+	for i, t := range tables {
+		n := i + 1
+		number := n
+		if opts.Table > 0 {
+			number = opts.Table
+		}
+		fmt.Fprintf(&sheets, `<sheet name="Таблица %d" sheetId="%d" r:id="rId%d"/>`, number, n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`+"\n", n, n)
+		fmt.Fprintf(&overrides, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`+"\n", n)
+		if err := zipFile(zw, fmt.Sprintf("xl/worksheets/sheet%d.xml", n), []byte(xlsxSheet(t))); err != nil {
+			return nil, err
+		}
+	}
+
+	files := []struct {
+		name string
+		data string
+	}{
+		{"[Content_Types].xml", fmt.Sprintf(xlsxContentTypes, overrides.String())},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, sheets.String())},
+		{"xl/_rels/workbook.xml.rels", fmt.Sprintf(xlsxWorkbookRels, rels.String(), len(tables)+1)},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, f := range files {
+		if err := zipFile(zw, f.name, []byte(f.data)); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func xlsxSheet(t PageTable) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+`)
+	for r, row := range t.Table.Grid() {
+		fmt.Fprintf(&b, `<row r="%d">`, r+1)
+		for c, text := range row {
+			if text == "" {
+				continue
+			}
+			ref := xlsxCell(r, c)
+			if xlsxNumber.MatchString(text) {
+				fmt.Fprintf(&b, `<c r="%s"><v>%s</v></c>`, ref, text)
+				continue
+			}
+			fmt.Fprintf(&b, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, docEscape(text))
+		}
+		b.WriteString("</row>\n")
+	}
+	b.WriteString("</sheetData>")
+
+	var merges []string
+	for _, cell := range t.Table.Cells {
+		if cell.RowSpan > 1 || cell.ColSpan > 1 {
+			merges = append(merges, xlsxCell(cell.Row, cell.Column)+":"+xlsxCell(cell.Row+cell.RowSpan-1, cell.Column+cell.ColSpan-1))
+		}
+	}
+	if len(merges) > 0 {
+		fmt.Fprintf(&b, `<mergeCells count="%d">`, len(merges))
+		for _, ref := range merges {
+			fmt.Fprintf(&b, `<mergeCell ref="%s"/>`, ref)
+		}
+		b.WriteString("</mergeCells>")
+	}
+	b.WriteString("</worksheet>\n")
+	return b.String()
+}
+
+// xlsxCell возвращает адрес ячейки в нотации A1 (строки и столбцы с 0).
+func xlsxCell(row, col int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+	return name + strconv.Itoa(row+1)
+}
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+%s</Types>
+`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>
+`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>%s</sheets></workbook>
+`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+%s<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>
+`
+
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/></cellXfs>
+<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>
+</styleSheet>
+`
@@ -0,0 +1,98 @@
+// Package filetype определяет формат загруженного файла по сигнатуре
+// (magic bytes), не доверяя расширению имени файла.
+package filetype
+
+import (
+	"bytes"
+	"strings"
+)
+
+type Format struct {
+	Name       string   // короткое имя: "jpeg", "png", ...
+	MIME       string   // MIME-тип, который передаётся провайдерам
+	Extensions []string // допустимые расширения без точки
+}
+
+var (
+	JPEG = Format{Name: "jpeg", MIME: "image/jpeg", Extensions: []string{"jpg", "jpeg", "jpe", "jfif"}}
+	PNG  = Format{Name: "png", MIME: "image/png", Extensions: []string{"png"}}
+	WebP = Format{Name: "webp", MIME: "image/webp", Extensions: []string{"webp"}}
+	GIF  = Format{Name: "gif", MIME: "image/gif", Extensions: []string{"gif"}}
+	TIFF = Format{Name: "tiff", MIME: "image/tiff", Extensions: []string{"tif", "tiff"}}
+	HEIC = Format{Name: "heic", MIME: "image/heic", Extensions: []string{"heic", "heif"}}
+	BMP  = Format{Name: "bmp", MIME: "image/bmp", Extensions: []string{"bmp"}}
+	PDF  = Format{Name: "pdf", MIME: "application/pdf", Extensions: []string{"pdf"}}
+
+	formats = []Format{JPEG, PNG, WebP, GIF, TIFF, HEIC, BMP, PDF}
+)
+
+// heicBrands — major brand в ftyp-боксе контейнера HEIF.
+var heicBrands = []string{"heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1"}
+
+// Detect определяет формат по первым байтам содержимого.
+func Detect(data []byte) (Format, bool) {
+	switch {
+	case len(data) >= 3 && data[0] == 0xFF && data[1] == 0xD8 && data[2] == 0xFF:
+		return JPEG, true
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return PNG, true
+	case len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return WebP, true
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return GIF, true
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return TIFF, true
+	case isHEIC(data):
+		return HEIC, true
+	case len(data) >= 14 && data[0] == 'B' && data[1] == 'M':
+		return BMP, true
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return PDF, true
+	}
+	return Format{}, false
+}
+
+func isHEIC(data []byte) bool {
+	if len(data) < 12 || !bytes.Equal(data[4:8], []byte("ftyp")) {
+		return false
+	}
+	brand := string(data[8:12])
+	for _, b := range heicBrands {
+		if brand == b {
+			return true
+		}
+	}
+	return false
+}
+
+// ByExtension возвращает формат по расширению (с точкой или без).
+func ByExtension(ext string) (Format, bool) {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	for _, f := range formats {
+		if f.HasExtension(ext) {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// HasExtension сообщает, относится ли расширение к формату.
+func (f Format) HasExtension(ext string) bool {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	for _, e := range f.Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// ByMIME возвращает формат по MIME-типу.
+func ByMIME(mime string) (Format, bool) {
+	for _, f := range formats {
+		if f.MIME == mime {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
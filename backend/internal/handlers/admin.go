@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/auth"
+	"github.com/airsss993/ocr-history/internal/config"
+	"github.com/airsss993/ocr-history/internal/domain"
+	"github.com/airsss993/ocr-history/internal/ratelimiter"
+	"github.com/airsss993/ocr-history/internal/services"
+	"github.com/airsss993/ocr-history/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+type workerSlots struct {
+	InUse    int `json:"in_use"`
+	Capacity int `json:"capacity"`
+}
+
+type providerState struct {
+	Name       string                    `json:"name"`
+	Configured bool                      `json:"configured"`
+	Workers    workerSlots               `json:"workers"`
+	InFlight   int                       `json:"in_flight_jobs"`
+	Limiter    *ratelimiter.LimiterState `json:"limiter,omitempty"`
+}
+
+func (h *Handler) initAdminRoutes(admin *gin.RouterGroup) {
+	admin.GET("/clients", h.handleAdminListClients)
+	admin.DELETE("/clients/:id", h.handleAdminPurgeClient)
+
+	admin.GET("/jobs", h.handleAdminListJobs)
+	admin.GET("/providers", h.handleAdminProviders)
+	admin.GET("/ready", h.handleAdminReadiness)
+
+	admin.POST("/cache/expire", h.handleAdminExpireCache)
+
+	admin.GET("/keys", h.handleAdminListKeys)
+	admin.POST("/keys", h.handleAdminCreateKey)
+	admin.POST("/keys/:id/rotate", h.handleAdminRotateKey)
+	admin.DELETE("/keys/:id", h.handleAdminRevokeKey)
+}
+
+func (h *Handler) ocrServices() []*services.OCRService {
+	return []*services.OCRService{h.geminiService, h.yandexService}
+}
+
+func (h *Handler) handleAdminListClients(c *gin.Context) {
+	stats := h.historyStorage.Stats()
+	c.JSON(http.StatusOK, gin.H{
+		"clients": stats,
+		"total":   len(stats),
+	})
+}
+
+// handleAdminPurgeClient удаляет историю клиента и сбрасывает его квоты.
+func (h *Handler) handleAdminPurgeClient(c *gin.Context) {
+	clientID := c.Param("id")
+
+	h.historyStorage.Clear(clientID)
+	if h.quota != nil {
+		h.quota.Reset(clientID)
+	}
+
+	logger.InfoCtx(c.Request.Context(), "admin purged client "+clientID)
+	c.JSON(http.StatusOK, gin.H{
+		"purged": clientID,
+	})
+}
+
+func (h *Handler) handleAdminListJobs(c *gin.Context) {
+	jobs := []services.JobInfo{}
+	workers := make(map[string]workerSlots)
+
+	for _, svc := range h.ocrServices() {
+		jobs = append(jobs, svc.Jobs()...)
+		inUse, capacity := svc.WorkerSlots()
+		workers[svc.Provider()] = workerSlots{InUse: inUse, Capacity: capacity}
+	}
+
+	interrupted := h.interrupted
+	if interrupted == nil {
+		interrupted = []services.JobInfo{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":        jobs,
+		"workers":     workers,
+		"interrupted": interrupted,
+	})
+}
+
+func (h *Handler) handleAdminProviders(c *gin.Context) {
+	providers := make([]providerState, 0, len(h.ocrServices()))
+	for _, svc := range h.ocrServices() {
+		inUse, capacity := svc.WorkerSlots()
+		state := providerState{
+			Name:       svc.Provider(),
+			Configured: h.conf().OCR.ProviderEnabled(svc.Provider()),
+			Workers:    workerSlots{InUse: inUse, Capacity: capacity},
+			InFlight:   len(svc.Jobs()),
+		}
+		if svc.Provider() == config.ProviderYandex {
+			limiter := h.yandexRateLimiter.State()
+			state.Limiter = &limiter
+		}
+		providers = append(providers, state)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"providers": providers,
+	})
+}
+
+// handleAdminExpireCache принудительно вытесняет историю клиентов, неактивных дольше idle.
+func (h *Handler) handleAdminExpireCache(c *gin.Context) {
+	idle, err := time.ParseDuration(c.Query("idle"))
+	if err != nil || idle < 0 {
+		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+			Error:   "validation_error",
+			Message: "query parameter idle must be a duration, e.g. idle=30m (idle=0s expires everything)",
+		})
+		return
+	}
+
+	removed := h.historyStorage.Expire(idle)
+	c.JSON(http.StatusOK, gin.H{
+		"expired_clients": removed,
+	})
+}
+
+func (h *Handler) handleAdminListKeys(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"keys": h.keys.List(),
+	})
+}
+
+type CreateKeyRequest struct {
+	Owner  string   `json:"owner"`
+	Scopes []string `json:"scopes"`
+	TTL    string   `json:"ttl,omitempty"` // например "720h"; пусто — бессрочно
+}
+
+func (h *Handler) handleAdminCreateKey(c *gin.Context) {
+	var req CreateKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+			Error:   "validation_error",
+			Message: "invalid request body, scopes are required",
+		})
+		return
+	}
+
+	var ttl time.Duration
+	if req.TTL != "" {
+		parsed, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+				Error:   "validation_error",
+				Message: "invalid ttl",
+			})
+			return
+		}
+		ttl = parsed
+	}
+
+	raw, key, err := h.keys.Generate(req.Owner, req.Scopes, ttl)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"key":     raw,
+		"details": key,
+	})
+}
+
+func (h *Handler) handleAdminRotateKey(c *gin.Context) {
+	grace := time.Hour
+	if v := c.Query("grace"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+				Error:   "validation_error",
+				Message: "invalid grace",
+			})
+			return
+		}
+		grace = parsed
+	}
+
+	raw, key, err := h.keys.Rotate(c.Param("id"), grace)
+	if err != nil {
+		h.keyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"key":     raw,
+		"details": key,
+	})
+}
+
+func (h *Handler) handleAdminRevokeKey(c *gin.Context) {
+	if err := h.keys.Revoke(c.Param("id")); err != nil {
+		h.keyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revoked": c.Param("id"),
+	})
+}
+
+func (h *Handler) keyError(c *gin.Context, err error) {
+	if errors.Is(err, auth.ErrKeyNotFound) {
+		c.JSON(http.StatusNotFound, domain.ErrorResponse{
+			Error:   "not_found",
+			Message: err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, auth.ErrConfigKey) {
+		c.JSON(http.StatusConflict, domain.ErrorResponse{
+			Error:   "config_key",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	logger.ErrorCtx(c.Request.Context(), err)
+	c.JSON(http.StatusInternalServerError, domain.ErrorResponse{
+		Error:   "internal_server_error",
+		Message: "failed to update api keys",
+	})
+}
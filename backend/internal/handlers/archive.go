@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/domain"
+	"github.com/airsss993/ocr-history/internal/export"
+	"github.com/airsss993/ocr-history/internal/filetype"
+	"github.com/airsss993/ocr-history/internal/storage"
+	"github.com/airsss993/ocr-history/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// archiveVersion — версия формата архива истории.
+const archiveVersion = 1
+
+// archiveManifest — оглавление архива истории (manifest.json).
+type archiveManifest struct {
+	Version    int            `json:"version"`
+	ExportedAt time.Time      `json:"exported_at"`
+	Formats    []string       `json:"formats,omitempty"`
+	Entries    []archiveEntry `json:"entries"`
+}
+
+// archiveEntry описывает запись истории; пути указаны относительно корня архива.
+type archiveEntry struct {
+	ID           string            `json:"id"`
+	CreatedAt    time.Time         `json:"created_at"`
+	Image        string            `json:"image,omitempty"`
+	ImageSHA256  string            `json:"image_sha256,omitempty"`
+	Result       string            `json:"result"`
+	Exports      map[string]string `json:"exports,omitempty"`
+	ExportErrors map[string]string `json:"export_errors,omitempty"`
+}
+
+// archiveEntryID — допустимый ID записи: он становится частью имён файлов.
+var archiveEntryID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// handleExportHistory отдаёт всю историю клиента ZIP-архивом: manifest.json,
+// изображения (images/), результаты OCR (results/) и выгрузки в форматах
+// из параметра formats (exports/), например formats=pdf,tei.
+func (h *Handler) handleExportHistory(c *gin.Context) {
+	owner, ok := h.historyOwner(c)
+	if !ok {
+		return
+	}
+
+	var formats []export.Format
+	for _, name := range strings.Split(c.Query("formats"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "json" {
+			continue
+		}
+		format, ok := export.Lookup(name)
+		if !ok {
+			c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+				Error:   "validation_error",
+				Message: fmt.Sprintf("unsupported format %q, expected one of: %s", name, strings.Join(export.Names(), ", ")),
+			})
+			return
+		}
+		formats = append(formats, format)
+	}
+	opts, ok := exportOptions(c)
+	if !ok {
+		return
+	}
+
+	entries := h.historyStorage.Get(owner)
+	maxPages := h.conf().OCR.MaxPagesPerFile
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="history-%s.zip"`, time.Now().Format("20060102-150405")))
+	c.Status(http.StatusOK)
+
+	if err := writeHistoryArchive(c.Writer, entries, formats, opts, maxPages); err != nil {
+		// Заголовки уже отправлены: остаётся оборвать архив и записать ошибку в лог.
+		logger.ErrorCtx(c.Request.Context(), fmt.Errorf("history archive export failed: %w", err))
+	}
+}
+
+// writeHistoryArchive пишет архив потоком; manifest.json идёт последним,
+// когда известны ошибки выгрузок.
+func writeHistoryArchive(w io.Writer, entries []storage.HistoryEntry, formats []export.Format, opts export.Options, maxPages int) error {
+	zw := zip.NewWriter(w)
+	manifest := archiveManifest{
+		Version:    archiveVersion,
+		ExportedAt: time.Now().UTC(),
+		Entries:    make([]archiveEntry, 0, len(entries)),
+	}
+	for _, format := range formats {
+		manifest.Formats = append(manifest.Formats, format.Name)
+	}
+
+	for i, entry := range entries {
+		id := entry.ID
+		if !archiveEntryID.MatchString(id) {
+			id = strconv.Itoa(i + 1)
+		}
+		item := archiveEntry{ID: entry.ID, CreatedAt: entry.CreatedAt, Result: "results/" + id + ".json"}
+
+		img, format, err := historyImage(entry)
+		if err != nil {
+			// Повреждённое изображение не должно мешать сохранить остальное.
+			item.ExportErrors = map[string]string{"image": err.Error()}
+		}
+		if img != nil {
+			item.Image = "images/" + id + "." + format.Extensions[0]
+			item.ImageSHA256 = imageHash(img)
+			if err := archiveFile(zw, item.Image, img, zip.Store); err != nil {
+				return err
+			}
+		}
+		result := entry.OcrResult
+		if len(result) == 0 {
+			result = json.RawMessage("null")
+		}
+		if err := archiveFile(zw, item.Result, result, zip.Deflate); err != nil {
+			return err
+		}
+
+		if len(formats) > 0 {
+			pages, pagesErr := historyPages(entry, maxPages)
+			for _, f := range formats {
+				file, err := export.File{}, pagesErr
+				if err == nil {
+					file, err = f.Write(pages, opts)
+				}
+				if err != nil {
+					if item.ExportErrors == nil {
+						item.ExportErrors = map[string]string{}
+					}
+					item.ExportErrors[f.Name] = err.Error()
+					continue
+				}
+				if item.Exports == nil {
+					item.Exports = map[string]string{}
+				}
+				item.Exports[f.Name] = "exports/" + id + "." + f.Name + "." + file.Extension
+				if err := archiveFile(zw, item.Exports[f.Name], file.Data, zip.Deflate); err != nil {
+					return err
+				}
+			}
+		}
+
+		manifest.Entries = append(manifest.Entries, item)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := archiveFile(zw, "manifest.json", data, zip.Deflate); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func archiveFile(zw *zip.Writer, name string, data []byte, method uint16) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func imageHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// handleImportHistory восстанавливает историю из архива handleExportHistory.
+// Архив проверяется целиком до записи; записи, изображение которых уже есть
+// в истории (по SHA-256), пропускаются.
+func (h *Handler) handleImportHistory(c *gin.Context) {
+	owner, ok := h.historyOwner(c)
+	if !ok {
+		return
+	}
+
+	cfg := h.conf().OCR
+	// Архив не больше одного пакета OCR максимального размера.
+	limit := int64(cfg.MaxImageSizeMB) * int64(max(cfg.MaxImagesPerRequest, 1)) << 20
+	data, err := importBody(c, limit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Изображения лежат в архиве без сжатия, поэтому распакованное содержимое
+	// честного архива ненамного больше его самого.
+	entries, err := readHistoryArchive(data, int64(cfg.MaxImageSizeMB)<<20, 2*limit)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, domain.ErrorResponse{
+			Error:   "import_failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	existing := h.historyStorage.Get(owner)
+	hashes := make(map[string]bool, len(existing))
+	ids := make(map[string]bool, len(existing))
+	for _, entry := range existing {
+		ids[entry.ID] = true
+		if img, _, err := historyImage(entry); err == nil && img != nil {
+			hashes[imageHash(img)] = true
+		}
+	}
+
+	type skipped struct {
+		ID     string `json:"id"`
+		Reason string `json:"reason"`
+	}
+	var (
+		imported []storage.HistoryEntry
+		skips    = []skipped{}
+	)
+	for _, entry := range entries {
+		if entry.hash != "" && hashes[entry.hash] {
+			skips = append(skips, skipped{ID: entry.ID, Reason: "duplicate image"})
+			continue
+		}
+		if entry.hash == "" && ids[entry.ID] {
+			skips = append(skips, skipped{ID: entry.ID, Reason: "entry already exists"})
+			continue
+		}
+		if ids[entry.ID] {
+			entry.ID = strconv.FormatInt(time.Now().UnixNano(), 10)
+		}
+		if entry.hash != "" {
+			hashes[entry.hash] = true
+		}
+		ids[entry.ID] = true
+		imported = append(imported, entry.HistoryEntry)
+	}
+
+	if len(imported) > 0 {
+		token, err := h.historyStorage.Import(owner, imported)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, domain.ErrorResponse{
+				Error:   "import_failed",
+				Message: err.Error(),
+			})
+			return
+		}
+		h.setClaimToken(c, token)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"imported": len(imported),
+		"skipped":  skips,
+	})
+}
+
+// archivedEntry — запись из архива вместе с хешем её изображения.
+type archivedEntry struct {
+	storage.HistoryEntry
+	hash string
+}
+
+// readHistoryArchive разбирает и проверяет архив истории. fileLimit
+// ограничивает распакованный размер каждого файла, totalLimit — всех
+// прочитанных файлов вместе. Каждый файл архива читается не больше одного раза.
+func readHistoryArchive(data []byte, fileLimit, totalLimit int64) ([]archivedEntry, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("file is not a ZIP archive")
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[path.Clean(f.Name)] = f
+	}
+
+	used := make(map[string]bool, len(zr.File))
+	remaining := totalLimit
+	read := func(name string) ([]byte, error) {
+		name = path.Clean(name)
+		f, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("%s is missing from the archive", name)
+		}
+		if used[name] {
+			return nil, fmt.Errorf("%s is referenced more than once", name)
+		}
+		used[name] = true
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		defer rc.Close()
+		limit := min(fileLimit, remaining)
+		body, err := io.ReadAll(io.LimitReader(rc, limit+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if int64(len(body)) > fileLimit {
+			return nil, fmt.Errorf("%s exceeds %d MB", name, fileLimit>>20)
+		}
+		if int64(len(body)) > remaining {
+			return nil, fmt.Errorf("archive content exceeds %d MB when unpacked", totalLimit>>20)
+		}
+		remaining -= int64(len(body))
+		return body, nil
+	}
+
+	raw, err := read("manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	var manifest archiveManifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return nil, fmt.Errorf("manifest.json is not valid JSON: %w", err)
+	}
+	if manifest.Version != archiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d, expected %d", manifest.Version, archiveVersion)
+	}
+	if len(manifest.Entries) > storage.MaxImportedEntries {
+		return nil, fmt.Errorf("archive has %d entries, maximum is %d", len(manifest.Entries), storage.MaxImportedEntries)
+	}
+
+	entries := make([]archivedEntry, 0, len(manifest.Entries))
+	seen := make(map[string]bool, len(manifest.Entries))
+	for i, item := range manifest.Entries {
+		if !archiveEntryID.MatchString(item.ID) {
+			return nil, fmt.Errorf("entry %d: invalid id %q", i+1, item.ID)
+		}
+		if seen[item.ID] {
+			return nil, fmt.Errorf("entry %s: duplicate id", item.ID)
+		}
+		seen[item.ID] = true
+		if item.CreatedAt.IsZero() {
+			return nil, fmt.Errorf("entry %s: created_at is required", item.ID)
+		}
+
+		result, err := read(item.Result)
+		if err != nil {
+			return nil, fmt.Errorf("entry %s: %w", item.ID, err)
+		}
+		if !json.Valid(result) {
+			return nil, fmt.Errorf("entry %s: %s is not valid JSON", item.ID, item.Result)
+		}
+
+		entry := archivedEntry{HistoryEntry: storage.HistoryEntry{
+			ID:        item.ID,
+			OcrResult: json.RawMessage(result),
+			CreatedAt: item.CreatedAt,
+		}}
+		if item.Image != "" {
+			img, err := read(item.Image)
+			if err != nil {
+				return nil, fmt.Errorf("entry %s: %w", item.ID, err)
+			}
+			if _, ok := filetype.Detect(img); !ok {
+				return nil, fmt.Errorf("entry %s: %s has unrecognized image content", item.ID, item.Image)
+			}
+			entry.hash = imageHash(img)
+			if item.ImageSHA256 != "" && !strings.EqualFold(item.ImageSHA256, entry.hash) {
+				return nil, fmt.Errorf("entry %s: %s does not match image_sha256", item.ID, item.Image)
+			}
+			entry.ImageBase64 = base64.StdEncoding.EncodeToString(img)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
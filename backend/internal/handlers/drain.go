@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/domain"
+	"github.com/airsss993/ocr-history/internal/services"
+	"github.com/airsss993/ocr-history/internal/storage"
+	"github.com/airsss993/ocr-history/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// unfinishedJobs — содержимое server.unfinishedJobsFile: пакеты, прерванные
+// остановкой сервиса. При следующем запуске они попадают в GET /admin/jobs,
+// а их асинхронные операции досчитываются (см. resumeJobs). Файлы без
+// операций не сохраняются: клиентов просят отправить их повторно.
+type unfinishedJobs struct {
+	InterruptedAt time.Time          `json:"interrupted_at"`
+	Jobs          []services.JobInfo `json:"jobs"`
+}
+
+// BeginDrain переводит сервис в режим остановки: /ready отвечает 503,
+// новые запросы на распознавание отклоняются.
+func (h *Handler) BeginDrain() {
+	h.draining.Store(true)
+}
+
+// rejectWhileDraining отвечает 503, если сервис останавливается.
+func (h *Handler) rejectWhileDraining(c *gin.Context) bool {
+	if !h.draining.Load() {
+		return false
+	}
+	c.Header("Retry-After", "30")
+	c.JSON(http.StatusServiceUnavailable, domain.ErrorResponse{
+		Error:   "shutting_down",
+		Message: "server is shutting down, please retry shortly",
+	})
+	return true
+}
+
+// Drain ждёт завершения текущих пакетов OCR до отмены ctx. Пакеты,
+// не успевшие завершиться, записываются в server.unfinishedJobsFile.
+func (h *Handler) Drain(ctx context.Context) error {
+	h.BeginDrain()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, svc := range h.ocrServices() {
+		wg.Add(1)
+		go func(svc *services.OCRService) {
+			defer wg.Done()
+			if err := svc.Drain(ctx); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", svc.Provider(), err))
+				mu.Unlock()
+			}
+		}(svc)
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	var jobs []services.JobInfo
+	for _, svc := range h.ocrServices() {
+		jobs = append(jobs, svc.Unfinished()...)
+	}
+	if len(jobs) > 0 {
+		if err := h.saveUnfinishedJobs(jobs); err != nil {
+			errs = append(errs, err)
+		} else {
+			logger.Warn(fmt.Sprintf("%d unfinished OCR job(s) saved to %s", len(jobs), h.conf().Server.UnfinishedJobsFile))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Close останавливает фоновые горутины обработчика.
+func (h *Handler) Close() {
+	h.yandexRateLimiter.Stop()
+}
+
+func (h *Handler) saveUnfinishedJobs(jobs []services.JobInfo) error {
+	path := h.conf().Server.UnfinishedJobsFile
+
+	data, err := json.MarshalIndent(unfinishedJobs{
+		InterruptedAt: time.Now().UTC(),
+		Jobs:          jobs,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal unfinished jobs: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create unfinished jobs directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write unfinished jobs file: %w", err)
+	}
+	return nil
+}
+
+// loadUnfinishedJobs читает пакеты, прерванные прошлой остановкой, и удаляет файл.
+func (h *Handler) loadUnfinishedJobs() {
+	path := h.conf().Server.UnfinishedJobsFile
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		logger.Error(fmt.Errorf("failed to read unfinished jobs file: %w", err))
+		return
+	}
+
+	var saved unfinishedJobs
+	if err := json.Unmarshal(data, &saved); err != nil {
+		logger.Error(fmt.Errorf("failed to parse unfinished jobs file: %w", err))
+		return
+	}
+
+	h.interrupted = saved.Jobs
+	logger.Warn(fmt.Sprintf("%d OCR job(s) were interrupted by the previous shutdown at %s, see GET /admin/jobs",
+		len(saved.Jobs), saved.InterruptedAt.Format(time.RFC3339)))
+
+	if err := os.Remove(path); err != nil {
+		logger.Error(fmt.Errorf("failed to remove unfinished jobs file: %w", err))
+	}
+
+	h.resumeJobs(saved.Jobs)
+}
+
+// resumeJobs в фоне дожидается асинхронных операций прерванных пакетов
+// и сохраняет их результаты в историю владельца запроса. Пакеты без
+// владельца истории остаются только в списке прерванных.
+func (h *Handler) resumeJobs(jobs []services.JobInfo) {
+	for _, job := range jobs {
+		if len(job.Operations) == 0 {
+			continue
+		}
+		if job.HistoryOwner == "" {
+			logger.Warn(fmt.Sprintf("OCR job %s has %d async operation(s) but no history owner, not resuming",
+				job.ID, len(job.Operations)))
+			continue
+		}
+
+		var svc *services.OCRService
+		for _, s := range h.ocrServices() {
+			if s.Provider() == job.Provider {
+				svc = s
+			}
+		}
+		if svc == nil {
+			continue
+		}
+
+		go func(job services.JobInfo) {
+			err := svc.Resume(context.Background(), job, func(result domain.OCRResult) {
+				h.saveResumedResult(job.HistoryOwner, result)
+			})
+			if err != nil {
+				logger.Error(fmt.Errorf("failed to resume OCR job %s: %w", job.ID, err))
+				return
+			}
+			logger.Info(fmt.Sprintf("OCR job %s resumed, %d result(s) saved to history", job.ID, len(job.Operations)))
+		}(job)
+	}
+}
+
+func (h *Handler) saveResumedResult(owner string, result domain.OCRResult) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		logger.Error(fmt.Errorf("failed to marshal resumed OCR result: %w", err))
+		return
+	}
+
+	h.historyStorage.Add(owner, storage.HistoryEntry{
+		ID:        strconv.FormatInt(time.Now().UnixNano(), 10),
+		OcrResult: data,
+		CreatedAt: time.Now(),
+	})
+}
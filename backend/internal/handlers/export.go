@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/domain"
+	"github.com/airsss993/ocr-history/internal/export"
+	"github.com/airsss993/ocr-history/internal/filetype"
+	"github.com/airsss993/ocr-history/internal/layout"
+	"github.com/airsss993/ocr-history/internal/multipage"
+	"github.com/airsss993/ocr-history/internal/storage"
+	"github.com/airsss993/ocr-history/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// exportFormat разбирает параметр format: json (обычный ответ)
+// или один из форматов выгрузки (см. пакет export).
+func exportFormat(c *gin.Context, name string) (*export.Format, bool) {
+	if name == "" || name == "json" {
+		return nil, true
+	}
+
+	format, ok := export.Lookup(name)
+	if !ok {
+		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("unsupported format %q, expected json or one of: %s", name, strings.Join(export.Names(), ", ")),
+		})
+		return nil, false
+	}
+	return &format, true
+}
+
+// writeExport отдаёт выгрузку файлом. Параметры images и notes включают
+// изображения страниц и приложение с заметками (DOCX, ODT), table выбирает
+// таблицу для CSV и XLSX.
+func (h *Handler) writeExport(c *gin.Context, format export.Format, pages []export.Page, name string) {
+	opts, ok := exportOptions(c)
+	if !ok {
+		return
+	}
+	file, err := format.Write(pages, opts)
+	if err != nil {
+		logger.ErrorCtx(c.Request.Context(), fmt.Errorf("%s export failed: %w", format.Name, err))
+		c.JSON(http.StatusUnprocessableEntity, domain.ErrorResponse{
+			Error:   "export_failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, file.Extension))
+	c.Data(http.StatusOK, file.ContentType, file.Data)
+}
+
+// exportOptions разбирает параметры images, notes и table.
+func exportOptions(c *gin.Context) (export.Options, bool) {
+	opts := export.Options{Images: queryFlag(c, "images"), Notes: queryFlag(c, "notes")}
+	if v := c.Query("table"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+				Error:   "validation_error",
+				Message: "table must be a positive integer",
+			})
+			return opts, false
+		}
+		opts.Table = n
+	}
+	return opts, true
+}
+
+func queryFlag(c *gin.Context, name string) bool {
+	v := c.Query(name)
+	return v == "1" || v == "true"
+}
+
+// exportName — имя файла выгрузки свежего задания OCR.
+func exportName() string {
+	return "ocr-" + time.Now().Format("20060102-150405")
+}
+
+// responsePages превращает результаты задания OCR в страницы выгрузки;
+// изображения есть, только если задание запускалось с KeepImages.
+func responsePages(response *domain.OCRResponse) []export.Page {
+	var pages []export.Page
+	for _, result := range response.Results {
+		if len(result.Pages) == 0 {
+			pages = append(pages, exportPage(result.Filename, 0, result.Text, result.Image))
+			continue
+		}
+		for _, page := range result.Pages {
+			pages = append(pages, exportPage(result.Filename, page.Page, page.Text, page.Image))
+		}
+	}
+	return pages
+}
+
+func exportPage(source string, number int, text json.RawMessage, image *domain.SourceImage) export.Page {
+	page := export.Page{Source: source, Number: number, Layout: layout.Parse(text), Meta: export.GeminiMeta(text)}
+	page.Meta.Created = time.Now()
+	if image != nil {
+		page.Image = image.Data
+		page.Format, _ = filetype.ByMIME(image.MIME)
+	}
+	return page
+}
+
+func (h *Handler) handleExportHistoryEntry(c *gin.Context) {
+	owner, ok := h.historyOwner(c)
+	if !ok {
+		return
+	}
+
+	format, ok := exportFormat(c, c.DefaultQuery("format", "pdf"))
+	if !ok {
+		return
+	}
+	if format == nil {
+		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+			Error:   "validation_error",
+			Message: "use GET /api/v1/history for JSON",
+		})
+		return
+	}
+
+	entry, found := h.historyStorage.Find(owner, c.Param("id"))
+	if !found {
+		c.JSON(http.StatusNotFound, domain.ErrorResponse{
+			Error:   "not_found",
+			Message: "history entry not found",
+		})
+		return
+	}
+
+	pages, err := historyPages(entry, h.conf().OCR.MaxPagesPerFile)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, domain.ErrorResponse{
+			Error:   "export_failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	h.writeExport(c, *format, pages, "ocr-"+entry.ID)
+}
+
+// historyPages восстанавливает страницы из записи истории: изображение
+// в imageBase64 (PDF и TIFF разбиваются на страницы) и результат OCR.
+// ocrResult — элемент results ответа OCR либо «сырой» ответ провайдера.
+func historyPages(entry storage.HistoryEntry, maxPages int) ([]export.Page, error) {
+	img, format, err := historyImage(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	var result domain.OCRResult
+	_ = json.Unmarshal(entry.OcrResult, &result)
+	source := result.Filename
+	if source == "" {
+		source = entry.ID
+	}
+
+	if len(result.Pages) == 0 {
+		text := result.Text
+		if len(text) == 0 {
+			text = entry.OcrResult
+		}
+		page := export.Page{Source: source, Layout: layout.Parse(text), Image: img, Format: format, Meta: historyMeta(entry, text)}
+		return []export.Page{page}, nil
+	}
+
+	var images []multipage.Page
+	if img != nil && multipage.IsMultipage(format) {
+		if images, err = multipage.Split(img, format, maxPages); err != nil {
+			return nil, err
+		}
+	}
+
+	pages := make([]export.Page, 0, len(result.Pages))
+	for _, p := range result.Pages {
+		page := export.Page{Source: source, Number: p.Page, Layout: layout.Parse(p.Text), Meta: historyMeta(entry, p.Text)}
+		switch i := p.Page - 1; {
+		case i >= 0 && i < len(images) && images[i].Err == nil:
+			page.Image, page.Format = images[i].Data, images[i].Format
+		case images == nil && len(result.Pages) == 1:
+			page.Image, page.Format = img, format
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+// historyImage декодирует imageBase64 записи истории (в том числе data URL).
+// Для записи без изображения возвращает nil.
+func historyImage(entry storage.HistoryEntry) ([]byte, filetype.Format, error) {
+	encoded := entry.ImageBase64
+	if encoded == "" {
+		return nil, filetype.Format{}, nil
+	}
+	if strings.HasPrefix(encoded, "data:") {
+		_, encoded, _ = strings.Cut(encoded, ",")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, filetype.Format{}, fmt.Errorf("imageBase64 is not valid base64")
+	}
+	format, ok := filetype.Detect(data)
+	if !ok {
+		return nil, filetype.Format{}, fmt.Errorf("unrecognized image content")
+	}
+	return data, format, nil
+}
+
+func historyMeta(entry storage.HistoryEntry, text json.RawMessage) export.Meta {
+	meta := export.GeminiMeta(text)
+	meta.EntryID, meta.Created = entry.ID, entry.CreatedAt
+	return meta
+}
+
+// handleImportHistoryEntry принимает исправленную разметку PAGE XML
+// (файл или ZIP, телом запроса или полем file формы) и записывает её
+// в запись истории вместо результата OCR.
+func (h *Handler) handleImportHistoryEntry(c *gin.Context) {
+	owner, ok := h.historyOwner(c)
+	if !ok {
+		return
+	}
+
+	entry, found := h.historyStorage.Find(owner, c.Param("id"))
+	if !found {
+		c.JSON(http.StatusNotFound, domain.ErrorResponse{
+			Error:   "not_found",
+			Message: "history entry not found",
+		})
+		return
+	}
+
+	data, err := importBody(c, int64(h.conf().OCR.MaxImageSizeMB)<<20)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	pages, err := export.ImportPageXML(data)
+	if err == nil {
+		entry.OcrResult, err = importedResult(entry, pages)
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, domain.ErrorResponse{
+			Error:   "import_failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if !h.historyStorage.UpdateResult(owner, entry.ID, entry.OcrResult) {
+		c.JSON(http.StatusNotFound, domain.ErrorResponse{
+			Error:   "not_found",
+			Message: "history entry not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entry": entry,
+	})
+}
+
+// importBody читает импортируемый файл из поля file формы или из тела запроса.
+func importBody(c *gin.Context, limit int64) ([]byte, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		header, err := c.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("multipart form must contain a file field")
+		}
+		f, err := header.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read uploaded file")
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body (limit %d MB)", limit>>20)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("request body is empty")
+	}
+	return data, nil
+}
+
+// importedResult подставляет исправленные страницы в результат OCR записи.
+// Страницы записываются в формате Yandex Vision, который понимает клиент;
+// их число должно совпадать с числом страниц записи.
+func importedResult(entry storage.HistoryEntry, pages []layout.Page) (json.RawMessage, error) {
+	var result domain.OCRResult
+	if json.Unmarshal(entry.OcrResult, &result) != nil || (len(result.Text) == 0 && len(result.Pages) == 0) {
+		// В записи «сырой» ответ провайдера — заменяем его целиком.
+		result = domain.OCRResult{Filename: result.Filename}
+	}
+
+	if want := max(len(result.Pages), 1); len(pages) != want {
+		return nil, fmt.Errorf("PAGE XML contains %d pages, history entry has %d", len(pages), want)
+	}
+
+	texts := make([]json.RawMessage, len(pages))
+	for i, page := range pages {
+		text, err := layout.EncodeYandex(page)
+		if err != nil {
+			return nil, err
+		}
+		texts[i] = text
+	}
+
+	result.Error = ""
+	if len(result.Pages) == 0 {
+		result.Text = texts[0]
+	} else {
+		for i := range result.Pages {
+			result.Pages[i].Text = texts[i]
+			result.Pages[i].Error = ""
+		}
+	}
+	return json.Marshal(result)
+}
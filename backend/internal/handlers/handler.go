@@ -2,72 +2,155 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/airsss993/ocr-history/internal/auth"
 	"github.com/airsss993/ocr-history/internal/config"
 	"github.com/airsss993/ocr-history/internal/domain"
+	"github.com/airsss993/ocr-history/internal/health"
+	"github.com/airsss993/ocr-history/internal/metrics"
 	"github.com/airsss993/ocr-history/internal/middleware"
+	"github.com/airsss993/ocr-history/internal/preprocess"
+	"github.com/airsss993/ocr-history/internal/quota"
 	"github.com/airsss993/ocr-history/internal/ratelimiter"
 	"github.com/airsss993/ocr-history/internal/repository"
 	"github.com/airsss993/ocr-history/internal/services"
 	"github.com/airsss993/ocr-history/internal/storage"
 	"github.com/airsss993/ocr-history/pkg/logger"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 type Handler struct {
-	cfg               *config.Config
+	cfg               atomic.Pointer[config.Config]
 	historyStorage    *storage.HistoryStorage
 	yandexRateLimiter *ratelimiter.YandexRateLimiter
-	yandexRepo        *repository.YandexOCRRepository
+	rateLimiter       *middleware.RateLimiter
+	geminiService     *services.OCRService
+	yandexService     *services.OCRService
+	yandexIAM         atomic.Pointer[repository.YandexIAMTokenSource] // nil — статический ключ
+	quota             *quota.Manager
+	metrics           *metrics.Metrics
+	keys              *auth.KeyStore
+	users             *auth.UserStore
+	tokens            *auth.TokenIssuer
+	readiness         *health.Checker
+
+	draining    atomic.Bool
+	interrupted []services.JobInfo // пакеты, прерванные прошлой остановкой
 }
 
-func NewHandler(cfg *config.Config, historyStorage *storage.HistoryStorage) *Handler {
-	yandexRL := ratelimiter.NewYandexRateLimiter(cfg.OCR.YandexRequestsPerSec)
-
-	yandexRepo := repository.NewYandexOCRRepository(
-		cfg.OCR.YandexAPIKey,
-		cfg.OCR.YandexFolderID,
-		cfg.OCR.YandexModel,
-		yandexRL,
-	)
+func NewHandler(
+	cfg *config.Config,
+	historyStorage *storage.HistoryStorage,
+	keys *auth.KeyStore,
+	users *auth.UserStore,
+	tokens *auth.TokenIssuer,
+) *Handler {
+	quotaManager := quota.NewManager(cfg.Quota)
+
+	var m *metrics.Metrics
+	if cfg.Metrics.Enabled {
+		m = metrics.New()
+	}
 
-	return &Handler{
-		cfg:               cfg,
+	h := &Handler{
 		historyStorage:    historyStorage,
-		yandexRateLimiter: yandexRL,
-		yandexRepo:        yandexRepo,
+		yandexRateLimiter: ratelimiter.NewYandexRateLimiter(cfg.OCR.YandexRequestsPerSec),
+		rateLimiter:       middleware.NewRateLimiter(cfg.RateLimit.MaxConcurrentUsers),
+		quota:             quotaManager,
+		metrics:           m,
+		keys:              keys,
+		users:             users,
+		tokens:            tokens,
+	}
+	h.cfg.Store(cfg)
+	h.readiness = h.newReadinessChecker()
+
+	h.geminiService = services.NewOCRService(config.ProviderGemini, h.newGeminiRepository(cfg), cfg.Workers.MaxWorkers, quotaManager)
+	h.yandexService = services.NewOCRService(config.ProviderYandex, h.newYandexRepository(cfg), cfg.Workers.MaxWorkers, quotaManager)
+	h.loadUnfinishedJobs()
+
+	if m != nil {
+		m.RegisterOCRService(h.geminiService)
+		m.RegisterOCRService(h.yandexService)
+		m.RegisterYandexLimiter(h.yandexRateLimiter)
+		m.RegisterHistory(historyStorage)
 	}
+
+	return h
+}
+
+// conf возвращает действующую конфигурацию; после перезагрузки — новую.
+func (h *Handler) conf() *config.Config {
+	return h.cfg.Load()
 }
 
 func (h *Handler) Init() *gin.Engine {
+	cfg := h.conf()
 	router := gin.New()
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		logger.Error(fmt.Errorf("invalid server.trustedProxies: %w", err))
+	}
 
 	router.Use(
-		gin.Recovery(),
-		gin.Logger(),
+		gin.RecoveryWithWriter(logger.Writer()),
+		otelgin.Middleware(cfg.Tracing.ServiceName),
+		middleware.RequestID(),
+		middleware.AccessLog(),
 		middleware.CORS(),
 	)
 
+	if h.metrics != nil {
+		router.Use(h.metrics.Middleware())
+		router.GET(cfg.Metrics.Path, h.metrics.Handler())
+	}
+
 	router.GET("/health", h.healthCheck)
 	router.GET("/ready", h.readinessCheck)
 
-	rateLimiter := middleware.NewRateLimiter(h.cfg.RateLimit.MaxConcurrentUsers)
 	api := router.Group("/api/v1")
-	api.Use(rateLimiter.Limit())
+	api.Use(
+		middleware.Authenticate(h.keys, h.tokens, cfg.Auth),
+		h.rateLimiter.Limit(),
+	)
 	{
-		api.POST("/ocr/gemini", h.handleGeminiOCR)
-		api.POST("/ocr/yandex", h.handleYandexOCR)
-
-		api.GET("/history", h.handleGetHistory)
-		api.POST("/history", h.handleAddHistory)
-		api.DELETE("/history/:id", h.handleDeleteHistoryEntry)
-		api.DELETE("/history", h.handleClearHistory)
+		api.POST("/ocr/gemini", middleware.RequireScope(auth.ScopeOCRGemini), h.handleGeminiOCR)
+		api.POST("/ocr/yandex", middleware.RequireScope(auth.ScopeOCRYandex), h.handleYandexOCR)
+
+		api.GET("/quota", h.handleGetQuota)
+
+		api.POST("/auth/register", h.handleRegister)
+		api.POST("/auth/login", h.handleLogin)
+		api.GET("/auth/me", h.handleMe)
+		api.POST("/auth/claim", h.handleClaimClientID)
+
+		historyRead := middleware.RequireScope(auth.ScopeHistoryRead)
+		historyWrite := middleware.RequireScope(auth.ScopeHistoryWrite)
+		api.GET("/history", historyRead, h.handleGetHistory)
+		api.POST("/history", historyWrite, h.handleAddHistory)
+		api.GET("/history/export", historyRead, h.handleExportHistory)
+		api.POST("/history/import", historyWrite, h.handleImportHistory)
+		api.GET("/history/:id/export", historyRead, h.handleExportHistoryEntry)
+		api.POST("/history/:id/import", historyWrite, h.handleImportHistoryEntry)
+		api.DELETE("/history/:id", historyWrite, h.handleDeleteHistoryEntry)
+		api.DELETE("/history", historyWrite, h.handleClearHistory)
 	}
 
+	admin := router.Group("/admin")
+	admin.Use(
+		middleware.Authenticate(h.keys, h.tokens, cfg.Auth),
+		middleware.RequireScope(auth.ScopeAdmin),
+	)
+	h.initAdminRoutes(admin)
+
 	return router
 }
 
@@ -77,19 +160,93 @@ func (h *Handler) healthCheck(c *gin.Context) {
 	})
 }
 
-func (h *Handler) readinessCheck(c *gin.Context) {
-	c.JSON(200, gin.H{
-		"ready": true,
+// providerEnabled отвечает 503, если у провайдера нет учётных данных.
+func (h *Handler) providerEnabled(c *gin.Context, provider string) bool {
+	if h.conf().OCR.ProviderEnabled(provider) {
+		return true
+	}
+	c.JSON(http.StatusServiceUnavailable, domain.ErrorResponse{
+		Error:   "provider_disabled",
+		Message: fmt.Sprintf("%s provider is not configured on this server", provider),
 	})
+	return false
+}
+
+// processOptions собирает параметры обработки из конфигурации и поля
+// preprocess запроса ("none", либо шаги через запятую).
+func (h *Handler) processOptions(c *gin.Context, provider string) (services.ProcessOptions, bool) {
+	cfg := h.conf()
+	opts := services.ProcessOptions{
+		MaxSizeMB:        cfg.OCR.MaxImageSizeMB,
+		SupportedFormats: cfg.OCR.SupportedFormats,
+		MaxPages:         cfg.OCR.MaxPagesPerFile,
+		HistoryOwner:     h.resultOwner(c),
+	}
+
+	steps := cfg.Preprocess.DefaultSteps
+	if requested, ok := c.GetPostForm("preprocess"); ok {
+		parsed, err := preprocess.ParseSteps(requested)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+				Error:   "validation_error",
+				Message: err.Error(),
+			})
+			return opts, false
+		}
+		if len(parsed) > 0 && !cfg.Preprocess.Enabled {
+			c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+				Error:   "validation_error",
+				Message: "image preprocessing is disabled on this server",
+			})
+			return opts, false
+		}
+		steps = parsed
+	}
+
+	if cfg.Preprocess.Enabled {
+		limits := cfg.Preprocess.Limits[provider]
+		opts.Preprocess = preprocess.Options{
+			Steps:       steps,
+			MaxSide:     limits.MaxSide,
+			MaxPixels:   limits.MaxPixels,
+			JPEGQuality: cfg.Preprocess.JPEGQuality,
+		}
+	}
+
+	return opts, true
+}
+
+// yandexModel выбирает модель Yandex для запроса: поле model формы, иначе
+// модель по типу документа из поля document_type, иначе модель из конфигурации.
+func (h *Handler) yandexModel(c *gin.Context) (string, bool) {
+	if model := strings.TrimSpace(c.PostForm("model")); model != "" {
+		if !slices.Contains(config.YandexModels, model) {
+			c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+				Error:   "validation_error",
+				Message: fmt.Sprintf("unsupported model %q, expected one of: %s", model, strings.Join(config.YandexModels, ", ")),
+			})
+			return "", false
+		}
+		return model, true
+	}
+
+	if documentType := c.PostForm("document_type"); documentType != "" {
+		model, ok := repository.YandexModelForDocument(documentType)
+		if !ok {
+			c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+				Error:   "validation_error",
+				Message: fmt.Sprintf("unknown document_type %q, expected one of: %s", documentType, strings.Join(repository.YandexDocumentTypes(), ", ")),
+			})
+			return "", false
+		}
+		return model, true
+	}
+
+	return h.conf().OCR.YandexModel, true
 }
 
 func (h *Handler) handleGeminiOCR(c *gin.Context) {
-	authKey := c.GetHeader("X-Gemini-API-Key")
-	if authKey == "" || authKey != h.cfg.OCR.GeminiAuthKey {
-		c.JSON(http.StatusUnauthorized, domain.ErrorResponse{
-			Error:   "authentication_error",
-			Message: "Invalid or missing authentication key",
-		})
+	if h.rejectWhileDraining(c) || !h.providerEnabled(c, config.ProviderGemini) {
 		return
 	}
 
@@ -111,25 +268,38 @@ func (h *Handler) handleGeminiOCR(c *gin.Context) {
 		return
 	}
 
-	if len(files) > h.cfg.OCR.MaxImagesPerRequest {
+	ocrCfg := h.conf().OCR
+	if len(files) > ocrCfg.MaxImagesPerRequest {
 		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
 			Error:   "validation_error",
-			Message: fmt.Sprintf("maximum %d images allowed, got %d", h.cfg.OCR.MaxImagesPerRequest, len(files)),
+			Message: fmt.Sprintf("maximum %d images allowed, got %d", ocrCfg.MaxImagesPerRequest, len(files)),
 		})
 		return
 	}
 
-	geminiRepo := repository.NewGeminiRepository(h.cfg.OCR.GeminiAPIKey, h.cfg.OCR.GeminiModel, h.cfg.OCR.GeminiProxyURL)
-	geminiService := services.NewOCRService(geminiRepo, h.cfg.Workers.MaxWorkers)
+	opts, ok := h.processOptions(c, config.ProviderGemini)
+	if !ok {
+		return
+	}
+
+	format, ok := exportFormat(c, c.Query("format"))
+	if !ok {
+		return
+	}
+	opts.KeepImages = format != nil
+
+	if !h.checkQuota(c, config.ProviderGemini, len(files)) {
+		return
+	}
 
 	// Обрабатываем изображения
-	response, err := geminiService.ProcessImages(
-		files,
-		h.cfg.OCR.MaxImageSizeMB,
-		h.cfg.OCR.SupportedFormats,
-	)
+	response, err := h.geminiService.ProcessImages(c.Request.Context(), h.quotaKey(c), files, opts)
+	if errors.Is(err, services.ErrShuttingDown) {
+		h.rejectWhileDraining(c)
+		return
+	}
 	if err != nil {
-		logger.Error(err)
+		logger.ErrorCtx(c.Request.Context(), err)
 		c.JSON(http.StatusInternalServerError, domain.ErrorResponse{
 			Error:   "internal_server_error",
 			Message: "failed to process images",
@@ -137,10 +307,19 @@ func (h *Handler) handleGeminiOCR(c *gin.Context) {
 		return
 	}
 
+	h.setQuotaHeaders(c)
+	if format != nil {
+		h.writeExport(c, *format, responsePages(response), exportName())
+		return
+	}
 	c.JSON(http.StatusOK, response)
 }
 
 func (h *Handler) handleYandexOCR(c *gin.Context) {
+	if h.rejectWhileDraining(c) || !h.providerEnabled(c, config.ProviderYandex) {
+		return
+	}
+
 	// Парсим форму
 	form, err := c.MultipartForm()
 	if err != nil {
@@ -160,24 +339,41 @@ func (h *Handler) handleYandexOCR(c *gin.Context) {
 		return
 	}
 
-	if len(files) > h.cfg.OCR.MaxImagesPerRequest {
+	ocrCfg := h.conf().OCR
+	if len(files) > ocrCfg.MaxImagesPerRequest {
 		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
 			Error:   "validation_error",
-			Message: fmt.Sprintf("maximum %d images allowed, got %d", h.cfg.OCR.MaxImagesPerRequest, len(files)),
+			Message: fmt.Sprintf("maximum %d images allowed, got %d", ocrCfg.MaxImagesPerRequest, len(files)),
 		})
 		return
 	}
 
-	yandexService := services.NewOCRService(h.yandexRepo, h.cfg.Workers.MaxWorkers)
+	opts, ok := h.processOptions(c, config.ProviderYandex)
+	if !ok {
+		return
+	}
+	if opts.Model, ok = h.yandexModel(c); !ok {
+		return
+	}
+
+	format, ok := exportFormat(c, c.Query("format"))
+	if !ok {
+		return
+	}
+	opts.KeepImages = format != nil
+
+	if !h.checkQuota(c, config.ProviderYandex, len(files)) {
+		return
+	}
 
 	// Обрабатываем изображения
-	response, err := yandexService.ProcessImages(
-		files,
-		h.cfg.OCR.MaxImageSizeMB,
-		h.cfg.OCR.SupportedFormats,
-	)
+	response, err := h.yandexService.ProcessImages(c.Request.Context(), h.quotaKey(c), files, opts)
+	if errors.Is(err, services.ErrShuttingDown) {
+		h.rejectWhileDraining(c)
+		return
+	}
 	if err != nil {
-		logger.Error(err)
+		logger.ErrorCtx(c.Request.Context(), err)
 		c.JSON(http.StatusInternalServerError, domain.ErrorResponse{
 			Error:   "internal_server_error",
 			Message: "failed to process images",
@@ -185,6 +381,11 @@ func (h *Handler) handleYandexOCR(c *gin.Context) {
 		return
 	}
 
+	h.setQuotaHeaders(c)
+	if format != nil {
+		h.writeExport(c, *format, responsePages(response), exportName())
+		return
+	}
 	c.JSON(http.StatusOK, response)
 }
 
@@ -192,17 +393,70 @@ func (h *Handler) getClientID(c *gin.Context) string {
 	return c.GetHeader("X-Client-ID")
 }
 
-func (h *Handler) handleGetHistory(c *gin.Context) {
+// historyOwner возвращает владельца истории: вошедшего пользователя или X-Client-ID.
+// Client ID, закреплённый за пользователем, без входа больше не работает.
+func (h *Handler) historyOwner(c *gin.Context) (string, bool) {
+	if principal := middleware.GetPrincipal(c); principal.UserID != "" {
+		return auth.UserOwner(principal.UserID), true
+	}
+
 	clientID := h.getClientID(c)
 	if clientID == "" {
 		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
 			Error:   "validation_error",
 			Message: "X-Client-ID header is required",
 		})
+		return "", false
+	}
+
+	if h.users != nil {
+		if _, claimed := h.users.ClaimedBy(clientID); claimed {
+			c.JSON(http.StatusForbidden, domain.ErrorResponse{
+				Error:   "client_id_claimed",
+				Message: "this client id belongs to a user account, log in to access its history",
+			})
+			return "", false
+		}
+	}
+
+	return clientID, true
+}
+
+// resultOwner возвращает владельца истории для результатов, досчитанных
+// после перезапуска, или пустую строку, если запрос истории не указал.
+func (h *Handler) resultOwner(c *gin.Context) string {
+	if principal := middleware.GetPrincipal(c); principal.UserID != "" {
+		return auth.UserOwner(principal.UserID)
+	}
+
+	clientID := h.getClientID(c)
+	if clientID == "" {
+		return ""
+	}
+	if h.users != nil {
+		if _, claimed := h.users.ClaimedBy(clientID); claimed {
+			return ""
+		}
+	}
+	return clientID
+}
+
+// setClaimToken отдаёт claim-токен новой анонимной истории в X-Claim-Token.
+// Токен выдаётся один раз; с ним клиент потом переносит историю в учётную запись.
+func (h *Handler) setClaimToken(c *gin.Context, token string) {
+	if token == "" || middleware.GetPrincipal(c).UserID != "" {
+		return
+	}
+	c.Header("X-Claim-Token", token)
+}
+
+func (h *Handler) handleGetHistory(c *gin.Context) {
+	owner, ok := h.historyOwner(c)
+	if !ok {
 		return
 	}
 
-	entries := h.historyStorage.Get(clientID)
+	entries := h.historyStorage.Get(owner)
 	c.JSON(http.StatusOK, gin.H{
 		"entries": entries,
 	})
@@ -214,12 +468,8 @@ type AddHistoryRequest struct {
 }
 
 func (h *Handler) handleAddHistory(c *gin.Context) {
-	clientID := h.getClientID(c)
-	if clientID == "" {
-		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
-			Error:   "validation_error",
-			Message: "X-Client-ID header is required",
-		})
+	owner, ok := h.historyOwner(c)
+	if !ok {
 		return
 	}
 
@@ -239,7 +489,7 @@ func (h *Handler) handleAddHistory(c *gin.Context) {
 		CreatedAt:   time.Now(),
 	}
 
-	h.historyStorage.Add(clientID, entry)
+	h.setClaimToken(c, h.historyStorage.Add(owner, entry))
 
 	c.JSON(http.StatusOK, gin.H{
 		"entry": entry,
@@ -247,17 +497,13 @@ func (h *Handler) handleAddHistory(c *gin.Context) {
 }
 
 func (h *Handler) handleDeleteHistoryEntry(c *gin.Context) {
-	clientID := h.getClientID(c)
-	if clientID == "" {
-		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
-			Error:   "validation_error",
-			Message: "X-Client-ID header is required",
-		})
+	owner, ok := h.historyOwner(c)
+	if !ok {
 		return
 	}
 
 	entryID := c.Param("id")
-	deleted := h.historyStorage.Delete(clientID, entryID)
+	deleted := h.historyStorage.Delete(owner, entryID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"deleted": deleted,
@@ -265,16 +511,12 @@ func (h *Handler) handleDeleteHistoryEntry(c *gin.Context) {
 }
 
 func (h *Handler) handleClearHistory(c *gin.Context) {
-	clientID := h.getClientID(c)
-	if clientID == "" {
-		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
-			Error:   "validation_error",
-			Message: "X-Client-ID header is required",
-		})
+	owner, ok := h.historyOwner(c)
+	if !ok {
 		return
 	}
 
-	h.historyStorage.Clear(clientID)
+	h.historyStorage.Clear(owner)
 
 	c.JSON(http.StatusOK, gin.H{
 		"cleared": true,
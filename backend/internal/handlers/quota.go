@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/domain"
+	"github.com/airsss993/ocr-history/internal/middleware"
+	"github.com/airsss993/ocr-history/internal/quota"
+	"github.com/gin-gonic/gin"
+)
+
+// quotaKey определяет, на кого списывается квота: владелец API-ключа или,
+// для анонимных запросов, IP-адрес. X-Client-ID клиент выбирает сам, и новый
+// заголовок на каждый запрос давал бы безлимитную квоту.
+func (h *Handler) quotaKey(c *gin.Context) string {
+	if principal := middleware.GetPrincipal(c); !principal.Anonymous() {
+		return principal.Owner
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// checkQuota отклоняет запрос целиком, если пакет из n изображений не помещается в квоту.
+func (h *Handler) checkQuota(c *gin.Context, provider string, n int) bool {
+	if h.quota == nil {
+		return true
+	}
+
+	key := h.quotaKey(c)
+	want := quota.Usage{
+		Images: int64(n),
+		Pages:  int64(n),
+		Cost:   h.quota.Cost(provider, int64(n), 0),
+	}
+
+	err := h.quota.Check(key, want)
+	if err == nil {
+		return true
+	}
+
+	h.setQuotaHeaders(c)
+
+	var exceeded *quota.ExceededError
+	if !errors.As(err, &exceeded) {
+		c.JSON(http.StatusInternalServerError, domain.ErrorResponse{
+			Error:   "internal_server_error",
+			Message: "failed to check quota",
+		})
+		return false
+	}
+
+	if exceeded.Resource == quota.ResourceBudget {
+		c.JSON(http.StatusPaymentRequired, domain.ErrorResponse{
+			Error:   "budget_exceeded",
+			Message: exceeded.Error(),
+		})
+		return false
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(exceeded.ResetAt).Seconds()))))
+	c.JSON(http.StatusTooManyRequests, domain.ErrorResponse{
+		Error:   "quota_exceeded",
+		Message: fmt.Sprintf("%s, resets at %s", exceeded.Error(), exceeded.ResetAt.Format(time.RFC3339)),
+	})
+	return false
+}
+
+func (h *Handler) setQuotaHeaders(c *gin.Context) {
+	if h.quota == nil {
+		return
+	}
+
+	r := h.quota.Remaining(h.quotaKey(c))
+	if r.Images >= 0 {
+		c.Header("X-Quota-Remaining-Images", strconv.FormatInt(r.Images, 10))
+	}
+	if r.Pages >= 0 {
+		c.Header("X-Quota-Remaining-Pages", strconv.FormatInt(r.Pages, 10))
+	}
+	if r.Tokens >= 0 {
+		c.Header("X-Quota-Remaining-Tokens", strconv.FormatInt(r.Tokens, 10))
+	}
+	if r.Budget >= 0 {
+		c.Header("X-Quota-Remaining-Budget", strconv.FormatFloat(r.Budget, 'f', 2, 64))
+	}
+	c.Header("X-Quota-Reset", strconv.FormatInt(r.ResetAt.Unix(), 10))
+}
+
+func (h *Handler) handleGetQuota(c *gin.Context) {
+	if h.quota == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"enabled": false,
+		})
+		return
+	}
+
+	key := h.quotaKey(c)
+	daily, monthly := h.quota.Usage(key)
+	h.setQuotaHeaders(c)
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":   true,
+		"remaining": h.quota.Remaining(key),
+		"used": gin.H{
+			"daily":   daily,
+			"monthly": monthly,
+		},
+	})
+}
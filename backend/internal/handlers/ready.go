@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/config"
+	"github.com/airsss993/ocr-history/internal/health"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	readinessCacheTTL = 30 * time.Second
+	readinessTimeout  = 5 * time.Second
+)
+
+func (h *Handler) newReadinessChecker() *health.Checker {
+	checker := health.NewChecker(readinessCacheTTL, readinessTimeout)
+
+	checker.Add(health.Check{Name: config.ProviderYandex, Provider: true, Probe: h.probeYandex})
+	checker.Add(health.Check{Name: config.ProviderGemini, Provider: true, Probe: h.probeGemini})
+	checker.Add(health.Check{Name: "storage", Probe: h.probeStorage})
+
+	return checker
+}
+
+// readinessCheck отвечает 503, пока не работает ни один провайдер или хранилище.
+// /ready открыт для оркестратора без авторизации, поэтому отдаёт только флаг:
+// статус каждой зависимости с путями и причинами — в /admin/ready.
+func (h *Handler) readinessCheck(c *gin.Context) {
+	if h.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"ready":    false,
+			"draining": true,
+		})
+		return
+	}
+
+	report := h.readiness.Report(c.Request.Context())
+	c.JSON(readinessStatus(report), gin.H{
+		"ready": report.Ready,
+	})
+}
+
+// handleAdminReadiness возвращает статус каждой зависимости.
+func (h *Handler) handleAdminReadiness(c *gin.Context) {
+	report := h.readiness.Report(c.Request.Context())
+	draining := h.draining.Load()
+	if draining {
+		report.Ready = false
+	}
+	c.JSON(readinessStatus(report), gin.H{
+		"ready":    report.Ready,
+		"draining": draining,
+		"checks":   report.Checks,
+	})
+}
+
+func readinessStatus(report health.Report) int {
+	if !report.Ready {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
+
+// providerDisabled возвращает health.Disabled, если у провайдера нет учётных данных.
+func (h *Handler) providerDisabled(name string) error {
+	for _, state := range h.conf().OCR.ProviderStates() {
+		if state.Name == name && !state.Enabled {
+			return health.Disabled(state.Reason)
+		}
+	}
+	return nil
+}
+
+// probeYandex при авторизации сервисным аккаунтом проверяет, что выдаётся IAM-токен.
+func (h *Handler) probeYandex(ctx context.Context) error {
+	if err := h.providerDisabled(config.ProviderYandex); err != nil {
+		return err
+	}
+	if iam := h.yandexIAM.Load(); iam != nil {
+		return iam.Ping(ctx)
+	}
+	return nil
+}
+
+// probeGemini проверяет ключ и, если задан прокси, что до него есть TCP-соединение.
+func (h *Handler) probeGemini(ctx context.Context) error {
+	if err := h.providerDisabled(config.ProviderGemini); err != nil {
+		return err
+	}
+
+	proxyURL := h.conf().OCR.GeminiProxyURL
+	if proxyURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL")
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		} else if u.Scheme == "socks5" {
+			port = "1080"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return fmt.Errorf("proxy %s is unreachable", u.Hostname())
+	}
+	return conn.Close()
+}
+
+// probeStorage проверяет, что каталоги файлов ключей и пользователей доступны на запись.
+func (h *Handler) probeStorage(ctx context.Context) error {
+	cfg := h.conf()
+
+	dirs := map[string]bool{}
+	if cfg.Auth.KeysFile != "" {
+		dirs[filepath.Dir(cfg.Auth.KeysFile)] = true
+	}
+	if h.users != nil && cfg.Auth.UsersFile != "" {
+		dirs[filepath.Dir(cfg.Auth.UsersFile)] = true
+	}
+
+	for dir := range dirs {
+		if err := checkWritable(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+
+	f, err := os.CreateTemp(dir, ".ready-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/airsss993/ocr-history/internal/config"
+	"github.com/airsss993/ocr-history/internal/pdf"
+	"github.com/airsss993/ocr-history/internal/repository"
+	"github.com/airsss993/ocr-history/pkg/logger"
+)
+
+func (h *Handler) newGeminiRepository(cfg *config.Config) repository.OCRRepository {
+	repo := repository.NewGeminiRepository(cfg.OCR.GeminiAPIKey, cfg.OCR.GeminiModel, cfg.OCR.GeminiProxyURL, cfg.OCR.GeminiPrompt)
+	if h.metrics != nil {
+		return h.metrics.Instrument(config.ProviderGemini, repo)
+	}
+	return repo
+}
+
+// newYandexRepository создаёт клиент Yandex; при заданном yandexKeyFile
+// он авторизуется IAM-токенами, источник которых запоминается для /ready.
+func (h *Handler) newYandexRepository(cfg *config.Config) repository.OCRRepository {
+	var iam *repository.YandexIAMTokenSource
+	if cfg.OCR.YandexKeyFile != "" {
+		iam = repository.NewYandexIAMTokenSource(cfg.OCR.YandexKeyFile, cfg.OCR.YandexIAMTokenURL)
+	}
+	h.yandexIAM.Store(iam)
+
+	repo := repository.NewYandexOCRRepository(
+		cfg.OCR.YandexAPIKey,
+		iam,
+		cfg.OCR.YandexFolderID,
+		cfg.OCR.YandexModel,
+		repository.YandexEndpoints{
+			Sync:        cfg.OCR.YandexEndpoints.Sync,
+			Async:       cfg.OCR.YandexEndpoints.Async,
+			Operations:  cfg.OCR.YandexEndpoints.Operations,
+			Recognition: cfg.OCR.YandexEndpoints.Recognition,
+		},
+		repository.YandexAsyncOptions{
+			Enabled:      cfg.OCR.YandexAsync.Enabled,
+			MinSize:      int64(cfg.OCR.YandexAsync.MinSizeMB) << 20,
+			MaxPages:     cfg.OCR.YandexAsync.MaxPages,
+			PollInterval: cfg.OCR.YandexAsync.PollInterval,
+			Timeout:      cfg.OCR.YandexAsync.Timeout,
+		},
+		h.yandexRateLimiter,
+	)
+	if h.metrics != nil {
+		return h.metrics.Instrument(config.ProviderYandex, repo)
+	}
+	return repo
+}
+
+// Reload применяет перезагружаемые секции новой конфигурации (лимиты, форматы,
+// промпт и клиенты провайдеров) и пишет в лог, что изменилось. Пакеты,
+// которые уже обрабатываются, дорабатывают со старыми клиентами.
+func (h *Handler) Reload(next *config.Config) {
+	old := h.conf()
+
+	changes := config.Diff(old, next)
+	if len(changes) == 0 {
+		logger.Info("configuration reloaded, nothing changed")
+		return
+	}
+
+	for _, change := range changes {
+		if change.Reloadable {
+			logger.Info("config changed: " + change.String())
+		} else {
+			logger.Warn("config changed, restart required to apply: " + change.String())
+		}
+	}
+
+	cfg := old.WithReloadable(next)
+
+	if cfg.Log != old.Log {
+		if err := logger.Configure(cfg.Log.Level, cfg.Log.Format); err != nil {
+			logger.Error(err)
+		}
+	}
+
+	pdf.SetDecodeLimit(cfg.OCR.MaxDecodedBytes())
+	h.rateLimiter.SetLimit(cfg.RateLimit.MaxConcurrentUsers)
+	h.yandexRateLimiter.SetRate(cfg.OCR.YandexRequestsPerSec)
+
+	if cfg.OCR.GeminiAPIKey != old.OCR.GeminiAPIKey ||
+		cfg.OCR.GeminiModel != old.OCR.GeminiModel ||
+		cfg.OCR.GeminiProxyURL != old.OCR.GeminiProxyURL ||
+		cfg.OCR.GeminiPrompt != old.OCR.GeminiPrompt {
+		h.geminiService.SetRepository(h.newGeminiRepository(cfg))
+	}
+
+	if cfg.OCR.YandexAPIKey != old.OCR.YandexAPIKey ||
+		cfg.OCR.YandexKeyFile != old.OCR.YandexKeyFile ||
+		cfg.OCR.YandexIAMTokenURL != old.OCR.YandexIAMTokenURL ||
+		cfg.OCR.YandexFolderID != old.OCR.YandexFolderID ||
+		cfg.OCR.YandexModel != old.OCR.YandexModel ||
+		cfg.OCR.YandexEndpoints != old.OCR.YandexEndpoints ||
+		cfg.OCR.YandexAsync != old.OCR.YandexAsync {
+		h.yandexService.SetRepository(h.newYandexRepository(cfg))
+	}
+
+	h.cfg.Store(cfg)
+	h.readiness.Reset()
+	logger.Info(fmt.Sprintf("configuration reloaded, %d change(s)", len(changes)))
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/auth"
+	"github.com/airsss993/ocr-history/internal/domain"
+	"github.com/airsss993/ocr-history/internal/middleware"
+	"github.com/airsss993/ocr-history/internal/storage"
+	"github.com/airsss993/ocr-history/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+type CredentialsRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type TokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      UserInfo  `json:"user"`
+}
+
+type UserInfo struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	ClientIDs []string  `json:"client_ids"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newUserInfo(u *auth.User) UserInfo {
+	clientIDs := u.ClientIDs
+	if clientIDs == nil {
+		clientIDs = []string{}
+	}
+	return UserInfo{
+		ID:        u.ID,
+		Username:  u.Username,
+		ClientIDs: clientIDs,
+		CreatedAt: u.CreatedAt,
+	}
+}
+
+// accountsEnabled отвечает 503, если JWT-секрет не настроен.
+func (h *Handler) accountsEnabled(c *gin.Context) bool {
+	if h.users != nil && h.tokens != nil {
+		return true
+	}
+	c.JSON(http.StatusServiceUnavailable, domain.ErrorResponse{
+		Error:   "accounts_disabled",
+		Message: "user accounts are not configured on this server",
+	})
+	return false
+}
+
+func (h *Handler) handleRegister(c *gin.Context) {
+	if !h.accountsEnabled(c) {
+		return
+	}
+	if !h.conf().Auth.Registration {
+		c.JSON(http.StatusForbidden, domain.ErrorResponse{
+			Error:   "registration_disabled",
+			Message: "registration is disabled on this server",
+		})
+		return
+	}
+
+	var req CredentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+			Error:   "validation_error",
+			Message: "invalid request body",
+		})
+		return
+	}
+
+	user, err := h.users.Register(req.Username, req.Password)
+	if errors.Is(err, auth.ErrUserExists) {
+		c.JSON(http.StatusConflict, domain.ErrorResponse{
+			Error:   "user_exists",
+			Message: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	h.respondWithToken(c, http.StatusCreated, user)
+}
+
+func (h *Handler) handleLogin(c *gin.Context) {
+	if !h.accountsEnabled(c) {
+		return
+	}
+
+	var req CredentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+			Error:   "validation_error",
+			Message: "invalid request body",
+		})
+		return
+	}
+
+	user, err := h.users.Authenticate(req.Username, req.Password)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, domain.ErrorResponse{
+			Error:   "authentication_error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	h.respondWithToken(c, http.StatusOK, user)
+}
+
+func (h *Handler) respondWithToken(c *gin.Context, status int, user *auth.User) {
+	token, expiresAt, err := h.tokens.Issue(user)
+	if err != nil {
+		logger.ErrorCtx(c.Request.Context(), err)
+		c.JSON(http.StatusInternalServerError, domain.ErrorResponse{
+			Error:   "internal_server_error",
+			Message: "failed to issue token",
+		})
+		return
+	}
+
+	c.JSON(status, TokenResponse{
+		Token:     token,
+		ExpiresAt: expiresAt,
+		User:      newUserInfo(user),
+	})
+}
+
+// currentUser возвращает пользователя из JWT или отвечает 401.
+func (h *Handler) currentUser(c *gin.Context) (*auth.User, bool) {
+	if !h.accountsEnabled(c) {
+		return nil, false
+	}
+
+	principal := middleware.GetPrincipal(c)
+	if principal.UserID != "" {
+		if user, ok := h.users.Get(principal.UserID); ok {
+			return user, true
+		}
+	}
+
+	c.JSON(http.StatusUnauthorized, domain.ErrorResponse{
+		Error:   "authentication_error",
+		Message: "login required",
+	})
+	return nil, false
+}
+
+func (h *Handler) handleMe(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user": newUserInfo(user),
+	})
+}
+
+// handleClaimClientID переносит историю анонимного X-Client-ID в учётную запись.
+// Владение историей подтверждается заголовком X-Claim-Token — токеном, который
+// сервер выдал при её создании; знать один client ID недостаточно.
+func (h *Handler) handleClaimClientID(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	clientID := h.getClientID(c)
+	if clientID == "" {
+		c.JSON(http.StatusBadRequest, domain.ErrorResponse{
+			Error:   "validation_error",
+			Message: "X-Client-ID header is required",
+		})
+		return
+	}
+
+	// Повторный запрос после успешного переноса: истории под client ID
+	// уже нет, и токен проверять не с чем.
+	if owner, ok := h.users.ClaimedBy(clientID); ok && owner == user.ID {
+		c.JSON(http.StatusOK, gin.H{
+			"claimed":       clientID,
+			"moved_entries": 0,
+		})
+		return
+	}
+
+	// Client ID закрепляется за пользователем только после проверки токена
+	// и под той же блокировкой истории, что и перенос.
+	moved, err := h.historyStorage.Claim(clientID, c.GetHeader("X-Claim-Token"), auth.UserOwner(user.ID), func() error {
+		return h.users.Claim(user.ID, clientID)
+	})
+	switch {
+	case errors.Is(err, storage.ErrInvalidClaim):
+		c.JSON(http.StatusForbidden, domain.ErrorResponse{
+			Error:   "invalid_claim_token",
+			Message: "X-Claim-Token issued with this client id's history is required",
+		})
+		return
+	case errors.Is(err, auth.ErrClientIDClaimed):
+		c.JSON(http.StatusConflict, domain.ErrorResponse{
+			Error:   "client_id_claimed",
+			Message: err.Error(),
+		})
+		return
+	case err != nil:
+		logger.ErrorCtx(c.Request.Context(), err)
+		c.JSON(http.StatusInternalServerError, domain.ErrorResponse{
+			Error:   "internal_server_error",
+			Message: "failed to claim client id",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"claimed":       clientID,
+		"moved_entries": moved,
+	})
+}
@@ -0,0 +1,165 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK       = "ok"
+	StatusFail     = "fail"
+	StatusDisabled = "disabled"
+)
+
+// disabledError возвращается пробой, если зависимость не настроена;
+// такая проверка не считается ни успешной, ни проваленной.
+type disabledError struct{ reason string }
+
+func (e disabledError) Error() string { return e.reason }
+
+// Disabled помечает проверку как выключенную с указанной причиной.
+func Disabled(reason string) error {
+	return disabledError{reason: reason}
+}
+
+// Check — одна зависимость сервиса.
+type Check struct {
+	Name string
+	// Provider — OCR-провайдер: для готовности достаточно одного рабочего провайдера.
+	// Остальные проверки должны проходить все.
+	Provider bool
+	Probe    func(ctx context.Context) error
+}
+
+// Result — результат последней пробы.
+type Result struct {
+	Name      string    `json:"name"`
+	Provider  bool      `json:"provider,omitempty"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type Report struct {
+	Ready  bool     `json:"ready"`
+	Checks []Result `json:"checks"`
+}
+
+// Checker выполняет пробы и кэширует их результаты на ttl, чтобы частые
+// запросы /ready от оркестратора не били по внешним API.
+type Checker struct {
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu      sync.Mutex
+	checks  []Check
+	results map[string]Result
+}
+
+func NewChecker(ttl, timeout time.Duration) *Checker {
+	return &Checker{
+		ttl:     ttl,
+		timeout: timeout,
+		results: make(map[string]Result),
+	}
+}
+
+func (c *Checker) Add(check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, check)
+}
+
+// Reset сбрасывает кэш, например после перезагрузки конфигурации.
+func (c *Checker) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = make(map[string]Result)
+}
+
+// Report возвращает состояние всех зависимостей, при необходимости
+// запуская устаревшие пробы параллельно.
+func (c *Checker) Report(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := make([]Check, len(c.checks))
+	copy(checks, c.checks)
+	c.mu.Unlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		if cached, ok := c.cached(check.Name); ok {
+			results[i] = cached
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = c.run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Checks: results}
+	report.Ready = ready(results)
+	return report
+}
+
+func (c *Checker) cached(name string) (Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result, ok := c.results[name]
+	if !ok || time.Since(result.CheckedAt) > c.ttl {
+		return Result{}, false
+	}
+	return result, true
+}
+
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Probe(ctx)
+
+	result := Result{
+		Name:      check.Name,
+		Provider:  check.Provider,
+		Status:    StatusOK,
+		LatencyMS: time.Since(start).Milliseconds(),
+		CheckedAt: start,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		if _, ok := err.(disabledError); ok {
+			result.Status = StatusDisabled
+		}
+		result.Error = err.Error()
+	}
+
+	c.mu.Lock()
+	c.results[check.Name] = result
+	c.mu.Unlock()
+
+	return result
+}
+
+func ready(results []Result) bool {
+	providerOK := false
+	for _, r := range results {
+		if r.Provider {
+			if r.Status == StatusOK {
+				providerOK = true
+			}
+			continue
+		}
+		if r.Status == StatusFail {
+			return false
+		}
+	}
+	return providerOK
+}
@@ -0,0 +1,175 @@
+// Package layout приводит ответы провайдеров OCR к общей структуре
+// страница → блоки → строки → слова с координатами в пикселях изображения.
+// На ней строятся экспорты (PDF с текстовым слоем и др.).
+package layout
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+)
+
+// Box — прямоугольник в пикселях, начало координат в левом верхнем углу.
+type Box struct {
+	X0, Y0, X1, Y1 float64
+}
+
+func (b Box) Empty() bool {
+	return b.X1 <= b.X0 || b.Y1 <= b.Y0
+}
+
+func (b Box) Width() float64  { return b.X1 - b.X0 }
+func (b Box) Height() float64 { return b.Y1 - b.Y0 }
+
+// Union возвращает прямоугольник, охватывающий оба.
+func (b Box) Union(o Box) Box {
+	if b.Empty() {
+		return o
+	}
+	if o.Empty() {
+		return b
+	}
+	return Box{
+		X0: math.Min(b.X0, o.X0), Y0: math.Min(b.Y0, o.Y0),
+		X1: math.Max(b.X1, o.X1), Y1: math.Max(b.Y1, o.Y1),
+	}
+}
+
+type Word struct {
+	Text       string
+	Box        Box
+	Confidence float64 // 0, если провайдер не сообщает
+}
+
+type Line struct {
+	Text  string
+	Box   Box
+	Words []Word
+}
+
+type Block struct {
+	Box   Box
+	Lines []Line
+}
+
+// Page — распознанная страница. Width и Height — размер изображения,
+// к которому относятся координаты; 0, если провайдер его не сообщил.
+type Page struct {
+	Width, Height float64
+	Blocks        []Block
+	Tables        []Table
+	Entities      []Entity // поля документа из специализированных моделей Yandex (паспорт и т. п.)
+}
+
+// Entity — именованное поле документа, например surname или birth_date.
+type Entity struct {
+	Name string
+	Text string
+}
+
+// Text возвращает текст страницы: строки через перевод строки,
+// блоки через пустую строку.
+func (p Page) Text() string {
+	blocks := make([]string, 0, len(p.Blocks))
+	for _, block := range p.Blocks {
+		lines := make([]string, 0, len(block.Lines))
+		for _, line := range block.Lines {
+			lines = append(lines, line.Text)
+		}
+		blocks = append(blocks, strings.Join(lines, "\n"))
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// HasBoxes сообщает, известны ли координаты строк.
+func (p Page) HasBoxes() bool {
+	for _, block := range p.Blocks {
+		for _, line := range block.Lines {
+			if !line.Box.Empty() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Parse разбирает поле text результата OCR: ответ Yandex Vision,
+// Google Vision, Gemini или просто строку. Неизвестный формат
+// превращается в текст без координат.
+func Parse(text json.RawMessage) Page {
+	if len(text) == 0 || string(text) == "null" {
+		return Page{}
+	}
+
+	var s string
+	if json.Unmarshal(text, &s) == nil {
+		if page, ok := parseJSONString(s); ok {
+			return page
+		}
+		return plainText(s)
+	}
+
+	if page, ok := parseYandex(text); ok {
+		return page
+	}
+	if page, ok := parseGoogle(text); ok {
+		return page
+	}
+	if page, ok := parseGemini(text); ok {
+		return page
+	}
+	return plainText(string(text))
+}
+
+// parseJSONString разбирает JSON, переданный строкой (так текст
+// попадает в результат, если провайдер вернул его не объектом).
+func parseJSONString(s string) (Page, bool) {
+	trimmed := strings.TrimSpace(s)
+	if !strings.HasPrefix(trimmed, "{") {
+		return Page{}, false
+	}
+	raw := json.RawMessage(trimmed)
+	if page, ok := parseYandex(raw); ok {
+		return page, true
+	}
+	if page, ok := parseGoogle(raw); ok {
+		return page, true
+	}
+	return parseGemini(raw)
+}
+
+// plainText раскладывает текст без координат: абзацы — блоки, строки — строки.
+func plainText(s string) Page {
+	var page Page
+	for _, paragraph := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n\n") {
+		var block Block
+		for _, text := range strings.Split(paragraph, "\n") {
+			if text = strings.TrimSpace(text); text != "" {
+				block.Lines = append(block.Lines, lineFromText(text))
+			}
+		}
+		if len(block.Lines) > 0 {
+			page.Blocks = append(page.Blocks, block)
+		}
+	}
+	page.Tables = markdownTables(s)
+	return page
+}
+
+func lineFromText(text string) Line {
+	line := Line{Text: text}
+	for _, word := range strings.Fields(text) {
+		line.Words = append(line.Words, Word{Text: word})
+	}
+	return line
+}
+
+func parseGemini(raw json.RawMessage) (Page, bool) {
+	var resp struct {
+		TextMarkdown *string `json:"text_markdown"`
+	}
+	if json.Unmarshal(raw, &resp) != nil || resp.TextMarkdown == nil {
+		return Page{}, false
+	}
+	return plainText(*resp.TextMarkdown), true
+}
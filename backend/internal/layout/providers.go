@@ -0,0 +1,338 @@
+package layout
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Структуры ответа Yandex Vision OCR (числа приходят строками).
+type yandexResponse struct {
+	Result *struct {
+		TextAnnotation *struct {
+			Width  string `json:"width"`
+			Height string `json:"height"`
+			Blocks []struct {
+				BoundingBox yandexPolygon `json:"boundingBox"`
+				Lines       []struct {
+					BoundingBox yandexPolygon `json:"boundingBox"`
+					Text        string        `json:"text"`
+					Words       []struct {
+						BoundingBox yandexPolygon `json:"boundingBox"`
+						Text        string        `json:"text"`
+						Confidence  float64       `json:"confidence"`
+					} `json:"words"`
+				} `json:"lines"`
+			} `json:"blocks"`
+			Tables []struct {
+				BoundingBox yandexPolygon `json:"boundingBox"`
+				RowCount    yandexInt     `json:"rowCount"`
+				ColumnCount yandexInt     `json:"columnCount"`
+				Cells       []struct {
+					BoundingBox yandexPolygon `json:"boundingBox"`
+					RowIndex    yandexInt     `json:"rowIndex"`
+					ColumnIndex yandexInt     `json:"columnIndex"`
+					RowSpan     yandexInt     `json:"rowSpan"`
+					ColumnSpan  yandexInt     `json:"columnSpan"`
+					Text        string        `json:"text"`
+				} `json:"cells"`
+			} `json:"tables"`
+			Entities []struct {
+				Name string `json:"name"`
+				Text string `json:"text"`
+			} `json:"entities"`
+			Markdown string `json:"markdown"`
+		} `json:"textAnnotation"`
+	} `json:"result"`
+}
+
+// yandexInt — целое int64, которое Yandex передаёт строкой (иногда числом).
+type yandexInt int
+
+func (n *yandexInt) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*n = 0
+		return nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*n = yandexInt(v)
+	return nil
+}
+
+type yandexPolygon struct {
+	Vertices []struct {
+		X string `json:"x"`
+		Y string `json:"y"`
+	} `json:"vertices"`
+}
+
+func (p yandexPolygon) box() Box {
+	points := make([][2]float64, 0, len(p.Vertices))
+	for _, v := range p.Vertices {
+		x, _ := strconv.ParseFloat(v.X, 64) // пропущенная координата означает 0
+		y, _ := strconv.ParseFloat(v.Y, 64)
+		points = append(points, [2]float64{x, y})
+	}
+	return boundingBox(points)
+}
+
+func boundingBox(points [][2]float64) Box {
+	if len(points) == 0 {
+		return Box{}
+	}
+	box := Box{X0: math.Inf(1), Y0: math.Inf(1), X1: math.Inf(-1), Y1: math.Inf(-1)}
+	for _, p := range points {
+		box.X0, box.X1 = math.Min(box.X0, p[0]), math.Max(box.X1, p[0])
+		box.Y0, box.Y1 = math.Min(box.Y0, p[1]), math.Max(box.Y1, p[1])
+	}
+	return box
+}
+
+func parseYandex(raw json.RawMessage) (Page, bool) {
+	var resp yandexResponse
+	if json.Unmarshal(raw, &resp) != nil || resp.Result == nil || resp.Result.TextAnnotation == nil {
+		return Page{}, false
+	}
+	annotation := resp.Result.TextAnnotation
+
+	var page Page
+	page.Width, _ = strconv.ParseFloat(annotation.Width, 64)
+	page.Height, _ = strconv.ParseFloat(annotation.Height, 64)
+
+	for _, b := range annotation.Blocks {
+		block := Block{Box: b.BoundingBox.box()}
+		for _, l := range b.Lines {
+			line := Line{Text: l.Text, Box: l.BoundingBox.box()}
+			for _, w := range l.Words {
+				line.Words = append(line.Words, Word{
+					Text:       w.Text,
+					Box:        w.BoundingBox.box(),
+					Confidence: w.Confidence,
+				})
+			}
+			block.Lines = append(block.Lines, line)
+		}
+		page.Blocks = append(page.Blocks, block)
+	}
+
+	for _, t := range annotation.Tables {
+		table := Table{Box: t.BoundingBox.box(), Rows: int(t.RowCount), Columns: int(t.ColumnCount)}
+		for _, c := range t.Cells {
+			cell := Cell{
+				Row: int(c.RowIndex), Column: int(c.ColumnIndex),
+				RowSpan: max(int(c.RowSpan), 1), ColSpan: max(int(c.ColumnSpan), 1),
+				Text: c.Text, Box: c.BoundingBox.box(),
+			}
+			table.Rows = max(table.Rows, cell.Row+cell.RowSpan)
+			table.Columns = max(table.Columns, cell.Column+cell.ColSpan)
+			table.Cells = append(table.Cells, cell)
+		}
+		page.Tables = append(page.Tables, table)
+	}
+
+	for _, e := range annotation.Entities {
+		page.Entities = append(page.Entities, Entity{Name: e.Name, Text: e.Text})
+	}
+
+	// Модели markdown и math-markdown отдают текст разметкой Markdown.
+	if len(page.Blocks) == 0 && annotation.Markdown != "" {
+		text := plainText(annotation.Markdown)
+		page.Blocks = text.Blocks
+		if len(page.Tables) == 0 {
+			page.Tables = text.Tables
+		}
+	}
+	return page, true
+}
+
+// EncodeYandex сериализует страницу в формате ответа Yandex Vision OCR
+// (result.textAnnotation): в нём клиент хранит и показывает разметку,
+// поэтому исправленный текст возвращается в историю именно так.
+func EncodeYandex(p Page) (json.RawMessage, error) {
+	type word struct {
+		BoundingBox yandexBox `json:"boundingBox"`
+		Text        string    `json:"text"`
+		Confidence  float64   `json:"confidence,omitempty"`
+	}
+	type line struct {
+		BoundingBox yandexBox `json:"boundingBox"`
+		Text        string    `json:"text"`
+		Words       []word    `json:"words"`
+	}
+	type block struct {
+		BoundingBox yandexBox `json:"boundingBox"`
+		Lines       []line    `json:"lines"`
+	}
+	type annotation struct {
+		Width    string  `json:"width"`
+		Height   string  `json:"height"`
+		Blocks   []block `json:"blocks"`
+		FullText string  `json:"fullText"`
+	}
+
+	out := annotation{
+		Width:    strconv.Itoa(int(math.Round(p.Width))),
+		Height:   strconv.Itoa(int(math.Round(p.Height))),
+		Blocks:   make([]block, 0, len(p.Blocks)),
+		FullText: p.Text(),
+	}
+	for _, b := range p.Blocks {
+		ob := block{BoundingBox: newYandexBox(b.Box), Lines: make([]line, 0, len(b.Lines))}
+		for _, l := range b.Lines {
+			ol := line{BoundingBox: newYandexBox(l.Box), Text: l.Text, Words: make([]word, 0, len(l.Words))}
+			for _, w := range l.Words {
+				ol.Words = append(ol.Words, word{BoundingBox: newYandexBox(w.Box), Text: w.Text, Confidence: w.Confidence})
+			}
+			ob.Lines = append(ob.Lines, ol)
+		}
+		out.Blocks = append(out.Blocks, ob)
+	}
+
+	var resp struct {
+		Result struct {
+			TextAnnotation annotation `json:"textAnnotation"`
+		} `json:"result"`
+	}
+	resp.Result.TextAnnotation = out
+	return json.Marshal(resp)
+}
+
+type yandexBox struct {
+	Vertices []yandexVertex `json:"vertices"`
+}
+
+type yandexVertex struct {
+	X string `json:"x"`
+	Y string `json:"y"`
+}
+
+// newYandexBox записывает прямоугольник четырьмя вершинами в том порядке,
+// в каком их отдаёт Yandex: от левого верхнего угла против часовой стрелки.
+func newYandexBox(b Box) yandexBox {
+	if b.Empty() {
+		return yandexBox{Vertices: []yandexVertex{}}
+	}
+	vertex := func(x, y float64) yandexVertex {
+		return yandexVertex{X: strconv.Itoa(int(math.Round(x))), Y: strconv.Itoa(int(math.Round(y)))}
+	}
+	return yandexBox{Vertices: []yandexVertex{
+		vertex(b.X0, b.Y0), vertex(b.X0, b.Y1), vertex(b.X1, b.Y1), vertex(b.X1, b.Y0),
+	}}
+}
+
+// Структуры fullTextAnnotation Google Vision (DOCUMENT_TEXT_DETECTION).
+type googleAnnotation struct {
+	Pages []struct {
+		Width  float64 `json:"width"`
+		Height float64 `json:"height"`
+		Blocks []struct {
+			BoundingBox googlePolygon `json:"boundingBox"`
+			Paragraphs  []struct {
+				Words []struct {
+					BoundingBox googlePolygon `json:"boundingBox"`
+					Confidence  float64       `json:"confidence"`
+					Symbols     []struct {
+						Text     string `json:"text"`
+						Property *struct {
+							DetectedBreak *struct {
+								Type string `json:"type"`
+							} `json:"detectedBreak"`
+						} `json:"property"`
+					} `json:"symbols"`
+				} `json:"words"`
+			} `json:"paragraphs"`
+		} `json:"blocks"`
+	} `json:"pages"`
+}
+
+type googlePolygon struct {
+	Vertices []struct {
+		X float64 `json:"x"`
+		Y float64 `json:"y"`
+	} `json:"vertices"`
+}
+
+func (p googlePolygon) box() Box {
+	points := make([][2]float64, 0, len(p.Vertices))
+	for _, v := range p.Vertices {
+		points = append(points, [2]float64{v.X, v.Y})
+	}
+	return boundingBox(points)
+}
+
+// parseGoogle принимает как ответ images:annotate целиком, так и
+// одиночный AnnotateImageResponse или fullTextAnnotation.
+func parseGoogle(raw json.RawMessage) (Page, bool) {
+	var resp struct {
+		Responses []struct {
+			FullTextAnnotation *googleAnnotation `json:"fullTextAnnotation"`
+		} `json:"responses"`
+		FullTextAnnotation *googleAnnotation `json:"fullTextAnnotation"`
+		googleAnnotation
+	}
+	if json.Unmarshal(raw, &resp) != nil {
+		return Page{}, false
+	}
+
+	annotation := resp.FullTextAnnotation
+	if len(resp.Responses) > 0 && resp.Responses[0].FullTextAnnotation != nil {
+		annotation = resp.Responses[0].FullTextAnnotation
+	}
+	if annotation == nil && len(resp.Pages) > 0 {
+		annotation = &resp.googleAnnotation
+	}
+	if annotation == nil || len(annotation.Pages) == 0 {
+		return Page{}, false
+	}
+
+	// Google не выделяет строки: строка заканчивается на символе
+	// с переносом (LINE_BREAK, EOL_SURE_SPACE).
+	src := annotation.Pages[0]
+	page := Page{Width: src.Width, Height: src.Height}
+	for _, b := range src.Blocks {
+		block := Block{Box: b.BoundingBox.box()}
+		for _, paragraph := range b.Paragraphs {
+			var line Line
+			flush := func() {
+				if len(line.Words) == 0 {
+					return
+				}
+				texts := make([]string, len(line.Words))
+				for i, w := range line.Words {
+					texts[i] = w.Text
+				}
+				line.Text = strings.Join(texts, " ")
+				block.Lines = append(block.Lines, line)
+				line = Line{}
+			}
+
+			for _, w := range paragraph.Words {
+				var text strings.Builder
+				lineBreak := false
+				for _, symbol := range w.Symbols {
+					text.WriteString(symbol.Text)
+					if symbol.Property != nil && symbol.Property.DetectedBreak != nil {
+						switch symbol.Property.DetectedBreak.Type {
+						case "LINE_BREAK", "EOL_SURE_SPACE":
+							lineBreak = true
+						}
+					}
+				}
+				word := Word{Text: text.String(), Box: w.BoundingBox.box(), Confidence: w.Confidence}
+				line.Words = append(line.Words, word)
+				line.Box = line.Box.Union(word.Box)
+				if lineBreak {
+					flush()
+				}
+			}
+			flush()
+		}
+		page.Blocks = append(page.Blocks, block)
+	}
+	return page, true
+}
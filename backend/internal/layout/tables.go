@@ -0,0 +1,83 @@
+package layout
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Table — таблица на странице: модель table Yandex Vision или таблица
+// Markdown в ответе Gemini. Индексы строк и столбцов с 0.
+type Table struct {
+	Box     Box // пустой, если провайдер не сообщил координаты
+	Rows    int
+	Columns int
+	Cells   []Cell
+}
+
+// Cell — ячейка таблицы; объединённая ячейка занимает RowSpan × ColSpan.
+type Cell struct {
+	Row, Column      int
+	RowSpan, ColSpan int // не меньше 1
+	Text             string
+	Box              Box
+}
+
+// Grid раскладывает ячейки по сетке Rows × Columns. Текст объединённой
+// ячейки стоит в её левом верхнем углу, остальные позиции пустые.
+func (t Table) Grid() [][]string {
+	grid := make([][]string, t.Rows)
+	for i := range grid {
+		grid[i] = make([]string, t.Columns)
+	}
+	for _, c := range t.Cells {
+		if c.Row >= 0 && c.Row < t.Rows && c.Column >= 0 && c.Column < t.Columns {
+			grid[c.Row][c.Column] = c.Text
+		}
+	}
+	return grid
+}
+
+// markdownSeparator — строка-разделитель под заголовком таблицы Markdown.
+var markdownSeparator = regexp.MustCompile(`^\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?$`)
+
+// markdownTables находит в тексте таблицы Markdown: подряд идущие
+// строки, начинающиеся с «|», вторая из которых — разделитель.
+func markdownTables(s string) []Table {
+	var tables []Table
+	var rows []string
+
+	flush := func() {
+		if len(rows) >= 2 && markdownSeparator.MatchString(rows[1]) {
+			tables = append(tables, markdownTable(append(rows[:1:1], rows[2:]...)))
+		}
+		rows = nil
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "|") {
+			rows = append(rows, line)
+			continue
+		}
+		flush()
+	}
+	flush()
+	return tables
+}
+
+func markdownTable(rows []string) Table {
+	var table Table
+	for r, row := range rows {
+		row = strings.TrimSuffix(strings.TrimPrefix(row, "|"), "|")
+		cells := strings.Split(row, "|")
+		for c, text := range cells {
+			table.Cells = append(table.Cells, Cell{
+				Row: r, Column: c, RowSpan: 1, ColSpan: 1,
+				Text: strings.TrimSpace(text),
+			})
+		}
+		table.Columns = max(table.Columns, len(cells))
+	}
+	table.Rows = len(rows)
+	return table
+}
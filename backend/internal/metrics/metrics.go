@@ -0,0 +1,231 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/ratelimiter"
+	"github.com/airsss993/ocr-history/internal/services"
+	"github.com/airsss993/ocr-history/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "ocr"
+
+// Metrics — реестр метрик сервиса. Метки ограничены шаблоном маршрута,
+// методом, кодом ответа и провайдером, чтобы не раздувать число рядов.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+
+	providerDuration *prometheus.HistogramVec
+	providerErrors   *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route template, method and status code.",
+		}, []string{"route", "method", "status"}),
+
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route template and method.",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		}, []string{"route", "method"}),
+
+		providerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "provider_request_duration_seconds",
+			Help:      "Latency of a single recognition call to an OCR provider.",
+			Buckets:   []float64{0.25, 0.5, 1, 2, 5, 10, 20, 40, 60, 120, 240},
+		}, []string{"provider", "outcome"}),
+
+		providerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "provider_errors_total",
+			Help:      "Failed recognition calls to an OCR provider.",
+		}, []string{"provider"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.providerDuration,
+		m.providerErrors,
+	)
+
+	return m
+}
+
+// Handler отдаёт метрики в формате Prometheus.
+func (m *Metrics) Handler() gin.HandlerFunc {
+	h := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return gin.WrapH(h)
+}
+
+// Middleware считает запросы и их длительность по шаблону маршрута.
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+
+		m.httpRequests.WithLabelValues(route, method, strconv.Itoa(c.Writer.Status())).Inc()
+		m.httpDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+	}
+}
+
+func (m *Metrics) observeProvider(provider string, duration time.Duration, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+		m.providerErrors.WithLabelValues(provider).Inc()
+	}
+	m.providerDuration.WithLabelValues(provider, outcome).Observe(duration.Seconds())
+}
+
+// RegisterOCRService публикует занятость рабочих слотов сервиса.
+func (m *Metrics) RegisterOCRService(svc *services.OCRService) {
+	labels := prometheus.Labels{"provider": svc.Provider()}
+
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "worker_slots_in_use",
+			Help:        "Occupied OCR worker slots.",
+			ConstLabels: labels,
+		}, func() float64 {
+			inUse, _ := svc.WorkerSlots()
+			return float64(inUse)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "worker_slots_capacity",
+			Help:        "Total OCR worker slots.",
+			ConstLabels: labels,
+		}, func() float64 {
+			_, capacity := svc.WorkerSlots()
+			return float64(capacity)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "jobs_in_flight",
+			Help:        "Image batches currently being processed.",
+			ConstLabels: labels,
+		}, func() float64 {
+			return float64(len(svc.Jobs()))
+		}),
+	)
+}
+
+// RegisterYandexLimiter публикует очередь и свободные токены лимитера Yandex.
+func (m *Metrics) RegisterYandexLimiter(rl *ratelimiter.YandexRateLimiter) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "yandex_limiter_waiting",
+			Help:      "Requests waiting for a Yandex rate limiter token.",
+		}, func() float64 {
+			return float64(rl.State().Waiting)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "yandex_limiter_tokens_available",
+			Help:      "Tokens currently available in the Yandex rate limiter.",
+		}, func() float64 {
+			return float64(rl.State().Available)
+		}),
+	)
+}
+
+// RegisterHistory публикует размер хранилища истории и долю попаданий в него.
+func (m *Metrics) RegisterHistory(history *storage.HistoryStorage) {
+	labels := prometheus.Labels{"backend": history.Backend()}
+
+	total := func() (clients, entries, size float64) {
+		for _, st := range history.Stats() {
+			clients++
+			entries += float64(st.Entries)
+			size += float64(st.SizeBytes)
+		}
+		return clients, entries, size
+	}
+
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "history_clients",
+			Help:        "Clients with stored history.",
+			ConstLabels: labels,
+		}, func() float64 {
+			clients, _, _ := total()
+			return clients
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "history_entries",
+			Help:        "Stored history entries.",
+			ConstLabels: labels,
+		}, func() float64 {
+			_, entries, _ := total()
+			return entries
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "history_size_bytes",
+			Help:        "Approximate size of stored history (images and results).",
+			ConstLabels: labels,
+		}, func() float64 {
+			_, _, size := total()
+			return size
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "history_cache_hits_total",
+			Help:        "History reads that found the client's data in the store.",
+			ConstLabels: labels,
+		}, func() float64 {
+			hits, _ := history.CacheStats()
+			return float64(hits)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "history_cache_misses_total",
+			Help:        "History reads for clients whose data was expired or never stored.",
+			ConstLabels: labels,
+		}, func() float64 {
+			_, misses := history.CacheStats()
+			return float64(misses)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "history_cache_hit_ratio",
+			Help:        "Share of history reads served from the store since start.",
+			ConstLabels: labels,
+		}, func() float64 {
+			hits, misses := history.CacheStats()
+			if hits+misses == 0 {
+				return 0
+			}
+			return float64(hits) / float64(hits+misses)
+		}),
+	)
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/repository"
+)
+
+// InstrumentedRepository замеряет длительность и ошибки вызовов провайдера.
+type InstrumentedRepository struct {
+	repo     repository.OCRRepository
+	metrics  *Metrics
+	provider string
+}
+
+func (m *Metrics) Instrument(provider string, repo repository.OCRRepository) *InstrumentedRepository {
+	return &InstrumentedRepository{
+		repo:     repo,
+		metrics:  m,
+		provider: provider,
+	}
+}
+
+func (r *InstrumentedRepository) RecognizeFromBytes(ctx context.Context, data []byte, mimeType string) (string, error) {
+	start := time.Now()
+	text, err := r.repo.RecognizeFromBytes(ctx, data, mimeType)
+	r.metrics.observeProvider(r.provider, time.Since(start), err)
+	return text, err
+}
+
+// RecognizeWithUsage сохраняет учёт токенов, если его поддерживает провайдер.
+func (r *InstrumentedRepository) RecognizeWithUsage(ctx context.Context, data []byte, mimeType string) (string, repository.Usage, error) {
+	reporter, ok := r.repo.(repository.UsageReporter)
+	if !ok {
+		text, err := r.RecognizeFromBytes(ctx, data, mimeType)
+		return text, repository.Usage{}, err
+	}
+
+	start := time.Now()
+	text, usage, err := reporter.RecognizeWithUsage(ctx, data, mimeType)
+	r.metrics.observeProvider(r.provider, time.Since(start), err)
+	return text, usage, err
+}
+
+// MaxDocumentPages пробрасывает поддержку документов обёрнутого провайдера.
+func (r *InstrumentedRepository) MaxDocumentPages(mimeType string) int {
+	if doc, ok := r.repo.(repository.DocumentRecognizer); ok {
+		return doc.MaxDocumentPages(mimeType)
+	}
+	return 0
+}
+
+// RecognizePages пробрасывает постраничное распознавание документа.
+func (r *InstrumentedRepository) RecognizePages(ctx context.Context, data []byte, mimeType string) ([]string, error) {
+	pager, ok := r.repo.(repository.PageRecognizer)
+	if !ok {
+		return nil, repository.ErrPagesUnsupported
+	}
+
+	start := time.Now()
+	pages, err := pager.RecognizePages(ctx, data, mimeType)
+	if errors.Is(err, repository.ErrPagesUnsupported) {
+		return nil, err
+	}
+	r.metrics.observeProvider(r.provider, time.Since(start), err)
+	return pages, err
+}
+
+// ResumeOperation пробрасывает досчёт асинхронной операции после перезапуска.
+func (r *InstrumentedRepository) ResumeOperation(ctx context.Context, id string) ([]string, error) {
+	resumer, ok := r.repo.(repository.OperationResumer)
+	if !ok {
+		return nil, repository.ErrResumeUnsupported
+	}
+
+	start := time.Now()
+	pages, err := resumer.ResumeOperation(ctx, id)
+	r.metrics.observeProvider(r.provider, time.Since(start), err)
+	return pages, err
+}
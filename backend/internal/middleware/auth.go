@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/airsss993/ocr-history/internal/auth"
+	"github.com/airsss993/ocr-history/internal/config"
+	"github.com/airsss993/ocr-history/internal/domain"
+	"github.com/airsss993/ocr-history/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+const principalKey = "principal"
+
+// Authenticate определяет Principal по заголовку X-API-Key (или устаревшему
+// X-Gemini-API-Key) либо по JWT пользователя в Authorization: Bearer.
+// Запросы без учётных данных получают AnonymousScopes, с неверными — отклоняются.
+func Authenticate(keys *auth.KeyStore, tokens *auth.TokenIssuer, cfg config.Auth) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw := c.GetHeader("X-API-Key")
+		if raw == "" {
+			raw = c.GetHeader("X-Gemini-API-Key")
+		}
+
+		bearer, hasBearer := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if raw == "" && hasBearer && tokens != nil {
+			userID, err := tokens.Parse(bearer)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, domain.ErrorResponse{
+					Error:   "authentication_error",
+					Message: "Invalid or expired token",
+				})
+				return
+			}
+
+			setPrincipal(c, &auth.Principal{
+				UserID: userID,
+				Owner:  auth.UserOwner(userID),
+				Scopes: slices.Clone(cfg.UserScopes),
+			})
+			c.Next()
+			return
+		}
+
+		if raw == "" {
+			setPrincipal(c, &auth.Principal{Scopes: slices.Clone(cfg.AnonymousScopes)})
+			c.Next()
+			return
+		}
+
+		principal, err := keys.Authenticate(raw)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, domain.ErrorResponse{
+				Error:   "authentication_error",
+				Message: "Invalid or missing authentication key",
+			})
+			return
+		}
+
+		setPrincipal(c, principal)
+		c.Next()
+	}
+}
+
+// setPrincipal сохраняет Principal и добавляет владельца в логгер запроса.
+func setPrincipal(c *gin.Context, principal *auth.Principal) {
+	c.Set(principalKey, principal)
+	if principal.Owner != "" {
+		c.Request = c.Request.WithContext(logger.WithFields(c.Request.Context(), "owner", principal.Owner))
+	}
+}
+
+// RequireScope пропускает только запросы, у которых есть область доступа scope.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := GetPrincipal(c)
+		if principal.HasScope(scope) {
+			c.Next()
+			return
+		}
+
+		if principal.Anonymous() {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, domain.ErrorResponse{
+				Error:   "authentication_error",
+				Message: "Invalid or missing authentication key",
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, domain.ErrorResponse{
+			Error:   "permission_denied",
+			Message: "api key lacks scope " + scope,
+		})
+	}
+}
+
+// GetPrincipal возвращает Principal, установленный Authenticate.
+func GetPrincipal(c *gin.Context) *auth.Principal {
+	if v, ok := c.Get(principalKey); ok {
+		if p, ok := v.(*auth.Principal); ok {
+			return p
+		}
+	}
+	return &auth.Principal{}
+}
@@ -8,7 +8,8 @@ func CORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Gemini-API-Key, X-Client-ID")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-API-Key, X-Gemini-API-Key, X-Client-ID, X-Claim-Token, X-Request-ID")
+		c.Header("Access-Control-Expose-Headers", "Retry-After, X-Request-ID, X-Claim-Token, X-Quota-Remaining-Images, X-Quota-Remaining-Pages, X-Quota-Remaining-Tokens, X-Quota-Remaining-Budget, X-Quota-Reset")
 		c.Header("Access-Control-Max-Age", "86400")
 
 		if c.Request.Method == "OPTIONS" {
@@ -2,32 +2,58 @@ package middleware
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 )
 
 type RateLimiter struct {
-	semaphore chan struct{}
+	mu            sync.Mutex
+	inFlight      int
+	maxConcurrent int
 }
 
 func NewRateLimiter(maxConcurrent int) *RateLimiter {
 	return &RateLimiter{
-		semaphore: make(chan struct{}, maxConcurrent),
+		maxConcurrent: maxConcurrent,
 	}
 }
 
+// SetLimit меняет допустимое число одновременных запросов; уже идущие не прерываются.
+func (rl *RateLimiter) SetLimit(maxConcurrent int) {
+	rl.mu.Lock()
+	rl.maxConcurrent = maxConcurrent
+	rl.mu.Unlock()
+}
+
+func (rl *RateLimiter) tryAcquire() bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.inFlight >= rl.maxConcurrent {
+		return false
+	}
+	rl.inFlight++
+	return true
+}
+
+func (rl *RateLimiter) release() {
+	rl.mu.Lock()
+	rl.inFlight--
+	rl.mu.Unlock()
+}
+
 func (rl *RateLimiter) Limit() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		select {
-		case rl.semaphore <- struct{}{}:
-			defer func() { <-rl.semaphore }()
-			c.Next()
-		default:
+		if !rl.tryAcquire() {
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error":   "too many concurrent requests",
 				"message": "server is busy, please try again later",
 			})
 			c.Abort()
+			return
 		}
+		defer rl.release()
+		c.Next()
 	}
 }
@@ -0,0 +1,60 @@
+// Package multipage разбивает многостраничные файлы (PDF, TIFF)
+// на отдельные изображения страниц, которые понимают провайдеры OCR.
+package multipage
+
+import (
+	"fmt"
+
+	"github.com/airsss993/ocr-history/internal/filetype"
+	"github.com/airsss993/ocr-history/internal/pdf"
+)
+
+// Page — одна страница файла. Err задан, если страницу не удалось извлечь;
+// остальные страницы при этом обрабатываются.
+type Page struct {
+	Number int // с 1
+	Data   []byte
+	Format filetype.Format
+	Err    error
+}
+
+// IsMultipage сообщает, нужно ли разбивать файл формата на страницы.
+func IsMultipage(format filetype.Format) bool {
+	return format.Name == filetype.PDF.Name || format.Name == filetype.TIFF.Name
+}
+
+// Split разбивает файл на страницы; файлы длиннее maxPages отклоняются целиком.
+func Split(data []byte, format filetype.Format, maxPages int) ([]Page, error) {
+	switch format.Name {
+	case filetype.PDF.Name:
+		doc, err := pdf.Open(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PDF: %w", err)
+		}
+		return SplitPDF(doc, maxPages)
+	case filetype.TIFF.Name:
+		return splitTIFF(data, maxPages)
+	}
+	return nil, fmt.Errorf("%s files cannot be split into pages", format.Name)
+}
+
+// SplitPDF извлекает изображения страниц уже открытого документа.
+func SplitPDF(doc *pdf.Document, maxPages int) ([]Page, error) {
+	if err := checkPageCount(doc.NumPages(), maxPages); err != nil {
+		return nil, err
+	}
+
+	pages := make([]Page, doc.NumPages())
+	for i := range pages {
+		pages[i].Number = i + 1
+		pages[i].Data, pages[i].Format, pages[i].Err = doc.PageImage(i)
+	}
+	return pages, nil
+}
+
+func checkPageCount(count, maxPages int) error {
+	if maxPages > 0 && count > maxPages {
+		return fmt.Errorf("file has %d pages, maximum is %d", count, maxPages)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package multipage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image/png"
+	"io"
+
+	"github.com/airsss993/ocr-history/internal/filetype"
+	"github.com/airsss993/ocr-history/internal/preprocess"
+	"golang.org/x/image/tiff"
+)
+
+// splitTIFF проходит по цепочке IFD и декодирует каждую страницу,
+// подменяя в заголовке смещение первого IFD: golang.org/x/image/tiff
+// читает только первую страницу. Страницы перекодируются в PNG —
+// TIFF не принимает ни один провайдер.
+func splitTIFF(data []byte, maxPages int) ([]Page, error) {
+	offsets, err := tiffPageOffsets(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPageCount(len(offsets), maxPages); err != nil {
+		return nil, err
+	}
+
+	order := tiffByteOrder(data)
+	pages := make([]Page, len(offsets))
+	for i, offset := range offsets {
+		pages[i].Number = i + 1
+
+		// Размер страницы проверяется по заголовку до декодирования,
+		// как в preprocess: IFD может объявить любые ширину и высоту.
+		cfg, err := tiff.DecodeConfig(&tiffPage{data: data, order: order, offset: offset})
+		if err != nil {
+			pages[i].Err = fmt.Errorf("failed to decode TIFF page: %w", err)
+			continue
+		}
+		if err := preprocess.CheckPixels(cfg.Width, cfg.Height); err != nil {
+			pages[i].Err = fmt.Errorf("TIFF page %w", err)
+			continue
+		}
+
+		img, err := tiff.Decode(&tiffPage{data: data, order: order, offset: offset})
+		if err != nil {
+			pages[i].Err = fmt.Errorf("failed to decode TIFF page: %w", err)
+			continue
+		}
+
+		var buf bytes.Buffer
+		encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+		if err := encoder.Encode(&buf, img); err != nil {
+			pages[i].Err = fmt.Errorf("failed to encode page image: %w", err)
+			continue
+		}
+		pages[i].Data, pages[i].Format = buf.Bytes(), filetype.PNG
+	}
+	return pages, nil
+}
+
+func tiffByteOrder(data []byte) binary.ByteOrder {
+	if bytes.HasPrefix(data, []byte("MM")) {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// tiffPageOffsets возвращает смещения IFD всех страниц.
+func tiffPageOffsets(data []byte) ([]uint32, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("TIFF header is truncated")
+	}
+	order := tiffByteOrder(data)
+
+	var offsets []uint32
+	seen := make(map[uint32]bool)
+	for offset := order.Uint32(data[4:8]); offset != 0; {
+		if seen[offset] {
+			break // зацикленная цепочка IFD
+		}
+		seen[offset] = true
+
+		if int64(offset)+2 > int64(len(data)) {
+			return nil, fmt.Errorf("TIFF page directory is out of bounds")
+		}
+		entries := int64(order.Uint16(data[offset : offset+2]))
+		next := int64(offset) + 2 + entries*12
+		if next+4 > int64(len(data)) {
+			return nil, fmt.Errorf("TIFF page directory is truncated")
+		}
+
+		offsets = append(offsets, offset)
+		offset = order.Uint32(data[next : next+4])
+	}
+
+	if len(offsets) == 0 {
+		return nil, fmt.Errorf("TIFF has no pages")
+	}
+	return offsets, nil
+}
+
+// tiffPage отдаёт файл, в заголовке которого первым указан IFD нужной страницы.
+type tiffPage struct {
+	data   []byte
+	order  binary.ByteOrder
+	offset uint32
+	pos    int64
+}
+
+func (p *tiffPage) ReadAt(b []byte, off int64) (int, error) {
+	if off >= int64(len(p.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, p.data[off:])
+
+	var header [8]byte
+	copy(header[:4], p.data[:4])
+	p.order.PutUint32(header[4:], p.offset)
+	for i := off; i < 8 && i < off+int64(n); i++ {
+		b[i-off] = header[i]
+	}
+
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (p *tiffPage) Read(b []byte) (int, error) {
+	n, err := p.ReadAt(b, p.pos)
+	p.pos += int64(n)
+	return n, err
+}
@@ -0,0 +1,158 @@
+package multipage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/airsss993/ocr-history/internal/filetype"
+)
+
+// tiffPageSpec — несжатая страница в оттенках серого; width и height
+// пишутся в IFD как есть и могут не совпадать с длиной pixels.
+type tiffPageSpec struct {
+	width, height uint32
+	pixels        []byte
+}
+
+// buildTIFF собирает little-endian TIFF: данные каждой страницы, за ними её IFD.
+func buildTIFF(pages ...tiffPageSpec) []byte {
+	le := binary.LittleEndian
+	buf := []byte("II*\x00\x00\x00\x00\x00")
+
+	prevNext := 4 // где записать смещение следующего IFD
+	for _, page := range pages {
+		stripOffset := uint32(len(buf))
+		buf = append(buf, page.pixels...)
+		if len(buf)%2 == 1 {
+			buf = append(buf, 0)
+		}
+
+		ifd := uint32(len(buf))
+		le.PutUint32(buf[prevNext:], ifd)
+
+		entries := []struct {
+			tag, typ uint16
+			value    uint32
+		}{
+			{256, 4, page.width},               // ImageWidth
+			{257, 4, page.height},              // ImageLength
+			{258, 3, 8},                        // BitsPerSample
+			{259, 3, 1},                        // Compression: нет
+			{262, 3, 1},                        // PhotometricInterpretation: BlackIsZero
+			{273, 4, stripOffset},              // StripOffsets
+			{278, 4, page.height},              // RowsPerStrip
+			{279, 4, uint32(len(page.pixels))}, // StripByteCounts
+		}
+		buf = le.AppendUint16(buf, uint16(len(entries)))
+		for _, e := range entries {
+			buf = le.AppendUint16(buf, e.tag)
+			buf = le.AppendUint16(buf, e.typ)
+			buf = le.AppendUint32(buf, 1)
+			if e.typ == 3 {
+				buf = le.AppendUint16(buf, uint16(e.value))
+				buf = le.AppendUint16(buf, 0)
+			} else {
+				buf = le.AppendUint32(buf, e.value)
+			}
+		}
+		prevNext = len(buf)
+		buf = le.AppendUint32(buf, 0)
+	}
+	return buf
+}
+
+func TestSplitTIFF(t *testing.T) {
+	data := buildTIFF(
+		tiffPageSpec{3, 2, []byte{0, 1, 2, 3, 4, 5}},
+		tiffPageSpec{1, 4, []byte{6, 7, 8, 9}},
+	)
+
+	pages, err := Split(data, filetype.TIFF, 0)
+	if err != nil {
+		t.Fatalf("Split: %v", err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("got %d pages, want 2", len(pages))
+	}
+
+	sizes := [][2]int{{3, 2}, {1, 4}}
+	for i, page := range pages {
+		if page.Err != nil {
+			t.Fatalf("page %d: %v", page.Number, page.Err)
+		}
+		if page.Number != i+1 || page.Format.Name != filetype.PNG.Name {
+			t.Errorf("page %d: number %d, format %s", i+1, page.Number, page.Format.Name)
+		}
+		img, err := png.Decode(bytes.NewReader(page.Data))
+		if err != nil {
+			t.Fatalf("page %d is not PNG: %v", page.Number, err)
+		}
+		if b := img.Bounds(); b.Dx() != sizes[i][0] || b.Dy() != sizes[i][1] {
+			t.Errorf("page %d is %dx%d, want %dx%d", page.Number, b.Dx(), b.Dy(), sizes[i][0], sizes[i][1])
+		}
+	}
+
+	if _, err := Split(data, filetype.TIFF, 1); err == nil {
+		t.Error("Split accepted more pages than maxPages")
+	}
+}
+
+// TestSplitTIFFHugePage: страница, объявившая огромные размеры, получает
+// ошибку до декодирования, а остальные страницы извлекаются.
+func TestSplitTIFFHugePage(t *testing.T) {
+	data := buildTIFF(
+		tiffPageSpec{0x7fffffff, 0x7fffffff, []byte{0, 0}},
+		tiffPageSpec{2, 1, []byte{1, 2}},
+	)
+
+	pages, err := Split(data, filetype.TIFF, 0)
+	if err != nil {
+		t.Fatalf("Split: %v", err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("got %d pages, want 2", len(pages))
+	}
+	if pages[0].Err == nil || !strings.Contains(pages[0].Err.Error(), "megapixels") {
+		t.Errorf("huge page error = %v, want the pixel limit", pages[0].Err)
+	}
+	if pages[1].Err != nil {
+		t.Errorf("page 2: %v", pages[1].Err)
+	}
+}
+
+// TestSplitTIFFTruncated разбирает все префиксы файла: повреждённый TIFF
+// даёт ошибку файла или страницы, но не панику.
+func TestSplitTIFFTruncated(t *testing.T) {
+	data := buildTIFF(
+		tiffPageSpec{2, 2, []byte{1, 2, 3, 4}},
+		tiffPageSpec{2, 2, []byte{5, 6, 7, 8}},
+	)
+
+	for n := 0; n < len(data); n++ {
+		pages, err := Split(data[:n], filetype.TIFF, 0)
+		if err != nil {
+			continue
+		}
+		for _, page := range pages {
+			if page.Err == nil && page.Data == nil {
+				t.Errorf("prefix %d: page %d has neither data nor error", n, page.Number)
+			}
+		}
+	}
+}
+
+func TestSplitTIFFRejects(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":           nil,
+		"short header":    []byte("II*\x00"),
+		"no pages":        []byte("II*\x00\x00\x00\x00\x00"),
+		"IFD out of file": []byte("II*\x00\xff\xff\x00\x00"),
+	} {
+		if _, err := Split(data, filetype.TIFF, 0); err == nil {
+			t.Errorf("%s: Split succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// DefaultDecodeLimit — предел распакованного потока, пока не вызван SetDecodeLimit.
+const DefaultDecodeLimit = 256 << 20
+
+// decodeLimit ограничивает размер потока после фильтров: небольшой PDF
+// с FlateDecode может распаковаться в гигабайты.
+var decodeLimit atomic.Int64
+
+func init() {
+	decodeLimit.Store(DefaultDecodeLimit)
+}
+
+// SetDecodeLimit задаёт предел распакованного потока в байтах; n <= 0 —
+// DefaultDecodeLimit.
+func SetDecodeLimit(n int64) {
+	if n <= 0 {
+		n = DefaultDecodeLimit
+	}
+	decodeLimit.Store(n)
+}
+
+// errDecodeLimit сообщает о потоке, превысившем предел.
+func errDecodeLimit(limit int64) error {
+	return fmt.Errorf("decoded PDF stream exceeds %d MB", limit>>20)
+}
+
+// imageCodecs — фильтры, результат которых — уже сжатое изображение.
+// Они могут стоять только последними в цепочке.
+var imageCodecs = map[Name]bool{
+	"DCTDecode":      true,
+	"CCITTFaxDecode": true,
+	"JPXDecode":      true,
+	"JBIG2Decode":    true,
+}
+
+// filterAbbreviations — сокращённые имена фильтров во встроенных изображениях.
+var filterAbbreviations = map[Name]Name{
+	"Fl":  "FlateDecode",
+	"AHx": "ASCIIHexDecode",
+	"A85": "ASCII85Decode",
+	"DCT": "DCTDecode",
+	"CCF": "CCITTFaxDecode",
+	"LZW": "LZWDecode",
+	"RL":  "RunLengthDecode",
+}
+
+// Decode применяет к потоку все фильтры; кодеки изображений не поддерживаются.
+func (d *Document) Decode(s *Stream) ([]byte, error) {
+	data, codec, _, err := d.decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if codec != "" {
+		return nil, fmt.Errorf("unexpected image filter %s", codec)
+	}
+	return data, nil
+}
+
+// decode применяет фильтры потока и останавливается на кодеке изображения,
+// возвращая его имя и параметры.
+func (d *Document) decode(s *Stream) ([]byte, Name, Dict, error) {
+	var filters []Name
+	switch f := d.Resolve(s.Dict["Filter"]).(type) {
+	case Name:
+		filters = []Name{f}
+	case Array:
+		for _, v := range f {
+			if name, ok := d.Resolve(v).(Name); ok {
+				filters = append(filters, name)
+			}
+		}
+	}
+
+	var parms []Dict
+	switch p := d.Resolve(s.Dict["DecodeParms"]).(type) {
+	case Dict:
+		parms = []Dict{p}
+	case Array:
+		for _, v := range p {
+			dict, _ := d.Resolve(v).(Dict)
+			parms = append(parms, dict)
+		}
+	}
+
+	limit := decodeLimit.Load()
+	data := s.Raw
+	for i, filter := range filters {
+		if full, ok := filterAbbreviations[filter]; ok {
+			filter = full
+		}
+		var parm Dict
+		if i < len(parms) {
+			parm = parms[i]
+		}
+
+		if imageCodecs[filter] {
+			if i != len(filters)-1 {
+				return nil, "", nil, fmt.Errorf("filter %s must be the last one", filter)
+			}
+			return data, filter, parm, nil
+		}
+
+		var err error
+		switch filter {
+		case "FlateDecode":
+			data, err = inflate(data, limit)
+			if err == nil {
+				data, err = d.unpredict(data, parm)
+			}
+		case "ASCIIHexDecode":
+			data, err = asciiHexDecode(data)
+		case "ASCII85Decode":
+			data, err = ascii85Decode(data)
+		case "RunLengthDecode":
+			data, err = runLengthDecode(data, limit)
+		default:
+			err = fmt.Errorf("unsupported PDF filter %s", filter)
+		}
+		if err != nil {
+			return nil, "", nil, err
+		}
+	}
+	return data, "", nil, nil
+}
+
+func inflate(data []byte, limit int64) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		// Некоторые генераторы пишут deflate без заголовка zlib.
+		return readLenient(flate.NewReader(bytes.NewReader(data)), limit)
+	}
+	defer zr.Close()
+	return readLenient(zr, limit)
+}
+
+// readLenient возвращает прочитанное, даже если поток обрезан:
+// в отсканированных PDF это обычно теряет лишь последние байты.
+// Поток длиннее limit — ошибка.
+func readLenient(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if int64(len(data)) > limit {
+		return nil, errDecodeLimit(limit)
+	}
+	if err != nil && len(data) == 0 {
+		return nil, fmt.Errorf("failed to inflate stream: %w", err)
+	}
+	return data, nil
+}
+
+// unpredict снимает предсказатель (/Predictor) после FlateDecode.
+func (d *Document) unpredict(data []byte, parm Dict) ([]byte, error) {
+	predictor := d.intParam(parm, "Predictor", 1)
+	if predictor == 1 {
+		return data, nil
+	}
+
+	// Colors, BitsPerComponent и Columns задаёт файл, поэтому строка
+	// длиннее самих данных отклоняется до выделения памяти.
+	colors := d.intParam(parm, "Colors", 1)
+	bpc := d.intParam(parm, "BitsPerComponent", 8)
+	columns := d.intParam(parm, "Columns", 1)
+	maxBits := 8 * int64(len(data))
+	if colors <= 0 || bpc <= 0 || columns <= 0 || int64(colors)*int64(bpc) > maxBits || int64(columns) > maxBits {
+		return nil, fmt.Errorf("invalid predictor parameters")
+	}
+	rowBits := int64(colors) * int64(bpc) * int64(columns)
+	if rowBits > maxBits {
+		return nil, fmt.Errorf("predictor row of %d bits is longer than the stream", rowBits)
+	}
+	rowBytes := int((rowBits + 7) / 8)
+	bpp := (colors*bpc + 7) / 8
+
+	if predictor == 2 {
+		if bpc != 8 {
+			return nil, fmt.Errorf("TIFF predictor with %d bits per component is not supported", bpc)
+		}
+		for row := 0; row+rowBytes <= len(data); row += rowBytes {
+			for i := row + colors; i < row+rowBytes; i++ {
+				data[i] += data[i-colors]
+			}
+		}
+		return data, nil
+	}
+
+	// PNG-предсказатели: перед каждой строкой байт с типом фильтра.
+	out := make([]byte, 0, len(data))
+	prev := make([]byte, rowBytes)
+	for pos := 0; pos+1+rowBytes <= len(data); pos += 1 + rowBytes {
+		kind := data[pos]
+		row := append([]byte(nil), data[pos+1:pos+1+rowBytes]...)
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left = row[i-bpp]
+				upLeft = prev[i-bpp]
+			}
+			up := prev[i]
+			switch kind {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func asciiHexDecode(data []byte) ([]byte, error) {
+	var digits []byte
+	for _, c := range data {
+		if c == '>' {
+			break
+		}
+		if !isSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	if _, err := hex.Decode(out, digits); err != nil {
+		return nil, fmt.Errorf("invalid ASCIIHex data: %w", err)
+	}
+	return out, nil
+}
+
+func ascii85Decode(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))
+	if i := bytes.Index(data, []byte("~>")); i >= 0 {
+		data = data[:i]
+	}
+	out := make([]byte, 4*len(data)/5+4)
+	n, _, err := ascii85.Decode(out, data, true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ASCII85 data: %w", err)
+	}
+	return out[:n], nil
+}
+
+func runLengthDecode(data []byte, limit int64) ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(data); {
+		if int64(len(out)) > limit {
+			return nil, errDecodeLimit(limit)
+		}
+		n := int(data[i])
+		i++
+		switch {
+		case n == 128:
+			return out, nil
+		case n < 128:
+			if i+n+1 > len(data) {
+				return nil, fmt.Errorf("truncated RunLength data")
+			}
+			out = append(out, data[i:i+n+1]...)
+			i += n + 1
+		default:
+			if i >= len(data) {
+				return nil, fmt.Errorf("truncated RunLength data")
+			}
+			out = append(out, bytes.Repeat(data[i:i+1], 257-n)...)
+			i++
+		}
+	}
+	if int64(len(out)) > limit {
+		return nil, errDecodeLimit(limit)
+	}
+	return out, nil
+}
+
+func (d *Document) intParam(dict Dict, key Name, def int) int {
+	if dict == nil {
+		return def
+	}
+	switch v := d.Resolve(dict[key]).(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return def
+}
+
+func (d *Document) boolParam(dict Dict, key Name) bool {
+	if dict == nil {
+		return false
+	}
+	v, _ := d.Resolve(dict[key]).(bool)
+	return v
+}
@@ -0,0 +1,429 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"regexp"
+
+	"github.com/airsss993/ocr-history/internal/filetype"
+	"github.com/airsss993/ocr-history/internal/preprocess"
+	"golang.org/x/image/ccitt"
+)
+
+// doOperator находит изображения, которые реально рисуются на странице:
+// словарь Resources бывает общим для всех страниц документа.
+var doOperator = regexp.MustCompile(`/([^\s/\[\]()<>{}%]+)\s+Do\b`)
+
+// PageImage возвращает самое крупное растровое изображение страницы
+// (номер с нуля): JPEG как есть, остальное — перекодированное в PNG.
+func (d *Document) PageImage(i int) ([]byte, filetype.Format, error) {
+	if i < 0 || i >= len(d.pages) {
+		return nil, filetype.Format{}, fmt.Errorf("page %d is out of range", i+1)
+	}
+	pg := d.pages[i]
+
+	s := d.largestImage(pg.resources, d.drawnNames(pg.dict["Contents"]), 0)
+	if s == nil {
+		return nil, filetype.Format{}, ErrNoImage
+	}
+
+	data, format, err := d.decodeImage(s)
+	if err != nil {
+		return nil, filetype.Format{}, err
+	}
+	if rotate := ((pg.rotate % 360) + 360) % 360; rotate != 0 {
+		return rotateEncoded(data, format, rotate)
+	}
+	return data, format, nil
+}
+
+// drawnNames возвращает имена XObject из операторов Do содержимого страницы;
+// nil, если содержимое прочитать не удалось.
+func (d *Document) drawnNames(contents Object) map[Name]bool {
+	var streams []*Stream
+	switch c := d.Resolve(contents).(type) {
+	case *Stream:
+		streams = []*Stream{c}
+	case Array:
+		for _, v := range c {
+			if s, ok := d.Resolve(v).(*Stream); ok {
+				streams = append(streams, s)
+			}
+		}
+	}
+	if len(streams) == 0 {
+		return nil
+	}
+
+	names := make(map[Name]bool)
+	for _, s := range streams {
+		data, err := d.Decode(s)
+		if err != nil {
+			return nil
+		}
+		for _, m := range doOperator.FindAllSubmatch(data, -1) {
+			names[Name(m[1])] = true
+		}
+	}
+	return names
+}
+
+func (d *Document) largestImage(resources Dict, drawn map[Name]bool, depth int) *Stream {
+	xobjects, _ := d.Resolve(resources["XObject"]).(Dict)
+
+	var (
+		best     *Stream
+		bestArea int
+	)
+	consider := func(s *Stream) {
+		if s == nil {
+			return
+		}
+		area := d.intParam(s.Dict, "Width", 0) * d.intParam(s.Dict, "Height", 0)
+		if area > bestArea {
+			best, bestArea = s, area
+		}
+	}
+
+	for name, v := range xobjects {
+		if drawn != nil && !drawn[name] {
+			continue
+		}
+		s, ok := d.Resolve(v).(*Stream)
+		if !ok {
+			continue
+		}
+		switch d.Resolve(s.Dict["Subtype"]) {
+		case Name("Image"):
+			consider(s)
+		case Name("Form"):
+			if depth < 4 {
+				formResources, _ := d.Resolve(s.Dict["Resources"]).(Dict)
+				consider(d.largestImage(formResources, d.drawnNames(s), depth+1))
+			}
+		}
+	}
+	return best
+}
+
+func (d *Document) decodeImage(s *Stream) ([]byte, filetype.Format, error) {
+	data, codec, parm, err := d.decode(s)
+	if err != nil {
+		return nil, filetype.Format{}, err
+	}
+
+	var img image.Image
+	switch codec {
+	case "DCTDecode":
+		return data, filetype.JPEG, nil
+	case "CCITTFaxDecode":
+		img, err = d.ccittImage(data, parm, s.Dict)
+	case "JPXDecode":
+		err = fmt.Errorf("JPEG 2000 page images are not supported")
+	case "JBIG2Decode":
+		err = fmt.Errorf("JBIG2 page images are not supported")
+	default:
+		img, err = d.rawImage(data, s.Dict)
+	}
+	if err != nil {
+		return nil, filetype.Format{}, err
+	}
+
+	return encodePNG(img)
+}
+
+func (d *Document) ccittImage(data []byte, parm, dict Dict) (image.Image, error) {
+	k := d.intParam(parm, "K", 0)
+	if k > 0 {
+		return nil, fmt.Errorf("mixed 2D CCITT Group 3 images are not supported")
+	}
+	subFormat := ccitt.Group3
+	if k < 0 {
+		subFormat = ccitt.Group4
+	}
+
+	width := d.intParam(parm, "Columns", 1728)
+	height := d.intParam(parm, "Rows", 0)
+	if height <= 0 {
+		height = d.intParam(dict, "Height", 0)
+	}
+	if err := preprocess.CheckPixels(width, height); err != nil {
+		return nil, fmt.Errorf("CCITT %w", err)
+	}
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	opts := &ccitt.Options{
+		Align:  d.boolParam(parm, "EncodedByteAlign"),
+		Invert: d.boolParam(parm, "BlackIs1"),
+	}
+	if err := ccitt.DecodeIntoGray(img, bytes.NewReader(data), ccitt.MSB, subFormat, opts); err != nil {
+		return nil, fmt.Errorf("failed to decode CCITT image: %w", err)
+	}
+
+	if d.inverted(dict) {
+		for i := range img.Pix {
+			img.Pix[i] = 255 - img.Pix[i]
+		}
+	}
+	return img, nil
+}
+
+// inverted сообщает, задан ли /Decode [1 0] для одноканального изображения.
+func (d *Document) inverted(dict Dict) bool {
+	decode, ok := d.Resolve(dict["Decode"]).(Array)
+	if !ok || len(decode) < 2 {
+		return false
+	}
+	first, _ := toFloat(d.Resolve(decode[0]))
+	return first == 1
+}
+
+func toFloat(v Object) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// colorSpace — упрощённое цветовое пространство изображения.
+type colorSpace struct {
+	components int
+	cmyk       bool
+	palette    color.Palette // для /Indexed
+}
+
+func (d *Document) colorSpace(v Object, depth int) (colorSpace, error) {
+	if depth > 4 {
+		return colorSpace{}, fmt.Errorf("color space nesting is too deep")
+	}
+
+	switch cs := d.Resolve(v).(type) {
+	case Name:
+		switch cs {
+		case "DeviceGray", "G", "CalGray":
+			return colorSpace{components: 1}, nil
+		case "DeviceRGB", "RGB", "CalRGB":
+			return colorSpace{components: 3}, nil
+		case "DeviceCMYK", "CMYK":
+			return colorSpace{components: 4, cmyk: true}, nil
+		}
+		return colorSpace{}, fmt.Errorf("unsupported color space %s", cs)
+	case Array:
+		if len(cs) == 0 {
+			break
+		}
+		family, _ := d.Resolve(cs[0]).(Name)
+		switch family {
+		case "CalGray":
+			return colorSpace{components: 1}, nil
+		case "CalRGB", "Lab":
+			return colorSpace{components: 3}, nil
+		case "ICCBased":
+			if len(cs) > 1 {
+				if s, ok := d.Resolve(cs[1]).(*Stream); ok {
+					switch n := d.intParam(s.Dict, "N", 3); n {
+					case 1, 3, 4:
+						return colorSpace{components: n, cmyk: n == 4}, nil
+					}
+				}
+			}
+		case "Indexed", "I":
+			if len(cs) == 4 {
+				return d.indexedColorSpace(cs, depth)
+			}
+		}
+		return colorSpace{}, fmt.Errorf("unsupported color space %s", family)
+	}
+	return colorSpace{}, fmt.Errorf("invalid color space")
+}
+
+func (d *Document) indexedColorSpace(cs Array, depth int) (colorSpace, error) {
+	base, err := d.colorSpace(cs[1], depth+1)
+	if err != nil {
+		return colorSpace{}, err
+	}
+	hival, _ := d.Resolve(cs[2]).(int)
+
+	var lookup []byte
+	switch l := d.Resolve(cs[3]).(type) {
+	case String:
+		lookup = []byte(l)
+	case *Stream:
+		if lookup, err = d.Decode(l); err != nil {
+			return colorSpace{}, err
+		}
+	}
+
+	palette := make(color.Palette, 0, hival+1)
+	for i := 0; i <= hival && (i+1)*base.components <= len(lookup); i++ {
+		palette = append(palette, toColor(lookup[i*base.components:(i+1)*base.components], base))
+	}
+	if len(palette) == 0 {
+		return colorSpace{}, fmt.Errorf("empty indexed color palette")
+	}
+	return colorSpace{components: 1, palette: palette}, nil
+}
+
+func toColor(c []byte, cs colorSpace) color.Color {
+	switch {
+	case cs.components == 1:
+		return color.Gray{Y: c[0]}
+	case cs.cmyk:
+		return color.CMYK{C: c[0], M: c[1], Y: c[2], K: c[3]}
+	default:
+		return color.RGBA{R: c[0], G: c[1], B: c[2], A: 255}
+	}
+}
+
+// rawImage собирает изображение из несжатых отсчётов.
+func (d *Document) rawImage(data []byte, dict Dict) (image.Image, error) {
+	// Width и Height задаёт сам файл: без проверки они переполняют
+	// rowBytes и размер растра.
+	width := d.intParam(dict, "Width", 0)
+	height := d.intParam(dict, "Height", 0)
+	if err := preprocess.CheckPixels(width, height); err != nil {
+		return nil, err
+	}
+
+	var (
+		cs  colorSpace
+		bpc int
+		err error
+	)
+	if d.boolParam(dict, "ImageMask") {
+		cs, bpc = colorSpace{components: 1}, 1
+	} else {
+		bpc = d.intParam(dict, "BitsPerComponent", 8)
+		if cs, err = d.colorSpace(dict["ColorSpace"], 0); err != nil {
+			return nil, err
+		}
+	}
+	switch bpc {
+	case 1, 2, 4, 8, 16:
+	default:
+		return nil, fmt.Errorf("unsupported bits per component: %d", bpc)
+	}
+
+	rowBytes := (width*cs.components*bpc + 7) / 8
+	if len(data) < rowBytes*height {
+		return nil, fmt.Errorf("image data is truncated")
+	}
+
+	invert := d.inverted(dict)
+	maxSample := (1 << bpc) - 1
+	sample := func(row []byte, idx int) int {
+		switch bpc {
+		case 8:
+			return int(row[idx])
+		case 16:
+			return int(row[2*idx])
+		}
+		bit := idx * bpc
+		return int(row[bit/8]>>(8-bpc-bit%8)) & maxSample
+	}
+	scale := func(v int) byte {
+		if bpc == 16 {
+			return byte(v)
+		}
+		return byte(v * 255 / maxSample)
+	}
+
+	grayscale := cs.components == 1 && cs.palette == nil
+	var (
+		img  *image.RGBA
+		gray *image.Gray
+	)
+	if grayscale {
+		gray = image.NewGray(image.Rect(0, 0, width, height))
+	} else {
+		img = image.NewRGBA(image.Rect(0, 0, width, height))
+	}
+	comps := make([]byte, cs.components)
+	for y := 0; y < height; y++ {
+		row := data[y*rowBytes : (y+1)*rowBytes]
+		for x := 0; x < width; x++ {
+			if cs.palette != nil {
+				idx := sample(row, x)
+				if idx >= len(cs.palette) {
+					idx = len(cs.palette) - 1
+				}
+				img.Set(x, y, cs.palette[idx])
+				continue
+			}
+			for c := range comps {
+				comps[c] = scale(sample(row, x*cs.components+c))
+			}
+			if grayscale {
+				if invert {
+					comps[0] = 255 - comps[0]
+				}
+				gray.Pix[y*gray.Stride+x] = comps[0]
+				continue
+			}
+			img.Set(x, y, toColor(comps, cs))
+		}
+	}
+
+	if grayscale {
+		return gray, nil
+	}
+	return img, nil
+}
+
+func encodePNG(img image.Image) ([]byte, filetype.Format, error) {
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, filetype.Format{}, fmt.Errorf("failed to encode page image: %w", err)
+	}
+	return buf.Bytes(), filetype.PNG, nil
+}
+
+// rotateEncoded поворачивает изображение по часовой стрелке согласно /Rotate страницы.
+func rotateEncoded(data []byte, format filetype.Format, degrees int) ([]byte, filetype.Format, error) {
+	src, err := preprocess.DecodeImage(data)
+	if err != nil {
+		return nil, filetype.Format{}, fmt.Errorf("failed to decode page image: %w", err)
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var dst *image.RGBA
+	if degrees == 180 {
+		dst = image.NewRGBA(image.Rect(0, 0, w, h))
+	} else {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := src.At(b.Min.X+x, b.Min.Y+y)
+			switch degrees {
+			case 90:
+				dst.Set(h-1-y, x, c)
+			case 180:
+				dst.Set(w-1-x, h-1-y, c)
+			case 270:
+				dst.Set(y, w-1-x, c)
+			default:
+				return data, format, nil
+			}
+		}
+	}
+
+	if format.Name == filetype.JPEG.Name {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 90}); err != nil {
+			return nil, filetype.Format{}, fmt.Errorf("failed to encode page image: %w", err)
+		}
+		return buf.Bytes(), filetype.JPEG, nil
+	}
+	return encodePNG(dst)
+}
@@ -0,0 +1,215 @@
+package pdf
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// scannedPDF собирает документ, каждая страница которого рисует одно
+// изображение /Im0; rotate задаёт /Rotate страниц.
+func scannedPDF(t *testing.T, rotate int, images ...*Stream) []byte {
+	t.Helper()
+
+	w := NewWriter()
+	pagesRef := w.Alloc()
+	var kids Array
+	for _, img := range images {
+		content := w.Add(&Stream{Dict: Dict{}, Raw: []byte("q 100 0 0 100 0 0 cm /Im0 Do Q")})
+		kids = append(kids, w.Add(Dict{
+			"Type":      Name("Page"),
+			"Parent":    pagesRef,
+			"MediaBox":  Array{0, 0, 100, 100},
+			"Rotate":    rotate,
+			"Contents":  content,
+			"Resources": Dict{"XObject": Dict{"Im0": w.Add(img)}},
+		}))
+	}
+	w.Set(pagesRef, Dict{"Type": Name("Pages"), "Kids": kids, "Count": len(kids)})
+	root := w.Add(Dict{"Type": Name("Catalog"), "Pages": pagesRef})
+
+	data, err := w.Finish(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func imageStream(dict Dict, data []byte) *Stream {
+	full := Dict{"Type": Name("XObject"), "Subtype": Name("Image")}
+	for k, v := range dict {
+		full[k] = v
+	}
+	return &Stream{Dict: full, Raw: data}
+}
+
+func grayImage(width, height int, data []byte) *Stream {
+	return imageStream(Dict{
+		"Width": width, "Height": height,
+		"ColorSpace": Name("DeviceGray"), "BitsPerComponent": 8,
+	}, data)
+}
+
+func TestPageImage(t *testing.T) {
+	doc, err := Open(scannedPDF(t, 90, grayImage(3, 2, []byte{0, 50, 100, 150, 200, 250})))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if doc.NumPages() != 1 {
+		t.Fatalf("NumPages = %d, want 1", doc.NumPages())
+	}
+
+	data, format, err := doc.PageImage(0)
+	if err != nil {
+		t.Fatalf("PageImage: %v", err)
+	}
+	if format.Name != "png" {
+		t.Errorf("format = %s, want png", format.Name)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("page image is not PNG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 2 || b.Dy() != 3 {
+		t.Errorf("rotated page is %dx%d, want 2x3", b.Dx(), b.Dy())
+	}
+}
+
+// TestPageImageMalformed: размеры и параметры изображения задаёт файл,
+// и никакие их значения не должны приводить к панике.
+func TestPageImageMalformed(t *testing.T) {
+	const huge = 0x7fffffff
+	tests := []struct {
+		name  string
+		image *Stream
+		want  string
+	}{
+		{"huge raw image", grayImage(huge, huge, []byte{0}), "megapixels"},
+		{"row size overflow", imageStream(Dict{
+			"Width": 1 << 58, "Height": 2, "ColorSpace": Name("DeviceRGB"), "BitsPerComponent": 16,
+		}, []byte{0}), "megapixels"},
+		{"negative size", grayImage(-5, 10, []byte{0}), "no raster image"},
+		{"zero size", grayImage(10, 0, nil), "no raster image"},
+		{"truncated samples", grayImage(10, 10, []byte{1, 2, 3}), "truncated"},
+		{"huge CCITT image", imageStream(Dict{
+			"Width": huge, "Height": huge, "ImageMask": true,
+			"Filter":      Name("CCITTFaxDecode"),
+			"DecodeParms": Dict{"K": -1, "Columns": huge, "Rows": huge},
+		}, []byte{0}), "megapixels"},
+		{"negative CCITT columns", imageStream(Dict{
+			"Width": 10, "Height": 10, "ImageMask": true,
+			"Filter":      Name("CCITTFaxDecode"),
+			"DecodeParms": Dict{"K": -1, "Columns": -1, "Rows": 10},
+		}, []byte{0}), "invalid size"},
+		{"huge predictor row", imageStream(Dict{
+			"Width": 10, "Height": 10, "ColorSpace": Name("DeviceGray"), "BitsPerComponent": 8,
+			"Filter":      Name("FlateDecode"),
+			"DecodeParms": Dict{"Predictor": 15, "Columns": 1 << 60, "Colors": 4},
+		}, FlateStream(nil, make([]byte, 110)).Raw), "predictor"},
+		{"unsupported bits", imageStream(Dict{
+			"Width": 2, "Height": 2, "ColorSpace": Name("DeviceGray"), "BitsPerComponent": 3,
+		}, []byte{0, 0}), "bits per component"},
+		{"indexed without palette", imageStream(Dict{
+			"Width": 2, "Height": 2, "BitsPerComponent": 8,
+			"ColorSpace": Array{Name("Indexed"), Name("DeviceRGB"), 255, String("")},
+		}, []byte{0, 0, 0, 0}), "palette"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Open(scannedPDF(t, 0, tt.image))
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			_, _, err = doc.PageImage(0)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("PageImage error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageImageRotatedHugeJPEG(t *testing.T) {
+	// Заголовок JPEG (SOF0 и SOS без данных) объявляет 65535×65535 пикселей.
+	jpegHeader := []byte{
+		0xFF, 0xD8,
+		0xFF, 0xC0, 0x00, 0x0B, 0x08, 0xFF, 0xFF, 0xFF, 0xFF, 0x01, 0x01, 0x11, 0x00,
+		0xFF, 0xDA, 0x00, 0x08, 0x01, 0x01, 0x00, 0x00, 0x3F, 0x00,
+		0xFF, 0xD9,
+	}
+	doc, err := Open(scannedPDF(t, 90, imageStream(Dict{
+		"Width": 65535, "Height": 65535, "ColorSpace": Name("DeviceGray"), "BitsPerComponent": 8,
+		"Filter": Name("DCTDecode"),
+	}, jpegHeader)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, _, err := doc.PageImage(0); err == nil || !strings.Contains(err.Error(), "megapixels") {
+		t.Errorf("PageImage error = %v, want the pixel limit", err)
+	}
+}
+
+// TestOpenTruncated читает все префиксы документа: повреждённый файл
+// даёт ошибку, но не панику.
+func TestOpenTruncated(t *testing.T) {
+	data := scannedPDF(t, 0,
+		grayImage(2, 2, []byte{1, 2, 3, 4}),
+		imageStream(Dict{
+			"Width": 2, "Height": 2, "ColorSpace": Name("DeviceGray"), "BitsPerComponent": 8,
+			"Filter": Name("FlateDecode"),
+		}, FlateStream(nil, []byte{5, 6, 7, 8}).Raw),
+	)
+
+	for n := 0; n <= len(data); n++ {
+		doc, err := Open(data[:n])
+		if err != nil {
+			continue
+		}
+		for i := 0; i < doc.NumPages(); i++ {
+			doc.PageImage(i)
+		}
+	}
+
+	doc, err := Open(data)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if doc.NumPages() != 2 {
+		t.Fatalf("NumPages = %d, want 2", doc.NumPages())
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := doc.PageImage(i); err != nil {
+			t.Errorf("page %d: %v", i+1, err)
+		}
+	}
+}
+
+func TestDecodeLimit(t *testing.T) {
+	defer SetDecodeLimit(DefaultDecodeLimit)
+	SetDecodeLimit(1 << 10)
+
+	bomb := FlateStream(Dict{}, make([]byte, 1<<20))
+	doc := &Document{}
+	if _, err := doc.Decode(bomb); err == nil {
+		t.Error("Decode inflated a stream over the limit")
+	}
+
+	small := FlateStream(Dict{}, []byte("q /Im0 Do Q"))
+	if data, err := doc.Decode(small); err != nil || string(data) != "q /Im0 Do Q" {
+		t.Errorf("Decode = %q, %v", data, err)
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"not a PDF":  []byte("GIF89a"),
+		"no catalog": []byte("%PDF-1.4\n1 0 obj\n<< /Type /Font >>\nendobj\n"),
+		"encrypted":  []byte("%PDF-1.4\ntrailer\n<< /Root 1 0 R /Encrypt 2 0 R >>\n"),
+		"no pages":   []byte("%PDF-1.4\n1 0 obj\n<< /Type /Catalog >>\nendobj\ntrailer\n<< /Root 1 0 R >>\n"),
+	} {
+		if _, err := Open(data); err == nil {
+			t.Errorf("%s: Open succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,603 @@
+// Package pdf — минимальный разбор PDF для отсканированных документов:
+// дерево страниц и растровые изображения на них. Векторное содержимое
+// и шрифты не отрисовываются.
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+type (
+	Object interface{}
+	Name   string
+	String string
+	Array  []Object
+	Dict   map[Name]Object
+	Ref    struct{ Num, Gen int }
+)
+
+// Stream — словарь потока и его данные до применения фильтров.
+type Stream struct {
+	Dict Dict
+	Raw  []byte
+}
+
+var (
+	ErrEncrypted = errors.New("encrypted PDF is not supported")
+	ErrNoImage   = errors.New("page has no raster image; only scanned PDFs are supported")
+)
+
+// maxDepth ограничивает цепочки ссылок и вложенность дерева страниц.
+const maxDepth = 32
+
+var objHeader = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+
+type Document struct {
+	data    []byte
+	offsets map[int]int // номер объекта -> смещение сразу после "N G obj"
+	cache   map[int]Object
+
+	packed       map[int]Object // объекты из потоков объектов (ObjStm)
+	packedLoaded bool
+
+	trailer Dict
+	pages   []page
+}
+
+type page struct {
+	dict      Dict
+	resources Dict
+	rotate    int
+}
+
+// Open разбирает документ. Таблица xref не используется: объекты ищутся
+// сканированием файла, поэтому повреждённые и дописанные инкрементально
+// файлы тоже читаются (побеждает последняя версия объекта).
+func Open(data []byte) (*Document, error) {
+	start := bytes.Index(data, []byte("%PDF-"))
+	if start < 0 || start > 1024 {
+		return nil, errors.New("not a PDF file")
+	}
+
+	d := &Document{
+		data:    data,
+		offsets: make(map[int]int),
+		cache:   make(map[int]Object),
+		packed:  make(map[int]Object),
+	}
+	d.index()
+	d.trailer = d.findTrailer()
+	if d.trailer["Encrypt"] != nil {
+		return nil, ErrEncrypted
+	}
+
+	root, ok := d.Resolve(d.trailer["Root"]).(Dict)
+	if !ok {
+		root = d.findCatalog()
+	}
+	if root == nil {
+		return nil, errors.New("PDF has no document catalog")
+	}
+
+	d.collectPages(root["Pages"], nil, 0, 0, make(map[int]bool))
+	if len(d.pages) == 0 {
+		return nil, errors.New("PDF has no pages")
+	}
+	return d, nil
+}
+
+// NumPages возвращает число страниц документа.
+func (d *Document) NumPages() int {
+	return len(d.pages)
+}
+
+// Resolve разыменовывает косвенные ссылки.
+func (d *Document) Resolve(v Object) Object {
+	for i := 0; i < maxDepth; i++ {
+		ref, ok := v.(Ref)
+		if !ok {
+			return v
+		}
+		v = d.object(ref.Num)
+	}
+	return nil
+}
+
+func (d *Document) index() {
+	pos := 0
+	for pos < len(d.data) {
+		loc := objHeader.FindSubmatchIndex(d.data[pos:])
+		if loc == nil {
+			return
+		}
+		start := pos + loc[0]
+		if start > 0 && !isSpace(d.data[start-1]) && !isDelimiter(d.data[start-1]) {
+			pos += loc[1]
+			continue
+		}
+		num, err := strconv.Atoi(string(d.data[pos+loc[2] : pos+loc[3]]))
+		if err == nil {
+			d.offsets[num] = pos + loc[1]
+		}
+		pos = d.skipObject(pos + loc[1])
+	}
+}
+
+// skipObject пропускает тело объекта, чтобы не принять за заголовки
+// совпадения внутри двоичных данных потока.
+func (d *Document) skipObject(from int) int {
+	end := bytes.Index(d.data[from:], []byte("endobj"))
+	stream := streamKeyword(d.data[from:])
+	if stream >= 0 && (end < 0 || stream < end) {
+		from += stream
+		es := bytes.Index(d.data[from:], []byte("endstream"))
+		if es < 0 {
+			return len(d.data)
+		}
+		from += es + len("endstream")
+		end = bytes.Index(d.data[from:], []byte("endobj"))
+	}
+	if end < 0 {
+		return len(d.data)
+	}
+	return from + end + len("endobj")
+}
+
+// streamKeyword ищет ключевое слово stream, за которым следует конец строки.
+func streamKeyword(data []byte) int {
+	offset := 0
+	for {
+		i := bytes.Index(data[offset:], []byte("stream"))
+		if i < 0 {
+			return -1
+		}
+		i += offset
+		after := i + len("stream")
+		prevOK := i == 0 || isSpace(data[i-1]) || data[i-1] == '>'
+		if prevOK && after < len(data) && (data[after] == '\r' || data[after] == '\n') {
+			return i
+		}
+		offset = after
+	}
+}
+
+func (d *Document) object(num int) Object {
+	if v, ok := d.cache[num]; ok {
+		return v
+	}
+	d.cache[num] = nil // защита от циклических ссылок
+
+	var v Object
+	if off, ok := d.offsets[num]; ok {
+		v, _ = d.parseIndirect(off)
+	} else {
+		d.loadPacked()
+		v = d.packed[num]
+	}
+	d.cache[num] = v
+	return v
+}
+
+func (d *Document) parseIndirect(off int) (Object, error) {
+	p := &parser{data: d.data, pos: off}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+
+	dict, ok := v.(Dict)
+	if !ok {
+		return v, nil
+	}
+	p.skipSpace()
+	if !bytes.HasPrefix(d.data[p.pos:], []byte("stream")) {
+		return dict, nil
+	}
+
+	start := p.pos + len("stream")
+	if start < len(d.data) && d.data[start] == '\r' {
+		start++
+	}
+	if start < len(d.data) && d.data[start] == '\n' {
+		start++
+	}
+
+	if length, ok := d.Resolve(dict["Length"]).(int); ok && length >= 0 && start+length <= len(d.data) {
+		q := &parser{data: d.data, pos: start + length}
+		q.skipSpace()
+		if bytes.HasPrefix(d.data[q.pos:], []byte("endstream")) {
+			return &Stream{Dict: dict, Raw: d.data[start : start+length]}, nil
+		}
+	}
+
+	// /Length отсутствует или неверен — ищем endstream.
+	end := bytes.Index(d.data[start:], []byte("endstream"))
+	if end < 0 {
+		return nil, errors.New("unterminated stream")
+	}
+	raw := bytes.TrimRight(d.data[start:start+end], "\r\n")
+	return &Stream{Dict: dict, Raw: raw}, nil
+}
+
+// loadPacked разбирает все потоки объектов (PDF 1.5+).
+func (d *Document) loadPacked() {
+	if d.packedLoaded {
+		return
+	}
+	d.packedLoaded = true
+
+	for _, num := range d.sortedObjects() {
+		s, ok := d.object(num).(*Stream)
+		if !ok || s.Dict["Type"] != Name("ObjStm") {
+			continue
+		}
+		data, err := d.Decode(s)
+		if err != nil {
+			continue
+		}
+		n, _ := d.Resolve(s.Dict["N"]).(int)
+		first, _ := d.Resolve(s.Dict["First"]).(int)
+		if first < 0 || first > len(data) {
+			continue
+		}
+
+		header := &parser{data: data[:first]}
+		for i := 0; i < n; i++ {
+			objNum, err1 := header.value()
+			objOff, err2 := header.value()
+			num, ok1 := objNum.(int)
+			off, ok2 := objOff.(int)
+			if err1 != nil || err2 != nil || !ok1 || !ok2 || first+off >= len(data) {
+				break
+			}
+			if _, direct := d.offsets[num]; direct {
+				continue
+			}
+			p := &parser{data: data, pos: first + off}
+			if v, err := p.value(); err == nil {
+				d.packed[num] = v
+			}
+		}
+	}
+}
+
+func (d *Document) sortedObjects() []int {
+	nums := make([]int, 0, len(d.offsets))
+	for num := range d.offsets {
+		nums = append(nums, num)
+	}
+	sort.Slice(nums, func(i, j int) bool {
+		return d.offsets[nums[i]] < d.offsets[nums[j]]
+	})
+	return nums
+}
+
+// findTrailer возвращает последний словарь trailer либо словарь
+// потока перекрёстных ссылок (/Type /XRef).
+func (d *Document) findTrailer() Dict {
+	if i := bytes.LastIndex(d.data, []byte("trailer")); i >= 0 {
+		p := &parser{data: d.data, pos: i + len("trailer")}
+		if v, err := p.value(); err == nil {
+			if dict, ok := v.(Dict); ok && dict["Root"] != nil {
+				return dict
+			}
+		}
+	}
+
+	nums := d.sortedObjects()
+	for i := len(nums) - 1; i >= 0; i-- {
+		if s, ok := d.object(nums[i]).(*Stream); ok && s.Dict["Type"] == Name("XRef") {
+			return s.Dict
+		}
+	}
+	return Dict{}
+}
+
+func (d *Document) findCatalog() Dict {
+	d.loadPacked()
+	nums := d.sortedObjects()
+	for num := range d.packed {
+		nums = append(nums, num)
+	}
+	for _, num := range nums {
+		if dict, ok := d.object(num).(Dict); ok && dict["Type"] == Name("Catalog") {
+			return dict
+		}
+	}
+	return nil
+}
+
+// collectPages обходит дерево страниц; Resources и Rotate наследуются от родителей.
+func (d *Document) collectPages(node Object, resources Dict, rotate, depth int, seen map[int]bool) {
+	if depth > maxDepth {
+		return
+	}
+	if ref, ok := node.(Ref); ok {
+		if seen[ref.Num] {
+			return
+		}
+		seen[ref.Num] = true
+	}
+
+	dict, ok := d.Resolve(node).(Dict)
+	if !ok {
+		return
+	}
+	if r, ok := d.Resolve(dict["Resources"]).(Dict); ok {
+		resources = r
+	}
+	if r, ok := d.Resolve(dict["Rotate"]).(int); ok {
+		rotate = r
+	}
+
+	kids, ok := d.Resolve(dict["Kids"]).(Array)
+	if !ok || dict["Type"] == Name("Page") {
+		d.pages = append(d.pages, page{dict: dict, resources: resources, rotate: rotate})
+		return
+	}
+	for _, kid := range kids {
+		d.collectPages(kid, resources, rotate, depth+1, seen)
+	}
+}
+
+// parser разбирает синтаксис объектов PDF.
+type parser struct {
+	data []byte
+	pos  int
+}
+
+func isSpace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch {
+		case isSpace(c):
+			p.pos++
+		case c == '%':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) value() (Object, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, errors.New("unexpected end of PDF data")
+	}
+
+	c := p.data[p.pos]
+	switch {
+	case c == '/':
+		return p.name(), nil
+	case c == '(':
+		return p.literalString()
+	case c == '<' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '<':
+		return p.dict()
+	case c == '<':
+		return p.hexString()
+	case c == '[':
+		return p.array()
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return p.number()
+	}
+
+	switch kw := p.keyword(); kw {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected token %q at offset %d", kw, p.pos)
+	}
+}
+
+func (p *parser) keyword() string {
+	start := p.pos
+	for p.pos < len(p.data) && !isSpace(p.data[p.pos]) && !isDelimiter(p.data[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start && p.pos < len(p.data) {
+		p.pos++ // одиночный разделитель, например лишняя ')'
+	}
+	return string(p.data[start:p.pos])
+}
+
+func (p *parser) name() Name {
+	p.pos++ // '/'
+	var buf []byte
+	for p.pos < len(p.data) && !isSpace(p.data[p.pos]) && !isDelimiter(p.data[p.pos]) {
+		c := p.data[p.pos]
+		if c == '#' && p.pos+2 < len(p.data) {
+			if b, err := strconv.ParseUint(string(p.data[p.pos+1:p.pos+3]), 16, 8); err == nil {
+				buf = append(buf, byte(b))
+				p.pos += 3
+				continue
+			}
+		}
+		buf = append(buf, c)
+		p.pos++
+	}
+	return Name(buf)
+}
+
+func (p *parser) number() (Object, error) {
+	start := p.pos
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c != '+' && c != '-' && c != '.' && (c < '0' || c > '9') {
+			break
+		}
+		p.pos++
+	}
+	token := string(p.data[start:p.pos])
+
+	n, err := strconv.Atoi(token)
+	if err != nil {
+		f, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", token)
+		}
+		return f, nil
+	}
+
+	// "N G R" — косвенная ссылка.
+	save := p.pos
+	p.skipSpace()
+	genStart := p.pos
+	for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos > genStart {
+		gen, _ := strconv.Atoi(string(p.data[genStart:p.pos]))
+		p.skipSpace()
+		if p.pos < len(p.data) && p.data[p.pos] == 'R' &&
+			(p.pos+1 == len(p.data) || isSpace(p.data[p.pos+1]) || isDelimiter(p.data[p.pos+1])) {
+			p.pos++
+			return Ref{Num: n, Gen: gen}, nil
+		}
+	}
+	p.pos = save
+	return n, nil
+}
+
+func (p *parser) dict() (Object, error) {
+	p.pos += 2
+	dict := make(Dict)
+	for {
+		p.skipSpace()
+		if p.pos+1 < len(p.data) && p.data[p.pos] == '>' && p.data[p.pos+1] == '>' {
+			p.pos += 2
+			return dict, nil
+		}
+		if p.pos >= len(p.data) || p.data[p.pos] != '/' {
+			return nil, fmt.Errorf("invalid dictionary key at offset %d", p.pos)
+		}
+		key := p.name()
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		dict[key] = v
+	}
+}
+
+func (p *parser) array() (Object, error) {
+	p.pos++
+	var arr Array
+	for {
+		p.skipSpace()
+		if p.pos < len(p.data) && p.data[p.pos] == ']' {
+			p.pos++
+			return arr, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+	}
+}
+
+func (p *parser) hexString() (Object, error) {
+	p.pos++
+	var digits []byte
+	for p.pos < len(p.data) && p.data[p.pos] != '>' {
+		if c := p.data[p.pos]; !isSpace(c) {
+			digits = append(digits, c)
+		}
+		p.pos++
+	}
+	p.pos++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+
+	buf := make([]byte, len(digits)/2)
+	for i := range buf {
+		b, err := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex string")
+		}
+		buf[i] = byte(b)
+	}
+	return String(buf), nil
+}
+
+func (p *parser) literalString() (Object, error) {
+	p.pos++
+	var buf []byte
+	depth := 1
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return String(buf), nil
+			}
+		case '\\':
+			if p.pos >= len(p.data) {
+				continue
+			}
+			e := p.data[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+					p.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; i++ {
+						v = v*8 + int(p.data[p.pos]-'0')
+						p.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		buf = append(buf, c)
+	}
+	return nil, errors.New("unterminated string")
+}
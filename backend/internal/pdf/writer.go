@@ -0,0 +1,185 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Writer собирает PDF из объектов по мере их добавления;
+// таблица перекрёстных ссылок дописывается в Finish.
+type Writer struct {
+	buf     bytes.Buffer
+	offsets []int // смещение объекта по номеру-1; -1 — номер выделен, объект не записан
+}
+
+func NewWriter() *Writer {
+	w := &Writer{}
+	w.buf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	return w
+}
+
+// Alloc выделяет номер объекта, чтобы сослаться на него до записи.
+func (w *Writer) Alloc() Ref {
+	w.offsets = append(w.offsets, -1)
+	return Ref{Num: len(w.offsets)}
+}
+
+// Add записывает объект под новым номером.
+func (w *Writer) Add(obj Object) Ref {
+	ref := w.Alloc()
+	w.Set(ref, obj)
+	return ref
+}
+
+// Set записывает объект под выделенным номером; *Stream пишется как поток.
+func (w *Writer) Set(ref Ref, obj Object) {
+	w.offsets[ref.Num-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n", ref.Num)
+
+	if s, ok := obj.(*Stream); ok {
+		dict := make(Dict, len(s.Dict)+1)
+		for k, v := range s.Dict {
+			dict[k] = v
+		}
+		dict["Length"] = len(s.Raw)
+		writeObject(&w.buf, dict)
+		w.buf.WriteString("\nstream\n")
+		w.buf.Write(s.Raw)
+		w.buf.WriteString("\nendstream")
+	} else {
+		writeObject(&w.buf, obj)
+	}
+	w.buf.WriteString("\nendobj\n")
+}
+
+// Finish дописывает xref и trailer и возвращает документ.
+func (w *Writer) Finish(root Ref, info Dict) ([]byte, error) {
+	var infoRef Ref
+	if info != nil {
+		infoRef = w.Add(info)
+	}
+
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for i, off := range w.offsets {
+		if off < 0 {
+			return nil, fmt.Errorf("object %d was allocated but never written", i+1)
+		}
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", off)
+	}
+
+	trailer := Dict{"Size": len(w.offsets) + 1, "Root": root}
+	if info != nil {
+		trailer["Info"] = infoRef
+	}
+	w.buf.WriteString("trailer\n")
+	writeObject(&w.buf, trailer)
+	fmt.Fprintf(&w.buf, "\nstartxref\n%d\n%%%%EOF\n", xref)
+
+	return w.buf.Bytes(), nil
+}
+
+// FlateStream сжимает данные и возвращает поток с /Filter /FlateDecode.
+func FlateStream(dict Dict, data []byte) *Stream {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+
+	if dict == nil {
+		dict = Dict{}
+	}
+	dict["Filter"] = Name("FlateDecode")
+	return &Stream{Dict: dict, Raw: buf.Bytes()}
+}
+
+func writeObject(buf *bytes.Buffer, obj Object) {
+	switch v := obj.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int:
+		buf.WriteString(strconv.Itoa(v))
+	case float64:
+		buf.WriteString(FormatNumber(v))
+	case Name:
+		writeName(buf, v)
+	case String:
+		writeString(buf, v)
+	case Ref:
+		fmt.Fprintf(buf, "%d %d R", v.Num, v.Gen)
+	case Array:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			writeObject(buf, item)
+		}
+		buf.WriteByte(']')
+	case Dict:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, string(k))
+		}
+		sort.Strings(keys)
+
+		buf.WriteString("<<")
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			writeName(buf, Name(k))
+			buf.WriteByte(' ')
+			writeObject(buf, v[Name(k)])
+		}
+		buf.WriteString(">>")
+	default:
+		panic(fmt.Sprintf("pdf: cannot write %T", obj))
+	}
+}
+
+// FormatNumber печатает число без экспоненты, как требует синтаксис PDF.
+func FormatNumber(f float64) string {
+	s := strings.TrimRight(strconv.FormatFloat(f, 'f', 3, 64), "0")
+	s = strings.TrimSuffix(s, ".")
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+func writeName(buf *bytes.Buffer, name Name) {
+	buf.WriteByte('/')
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c < '!' || c > '~' || c == '#' || isDelimiter(c) {
+			fmt.Fprintf(buf, "#%02X", c)
+			continue
+		}
+		buf.WriteByte(c)
+	}
+}
+
+func writeString(buf *bytes.Buffer, s String) {
+	buf.WriteByte('(')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '(', ')', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\n':
+			buf.WriteString(`\n`)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte(')')
+}
@@ -0,0 +1,264 @@
+// Package preprocess подготавливает фотографии страниц перед отправкой
+// провайдеру: поворот по EXIF, уменьшение до лимитов провайдера,
+// нормализация контраста, бинаризация и выравнивание наклона.
+package preprocess
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/airsss993/ocr-history/internal/filetype"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// Шаги выполняются всегда в этом порядке, независимо от порядка в запросе.
+const (
+	StepOrient    = "orient"
+	StepDownscale = "downscale"
+	StepGrayscale = "grayscale"
+	StepContrast  = "contrast"
+	StepDeskew    = "deskew"
+	StepBinarize  = "binarize"
+)
+
+var AllSteps = []string{StepOrient, StepDownscale, StepGrayscale, StepContrast, StepDeskew, StepBinarize}
+
+const (
+	maxSkewAngle   = 5.0  // градусов
+	skewStep       = 0.25 // градусов
+	minSkewToFix   = 0.3  // меньший наклон не исправляем, чтобы не размывать текст
+	defaultQuality = 90
+)
+
+type Options struct {
+	Steps       []string
+	MaxSide     int // 0 — без ограничения
+	MaxPixels   int // 0 — без ограничения
+	JPEGQuality int
+}
+
+// Enabled сообщает, нужно ли вообще декодировать изображение.
+func (o Options) Enabled() bool {
+	return len(o.Steps) > 0
+}
+
+func (o Options) has(step string) bool {
+	return slices.Contains(o.Steps, step)
+}
+
+// ParseSteps разбирает список шагов через запятую. "none" — без обработки.
+func ParseSteps(s string) ([]string, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if s == "" || s == "none" {
+		return nil, nil
+	}
+
+	var steps []string
+	for _, step := range strings.Split(s, ",") {
+		step = strings.TrimSpace(step)
+		if !slices.Contains(AllSteps, step) {
+			return nil, fmt.Errorf("unknown preprocessing step %q, expected: %s", step, strings.Join(AllSteps, ", "))
+		}
+		if !slices.Contains(steps, step) {
+			steps = append(steps, step)
+		}
+	}
+	return steps, nil
+}
+
+type Result struct {
+	Data    []byte
+	MIME    string
+	Applied []string // выполненные шаги с параметрами, например "deskew:-1.25"
+}
+
+// Process применяет шаги к изображению. Если ни один шаг ничего не изменил,
+// возвращаются исходные байты. Форматы, которые нельзя декодировать
+// на Go (HEIC, PDF), пропускаются без изменений.
+func Process(data []byte, format filetype.Format, opts Options) (Result, error) {
+	result := Result{Data: data, MIME: format.MIME}
+	if !opts.Enabled() {
+		return result, nil
+	}
+
+	img, err := decode(data, format)
+	if err != nil {
+		return result, err
+	}
+	if img == nil {
+		result.Applied = []string{"skipped:" + format.Name}
+		return result, nil
+	}
+
+	changed := false
+	apply := func(step string) {
+		result.Applied = append(result.Applied, step)
+		changed = true
+	}
+
+	if opts.has(StepOrient) && format.Name == filetype.JPEG.Name {
+		if o := jpegOrientation(data); o != 1 {
+			img = orient(img, o)
+			apply(fmt.Sprintf("%s:%d", StepOrient, o))
+		}
+	}
+
+	if opts.has(StepDownscale) {
+		b := img.Bounds()
+		if w, h, ok := fitSize(b.Dx(), b.Dy(), opts.MaxSide, opts.MaxPixels); ok {
+			img = resize(img, w, h)
+			apply(fmt.Sprintf("%s:%dx%d", StepDownscale, w, h))
+		}
+	}
+
+	// Контраст, выравнивание и бинаризация работают с яркостью.
+	needGray := opts.has(StepGrayscale) || opts.has(StepContrast) || opts.has(StepDeskew) || opts.has(StepBinarize)
+	binarized := false
+	if needGray {
+		gray := toGray(img)
+		apply(StepGrayscale)
+
+		if opts.has(StepContrast) && stretchContrast(gray) {
+			apply(StepContrast)
+		}
+
+		if opts.has(StepDeskew) {
+			if angle := estimateSkew(gray, maxSkewAngle, skewStep); math.Abs(angle) >= minSkewToFix {
+				gray = rotate(gray, angle)
+				apply(fmt.Sprintf("%s:%.2f", StepDeskew, angle))
+			}
+		}
+
+		if opts.has(StepBinarize) {
+			gray = binarize(gray)
+			binarized = true
+			apply(StepBinarize)
+		}
+
+		img = gray
+	}
+
+	if !changed {
+		return result, nil
+	}
+
+	var buf bytes.Buffer
+	if binarized {
+		// Двухцветное изображение в PNG и меньше, и без артефактов JPEG.
+		err = png.Encode(&buf, img)
+		result.MIME = filetype.PNG.MIME
+	} else {
+		quality := opts.JPEGQuality
+		if quality <= 0 || quality > 100 {
+			quality = defaultQuality
+		}
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+		result.MIME = filetype.JPEG.MIME
+	}
+	if err != nil {
+		return Result{Data: data, MIME: format.MIME}, fmt.Errorf("failed to encode preprocessed image: %w", err)
+	}
+
+	result.Data = buf.Bytes()
+	return result, nil
+}
+
+// ToPNG перекодирует изображение в PNG без потерь — для провайдеров,
+// которые не принимают исходный формат (WebP, GIF, BMP, TIFF).
+func ToPNG(data []byte, format filetype.Format) ([]byte, error) {
+	img, err := decode(data, format)
+	if err != nil {
+		return nil, err
+	}
+	if img == nil {
+		return nil, fmt.Errorf("%s images cannot be converted", format.Name)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode %s image as PNG: %w", format.Name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// MaxDecodePixels — предел размера декодируемого изображения: небольшой файл
+// может объявить 50000×50000 пикселей и занять при декодировании гигабайты.
+const MaxDecodePixels = 100_000_000
+
+// CheckPixels отклоняет изображение, размер которого не положителен или
+// больше MaxDecodePixels. Размер берётся из самого файла, поэтому
+// произведение считается без переполнения.
+func CheckPixels(width, height int) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("image has invalid size %dx%d", width, height)
+	}
+	if int64(width) > MaxDecodePixels/int64(height) {
+		return fmt.Errorf("image is %dx%d pixels, maximum is %d megapixels",
+			width, height, MaxDecodePixels/1_000_000)
+	}
+	return nil
+}
+
+// DecodeImage декодирует изображение любого зарегистрированного в image
+// формата, проверив его размер по заголовку.
+func DecodeImage(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if err := CheckPixels(cfg.Width, cfg.Height); err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// decoders — декодеры форматов, которые обрабатываются на Go.
+var decoders = map[string]struct {
+	config func(io.Reader) (image.Config, error)
+	decode func(io.Reader) (image.Image, error)
+}{
+	filetype.JPEG.Name: {jpeg.DecodeConfig, jpeg.Decode},
+	filetype.PNG.Name:  {png.DecodeConfig, png.Decode},
+	filetype.GIF.Name:  {gif.DecodeConfig, gif.Decode},
+	filetype.WebP.Name: {webp.DecodeConfig, webp.Decode},
+	filetype.BMP.Name:  {bmp.DecodeConfig, bmp.Decode},
+	filetype.TIFF.Name: {tiff.DecodeConfig, tiff.Decode},
+}
+
+// decode возвращает nil без ошибки для форматов, которые не декодируются.
+// Размер изображения проверяется по заголовку до декодирования.
+func decode(data []byte, format filetype.Format) (image.Image, error) {
+	d, ok := decoders[format.Name]
+	if !ok {
+		return nil, nil
+	}
+
+	cfg, err := d.config(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s image: %w", format.Name, err)
+	}
+	if err := CheckPixels(cfg.Width, cfg.Height); err != nil {
+		return nil, fmt.Errorf("%s %w", format.Name, err)
+	}
+
+	img, err := d.decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s image: %w", format.Name, err)
+	}
+	return img, nil
+}
@@ -0,0 +1,254 @@
+package preprocess
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	if n, ok := img.(*image.NRGBA); ok && n.Rect.Min == (image.Point{}) {
+		return n
+	}
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+func toGray(img image.Image) *image.Gray {
+	if g, ok := img.(*image.Gray); ok && g.Rect.Min == (image.Point{}) {
+		return g
+	}
+	b := img.Bounds()
+	dst := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// orient приводит изображение к нормальной ориентации по значению EXIF Orientation.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	src := toNRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 { // 5–8 меняют местами ширину и высоту
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // зеркально по горизонтали
+				dx, dy = w-1-x, y
+			case 3: // 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // зеркально по вертикали
+				dx, dy = x, h-1-y
+			case 5: // транспонирование
+				dx, dy = y, x
+			case 6: // 90° по часовой
+				dx, dy = h-1-y, x
+			case 7: // поперечное транспонирование
+				dx, dy = h-1-y, w-1-x
+			case 8: // 90° против часовой
+				dx, dy = y, w-1-x
+			}
+			si := y*src.Stride + x*4
+			di := dy*dst.Stride + dx*4
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// fitSize возвращает размер, вписанный в ограничения по стороне и числу пикселей.
+func fitSize(w, h, maxSide, maxPixels int) (int, int, bool) {
+	scale := 1.0
+	if maxSide > 0 && (w > maxSide || h > maxSide) {
+		scale = math.Min(float64(maxSide)/float64(w), float64(maxSide)/float64(h))
+	}
+	if maxPixels > 0 && float64(w)*float64(h)*scale*scale > float64(maxPixels) {
+		scale = math.Sqrt(float64(maxPixels) / (float64(w) * float64(h)))
+	}
+	if scale >= 1 {
+		return w, h, false
+	}
+	return max(1, int(float64(w)*scale)), max(1, int(float64(h)*scale)), true
+}
+
+func resize(img image.Image, w, h int) image.Image {
+	var dst draw.Image
+	if _, ok := img.(*image.Gray); ok {
+		dst = image.NewGray(image.Rect(0, 0, w, h))
+	} else {
+		dst = image.NewNRGBA(image.Rect(0, 0, w, h))
+	}
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+	return dst
+}
+
+// stretchContrast растягивает гистограмму так, чтобы 1% самых тёмных пикселей
+// стал чёрным, а 1% самых светлых — белым. Возвращает false, если изображение
+// почти однотонное и растягивать нечего.
+func stretchContrast(img *image.Gray) bool {
+	var hist [256]int
+	for _, v := range img.Pix {
+		hist[v]++
+	}
+
+	total := len(img.Pix)
+	clip := total / 100
+	lo, hi := 0, 255
+	for sum := 0; lo < 255; lo++ {
+		sum += hist[lo]
+		if sum > clip {
+			break
+		}
+	}
+	for sum := 0; hi > 0; hi-- {
+		sum += hist[hi]
+		if sum > clip {
+			break
+		}
+	}
+	if hi-lo < 16 || (lo == 0 && hi == 255) {
+		return false
+	}
+
+	var lut [256]uint8
+	for i := range lut {
+		v := (i - lo) * 255 / (hi - lo)
+		lut[i] = uint8(min(255, max(0, v)))
+	}
+	for i, v := range img.Pix {
+		img.Pix[i] = lut[v]
+	}
+	return true
+}
+
+// binarize — адаптивная бинаризация Брэдли: пиксель становится чёрным, если он
+// темнее среднего по окрестности на заданную долю. Устойчива к неравномерному
+// освещению, типичному для фотографий страниц.
+func binarize(img *image.Gray) *image.Gray {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	integral := make([]int64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		var row int64
+		for x := 0; x < w; x++ {
+			row += int64(img.Pix[y*img.Stride+x])
+			integral[(y+1)*(w+1)+x+1] = integral[y*(w+1)+x+1] + row
+		}
+	}
+
+	half := max(w, h) / 32
+	if half < 4 {
+		half = 4
+	}
+	const t = 15 // процент
+
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := max(0, y-half), min(h-1, y+half)
+		for x := 0; x < w; x++ {
+			x0, x1 := max(0, x-half), min(w-1, x+half)
+			count := int64((x1 - x0 + 1) * (y1 - y0 + 1))
+			sum := integral[(y1+1)*(w+1)+x1+1] - integral[y0*(w+1)+x1+1] -
+				integral[(y1+1)*(w+1)+x0] + integral[y0*(w+1)+x0]
+
+			if int64(img.Pix[y*img.Stride+x])*count*100 <= sum*(100-t) {
+				dst.Pix[y*dst.Stride+x] = 0
+			} else {
+				dst.Pix[y*dst.Stride+x] = 255
+			}
+		}
+	}
+	return dst
+}
+
+// estimateSkew ищет угол наклона строк текста (в градусах, в пределах ±maxAngle),
+// при котором горизонтальная проекция тёмных пикселей наиболее «контрастна».
+func estimateSkew(img *image.Gray, maxAngle, step float64) float64 {
+	sample := img
+	if w, h, ok := fitSize(img.Rect.Dx(), img.Rect.Dy(), 1000, 0); ok {
+		sample = resize(img, w, h).(*image.Gray)
+	}
+	bin := binarize(sample)
+
+	w, h := bin.Rect.Dx(), bin.Rect.Dy()
+	var points [][2]float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if bin.Pix[y*bin.Stride+x] == 0 {
+				points = append(points, [2]float64{float64(x), float64(y)})
+			}
+		}
+	}
+	if len(points) < 100 {
+		return 0
+	}
+
+	best, bestScore := 0.0, -1.0
+	bins := make([]float64, 2*(w+h))
+	for angle := -maxAngle; angle <= maxAngle+1e-9; angle += step {
+		rad := angle * math.Pi / 180
+		sin, cos := math.Sin(rad), math.Cos(rad)
+		clear(bins)
+		for _, p := range points {
+			row := int(p[1]*cos-p[0]*sin) + w
+			if row >= 0 && row < len(bins) {
+				bins[row]++
+			}
+		}
+		score := 0.0
+		for _, v := range bins {
+			score += v * v
+		}
+		if score > bestScore {
+			best, bestScore = angle, score
+		}
+	}
+	return best
+}
+
+// rotate поворачивает изображение на angle градусов вокруг центра
+// с билинейной интерполяцией, заполняя углы белым.
+func rotate(img *image.Gray, angle float64) *image.Gray {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	rad := angle * math.Pi / 180
+	sin, cos := math.Sin(rad), math.Cos(rad)
+	cx, cy := float64(w)/2, float64(h)/2
+
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := float64(x)-cx, float64(y)-cy
+			sx := dx*cos - dy*sin + cx
+			sy := dx*sin + dy*cos + cy
+			dst.Pix[y*dst.Stride+x] = sampleBilinear(img, sx, sy)
+		}
+	}
+	return dst
+}
+
+func sampleBilinear(img *image.Gray, x, y float64) uint8 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if x < 0 || y < 0 || x > float64(w-1) || y > float64(h-1) {
+		return 255
+	}
+	x0, y0 := int(x), int(y)
+	x1, y1 := min(x0+1, w-1), min(y0+1, h-1)
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	p := func(x, y int) float64 { return float64(img.Pix[y*img.Stride+x]) }
+	top := p(x0, y0)*(1-fx) + p(x1, y0)*fx
+	bottom := p(x0, y1)*(1-fx) + p(x1, y1)*fx
+	return uint8(top*(1-fy) + bottom*fy + 0.5)
+}
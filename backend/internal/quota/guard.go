@@ -0,0 +1,92 @@
+package quota
+
+import (
+	"context"
+
+	"github.com/airsss993/ocr-history/internal/repository"
+)
+
+// GuardedRepository списывает квоту клиента перед каждым вызовом провайдера.
+type GuardedRepository struct {
+	repo     repository.OCRRepository
+	manager  *Manager
+	key      string
+	provider string
+}
+
+func NewGuardedRepository(repo repository.OCRRepository, manager *Manager, key, provider string) *GuardedRepository {
+	return &GuardedRepository{
+		repo:     repo,
+		manager:  manager,
+		key:      key,
+		provider: provider,
+	}
+}
+
+func (g *GuardedRepository) RecognizeFromBytes(ctx context.Context, data []byte, mimeType string) (string, error) {
+	reserved := Usage{
+		Images: 1,
+		Pages:  1,
+		Cost:   g.manager.Cost(g.provider, 1, 0),
+	}
+	if err := g.manager.Reserve(g.key, reserved); err != nil {
+		return "", err
+	}
+
+	var (
+		text  string
+		usage repository.Usage
+		err   error
+	)
+	if reporter, ok := g.repo.(repository.UsageReporter); ok {
+		text, usage, err = reporter.RecognizeWithUsage(ctx, data, mimeType)
+	} else {
+		text, err = g.repo.RecognizeFromBytes(ctx, data, mimeType)
+	}
+
+	if err != nil {
+		g.manager.Release(g.key, reserved)
+		return "", err
+	}
+
+	if usage.Tokens > 0 {
+		g.manager.Record(g.key, Usage{
+			Tokens: usage.Tokens,
+			Cost:   g.manager.Cost(g.provider, 0, usage.Tokens),
+		})
+	}
+
+	return text, nil
+}
+
+// RecognizePages распознаёт документ постранично. Число страниц заранее
+// неизвестно: резервируется одна, остальные списываются после ответа.
+func (g *GuardedRepository) RecognizePages(ctx context.Context, data []byte, mimeType string) ([]string, error) {
+	pager, ok := g.repo.(repository.PageRecognizer)
+	if !ok {
+		return nil, repository.ErrPagesUnsupported
+	}
+
+	reserved := Usage{
+		Images: 1,
+		Pages:  1,
+		Cost:   g.manager.Cost(g.provider, 1, 0),
+	}
+	if err := g.manager.Reserve(g.key, reserved); err != nil {
+		return nil, err
+	}
+
+	pages, err := pager.RecognizePages(ctx, data, mimeType)
+	if err != nil {
+		g.manager.Release(g.key, reserved)
+		return nil, err
+	}
+
+	if extra := int64(len(pages)) - 1; extra > 0 {
+		g.manager.Record(g.key, Usage{
+			Pages: extra,
+			Cost:  g.manager.Cost(g.provider, extra, 0),
+		})
+	}
+	return pages, nil
+}
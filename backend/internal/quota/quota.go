@@ -0,0 +1,334 @@
+package quota
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/config"
+)
+
+const (
+	PeriodDaily   = "daily"
+	PeriodMonthly = "monthly"
+
+	ResourceImages = "images"
+	ResourcePages  = "pages"
+	ResourceTokens = "tokens"
+	ResourceBudget = "budget"
+)
+
+// Usage — расход клиента: изображения, страницы, токены и деньги.
+type Usage struct {
+	Images int64   `json:"images"`
+	Pages  int64   `json:"pages"`
+	Tokens int64   `json:"tokens"`
+	Cost   float64 `json:"cost"`
+}
+
+func (u Usage) add(o Usage) Usage {
+	return Usage{
+		Images: u.Images + o.Images,
+		Pages:  u.Pages + o.Pages,
+		Tokens: u.Tokens + o.Tokens,
+		Cost:   u.Cost + o.Cost,
+	}
+}
+
+func (u Usage) sub(o Usage) Usage {
+	return Usage{
+		Images: max(u.Images-o.Images, 0),
+		Pages:  max(u.Pages-o.Pages, 0),
+		Tokens: max(u.Tokens-o.Tokens, 0),
+		Cost:   math.Max(u.Cost-o.Cost, 0),
+	}
+}
+
+// ExceededError возвращается, когда запрос не помещается в лимит.
+type ExceededError struct {
+	Period   string
+	Resource string
+	ResetAt  time.Time
+}
+
+func (e *ExceededError) Error() string {
+	if e.Resource == ResourceBudget {
+		return fmt.Sprintf("%s budget exhausted", e.Period)
+	}
+	return fmt.Sprintf("%s %s quota exceeded", e.Period, e.Resource)
+}
+
+// Remaining — остаток квоты. Значение -1 означает «без ограничений».
+type Remaining struct {
+	Images  int64     `json:"images"`
+	Pages   int64     `json:"pages"`
+	Tokens  int64     `json:"tokens"`
+	Budget  float64   `json:"budget"`
+	ResetAt time.Time `json:"reset_at"`
+}
+
+type window struct {
+	start time.Time
+	used  Usage
+}
+
+type counters struct {
+	daily   window
+	monthly window
+}
+
+type limits struct {
+	daily   config.QuotaLimits
+	monthly config.QuotaLimits
+}
+
+type Manager struct {
+	mu        sync.Mutex
+	usage     map[string]*counters
+	defaults  limits
+	overrides map[string]limits
+	pricing   map[string]config.ProviderPricing
+	now       func() time.Time
+	prunedAt  time.Time // начало суток последней очистки usage
+}
+
+// NewManager возвращает nil, если квоты выключены в конфигурации.
+func NewManager(cfg config.Quota) *Manager {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	overrides := make(map[string]limits, len(cfg.Clients))
+	for _, c := range cfg.Clients {
+		overrides[c.ID] = limits{daily: c.Daily, monthly: c.Monthly}
+	}
+
+	return &Manager{
+		usage:     make(map[string]*counters),
+		defaults:  limits{daily: cfg.Daily, monthly: cfg.Monthly},
+		overrides: overrides,
+		pricing:   cfg.Pricing,
+		now:       time.Now,
+	}
+}
+
+// Cost считает стоимость распознавания по тарифам провайдера.
+func (m *Manager) Cost(provider string, pages, tokens int64) float64 {
+	p, ok := m.pricing[provider]
+	if !ok {
+		return 0
+	}
+	return float64(pages)*p.PerPage + float64(tokens)*p.PerMillionTokens/1_000_000
+}
+
+// Check проверяет, что запрос want помещается в остаток, не резервируя его.
+func (m *Manager) Check(key string, want Usage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.check(key, m.counters(key, false), want)
+}
+
+// Reserve атомарно проверяет и списывает want из квоты клиента.
+func (m *Manager) Reserve(key string, want Usage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.counters(key, true)
+	if err := m.check(key, c, want); err != nil {
+		return err
+	}
+
+	c.daily.used = c.daily.used.add(want)
+	c.monthly.used = c.monthly.used.add(want)
+	return nil
+}
+
+// Release возвращает ранее зарезервированный расход, например при ошибке провайдера.
+func (m *Manager) Release(key string, used Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.counters(key, false)
+	c.daily.used = c.daily.used.sub(used)
+	c.monthly.used = c.monthly.used.sub(used)
+}
+
+// Record списывает расход, известный только после вызова провайдера (токены).
+// Лимит при этом может быть превышен — следующий запрос будет отклонён.
+func (m *Manager) Record(key string, used Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.counters(key, true)
+	c.daily.used = c.daily.used.add(used)
+	c.monthly.used = c.monthly.used.add(used)
+}
+
+// Remaining возвращает общий остаток: минимум из дневного и месячного.
+func (m *Manager) Remaining(key string) Remaining {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.counters(key, false)
+	l := m.limitsFor(key)
+
+	daily := remaining(l.daily, c.daily.used, c.daily.start.AddDate(0, 0, 1))
+	monthly := remaining(l.monthly, c.monthly.used, c.monthly.start.AddDate(0, 1, 0))
+
+	r := Remaining{
+		Images: minLimit(daily.Images, monthly.Images),
+		Pages:  minLimit(daily.Pages, monthly.Pages),
+		Tokens: minLimit(daily.Tokens, monthly.Tokens),
+		Budget: minBudget(daily.Budget, monthly.Budget),
+	}
+
+	// Время сброса — у периода, который сейчас ограничивает сильнее.
+	r.ResetAt = daily.ResetAt
+	if exhausted(monthly) {
+		r.ResetAt = monthly.ResetAt
+	}
+	return r
+}
+
+// Usage возвращает расход клиента за текущие сутки и месяц.
+func (m *Manager) Usage(key string) (daily, monthly Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.counters(key, false)
+	return c.daily.used, c.monthly.used
+}
+
+// Reset обнуляет счётчики клиента.
+func (m *Manager) Reset(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.usage, key)
+}
+
+func (m *Manager) check(key string, c *counters, want Usage) error {
+	l := m.limitsFor(key)
+
+	if res := exceeds(l.daily, c.daily.used, want); res != "" {
+		return &ExceededError{Period: PeriodDaily, Resource: res, ResetAt: c.daily.start.AddDate(0, 0, 1)}
+	}
+	if res := exceeds(l.monthly, c.monthly.used, want); res != "" {
+		return &ExceededError{Period: PeriodMonthly, Resource: res, ResetAt: c.monthly.start.AddDate(0, 1, 0)}
+	}
+	return nil
+}
+
+func (m *Manager) limitsFor(key string) limits {
+	if l, ok := m.overrides[key]; ok {
+		return l
+	}
+	return m.defaults
+}
+
+// counters возвращает счётчики клиента, сбрасывая истёкшие периоды. Вызывать под m.mu.
+// Без create счётчики нового клиента не сохраняются: проверка и чтение остатка
+// не заводят запись на каждый анонимный IP.
+func (m *Manager) counters(key string, create bool) *counters {
+	now := m.now().UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	m.prune(dayStart, monthStart)
+
+	c, ok := m.usage[key]
+	if !ok {
+		c = &counters{}
+		if create {
+			m.usage[key] = c
+		}
+	}
+	if !c.daily.start.Equal(dayStart) {
+		c.daily = window{start: dayStart}
+	}
+	if !c.monthly.start.Equal(monthStart) {
+		c.monthly = window{start: monthStart}
+	}
+	return c
+}
+
+// prune раз в сутки удаляет клиентов без расхода в текущем месяце: их
+// счётчики всё равно обнулились бы при следующем обращении. Вызывать под m.mu.
+func (m *Manager) prune(dayStart, monthStart time.Time) {
+	if m.prunedAt.Equal(dayStart) {
+		return
+	}
+	m.prunedAt = dayStart
+
+	for key, c := range m.usage {
+		if !c.monthly.start.Equal(monthStart) || c.monthly.used == (Usage{}) {
+			delete(m.usage, key)
+		}
+	}
+}
+
+func exceeds(l config.QuotaLimits, used, want Usage) string {
+	switch {
+	case l.Images > 0 && used.Images+want.Images > l.Images:
+		return ResourceImages
+	case l.Pages > 0 && used.Pages+want.Pages > l.Pages:
+		return ResourcePages
+	case l.Tokens > 0 && used.Tokens+want.Tokens > l.Tokens:
+		return ResourceTokens
+	case l.Budget > 0 && used.Cost+want.Cost > l.Budget:
+		return ResourceBudget
+	}
+
+	// Токены и деньги списываются после вызова, поэтому их перерасход
+	// блокирует следующий запрос, даже если сам запрос «ничего не стоит».
+	switch {
+	case l.Tokens > 0 && used.Tokens >= l.Tokens:
+		return ResourceTokens
+	case l.Budget > 0 && used.Cost >= l.Budget:
+		return ResourceBudget
+	}
+	return ""
+}
+
+func remaining(l config.QuotaLimits, used Usage, resetAt time.Time) Remaining {
+	r := Remaining{Images: -1, Pages: -1, Tokens: -1, Budget: -1, ResetAt: resetAt}
+	if l.Images > 0 {
+		r.Images = max(l.Images-used.Images, 0)
+	}
+	if l.Pages > 0 {
+		r.Pages = max(l.Pages-used.Pages, 0)
+	}
+	if l.Tokens > 0 {
+		r.Tokens = max(l.Tokens-used.Tokens, 0)
+	}
+	if l.Budget > 0 {
+		r.Budget = math.Max(l.Budget-used.Cost, 0)
+	}
+	return r
+}
+
+func exhausted(r Remaining) bool {
+	return r.Images == 0 || r.Pages == 0 || r.Tokens == 0 || r.Budget == 0
+}
+
+func minLimit(a, b int64) int64 {
+	if a < 0 {
+		return b
+	}
+	if b < 0 {
+		return a
+	}
+	return min(a, b)
+}
+
+func minBudget(a, b float64) float64 {
+	if a < 0 {
+		return b
+	}
+	if b < 0 {
+		return a
+	}
+	return math.Min(a, b)
+}
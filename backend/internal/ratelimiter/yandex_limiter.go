@@ -3,15 +3,18 @@ package ratelimiter
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type YandexRateLimiter struct {
 	tokens     chan struct{}
-	refillRate time.Duration
+	refillRate atomic.Int64 // time.Duration между пополнениями
+	rateCh     chan time.Duration
 	stopCh     chan struct{}
 	stopOnce   sync.Once
 	wg         sync.WaitGroup
+	waiting    atomic.Int64
 }
 
 func NewYandexRateLimiter(requestsPerSecond int) *YandexRateLimiter {
@@ -20,10 +23,11 @@ func NewYandexRateLimiter(requestsPerSecond int) *YandexRateLimiter {
 	}
 
 	rl := &YandexRateLimiter{
-		tokens:     make(chan struct{}, requestsPerSecond),
-		refillRate: time.Second / time.Duration(requestsPerSecond),
-		stopCh:     make(chan struct{}),
+		tokens: make(chan struct{}, requestsPerSecond),
+		rateCh: make(chan time.Duration, 1),
+		stopCh: make(chan struct{}),
 	}
+	rl.refillRate.Store(int64(time.Second / time.Duration(requestsPerSecond)))
 
 	for i := 0; i < requestsPerSecond; i++ {
 		rl.tokens <- struct{}{}
@@ -37,13 +41,15 @@ func NewYandexRateLimiter(requestsPerSecond int) *YandexRateLimiter {
 
 func (rl *YandexRateLimiter) refill() {
 	defer rl.wg.Done()
-	ticker := time.NewTicker(rl.refillRate)
+	ticker := time.NewTicker(time.Duration(rl.refillRate.Load()))
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-rl.stopCh:
 			return
+		case rate := <-rl.rateCh:
+			ticker.Reset(rate)
 		case <-ticker.C:
 			select {
 			case rl.tokens <- struct{}{}:
@@ -56,6 +62,9 @@ func (rl *YandexRateLimiter) refill() {
 }
 
 func (rl *YandexRateLimiter) Acquire(ctx context.Context) error {
+	rl.waiting.Add(1)
+	defer rl.waiting.Add(-1)
+
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
@@ -73,6 +82,41 @@ func (rl *YandexRateLimiter) TryAcquire() bool {
 	}
 }
 
+// LimiterState — снимок состояния лимитера для мониторинга.
+type LimiterState struct {
+	Available  int     `json:"available"`
+	Capacity   int     `json:"capacity"`
+	Waiting    int64   `json:"waiting"`
+	RatePerSec float64 `json:"rate_per_sec"`
+}
+
+func (rl *YandexRateLimiter) State() LimiterState {
+	return LimiterState{
+		Available:  len(rl.tokens),
+		Capacity:   cap(rl.tokens),
+		Waiting:    rl.waiting.Load(),
+		RatePerSec: float64(time.Second) / float64(rl.refillRate.Load()),
+	}
+}
+
+// SetRate меняет скорость пополнения без сброса накопленных токенов.
+// Ёмкость корзины остаётся прежней, заданной при создании.
+func (rl *YandexRateLimiter) SetRate(requestsPerSecond int) {
+	if requestsPerSecond <= 0 {
+		return
+	}
+	rate := time.Second / time.Duration(requestsPerSecond)
+	if time.Duration(rl.refillRate.Swap(int64(rate))) == rate {
+		return
+	}
+
+	select {
+	case <-rl.rateCh:
+	default:
+	}
+	rl.rateCh <- rate
+}
+
 func (rl *YandexRateLimiter) Stop() {
 	rl.stopOnce.Do(func() {
 		close(rl.stopCh)
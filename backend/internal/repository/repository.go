@@ -1,5 +1,86 @@
 package repository
 
+import (
+	"context"
+	"errors"
+)
+
+// OCRRepository распознаёт текст; mimeType определён по содержимому
+// (см. пакет filetype), провайдеру не нужно угадывать формат самому.
 type OCRRepository interface {
-	RecognizeFromBytes(data []byte) (string, error)
+	RecognizeFromBytes(ctx context.Context, data []byte, mimeType string) (string, error)
+}
+
+// Usage — расход ресурсов провайдера на одно распознавание.
+type Usage struct {
+	Tokens int64
+}
+
+// UsageReporter реализуют провайдеры, которые сообщают расход токенов.
+type UsageReporter interface {
+	RecognizeWithUsage(ctx context.Context, data []byte, mimeType string) (string, Usage, error)
+}
+
+// DocumentRecognizer реализуют провайдеры, которые сами принимают
+// многостраничные документы. MaxDocumentPages возвращает, сколько страниц
+// документа mimeType можно отправить одним вызовом (0 — формат не принимается целиком).
+type DocumentRecognizer interface {
+	MaxDocumentPages(mimeType string) int
+}
+
+// PageRecognizer реализуют провайдеры, которые распознают многостраничный
+// документ одним вызовом (например, асинхронным API) и возвращают результат
+// каждой страницы отдельно, по порядку страниц. Обёртки над провайдером
+// без такой возможности возвращают ErrPagesUnsupported.
+type PageRecognizer interface {
+	RecognizePages(ctx context.Context, data []byte, mimeType string) ([]string, error)
+}
+
+// ErrPagesUnsupported: провайдер не раскладывает документ по страницам,
+// документ распознаётся обычным RecognizeFromBytes.
+var ErrPagesUnsupported = errors.New("provider does not recognize documents page by page")
+
+// OperationResumer реализуют провайдеры с асинхронным API: они умеют
+// дождаться операции, запущенной до перезапуска сервиса, и забрать её
+// результат — по ответу на страницу, как PageRecognizer.
+type OperationResumer interface {
+	ResumeOperation(ctx context.Context, id string) ([]string, error)
+}
+
+// ErrResumeUnsupported: провайдер не умеет досчитывать асинхронные операции.
+var ErrResumeUnsupported = errors.New("provider does not resume asynchronous operations")
+
+type modelKey struct{}
+
+type operationHookKey struct{}
+
+// OperationHook получает ID запущенной асинхронной операции и возвращает
+// функцию, которую провайдер вызывает, когда операция завершена.
+type OperationHook func(id string) (done func())
+
+// WithOperationHook подписывает вызывающего на асинхронные операции,
+// которые провайдер запускает в рамках ctx.
+func WithOperationHook(ctx context.Context, hook OperationHook) context.Context {
+	return context.WithValue(ctx, operationHookKey{}, hook)
+}
+
+// trackOperation сообщает подписчику из ctx о запущенной операции.
+func trackOperation(ctx context.Context, id string) (done func()) {
+	if hook, ok := ctx.Value(operationHookKey{}).(OperationHook); ok && hook != nil {
+		return hook(id)
+	}
+	return func() {}
+}
+
+// WithModel задаёт модель провайдера для одного запроса вместо модели из конфигурации.
+func WithModel(ctx context.Context, model string) context.Context {
+	return context.WithValue(ctx, modelKey{}, model)
+}
+
+// ModelFrom возвращает модель, заданную WithModel, или fallback.
+func ModelFrom(ctx context.Context, fallback string) string {
+	if model, ok := ctx.Value(modelKey{}).(string); ok && model != "" {
+		return model
+	}
+	return fallback
 }
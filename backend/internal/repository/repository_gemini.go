@@ -8,54 +8,106 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 
+	"github.com/airsss993/ocr-history/internal/config"
+	"github.com/airsss993/ocr-history/internal/tracing"
 	"github.com/airsss993/ocr-history/pkg/logger"
 	"google.golang.org/genai"
 )
 
+// DefaultGeminiPrompt — системная инструкция, если ocr.geminiPrompt не задан.
+const DefaultGeminiPrompt = `Ты — специалист по оцифровке старинных документов (архивные бумаги, рукописи, дореформенная орфография, бледные чернила, пятна, разрывы). Твоя задача — извлечь весь видимый текст с фотографии и вернуть его строго в JSON, соответствующий указанной схеме.
+
+Правила распознавания
+	1.	Не выдумывай отсутствующий текст. Если символ/слово не читается — помечай это в notes и/или warnings.
+	2.	Сохраняй орфографию оригинала (включая дореформенные буквы/написания), как на документе.
+	•	Если уверен — пиши как есть.
+	•	Если сомневаешься — используй маркеры:
+	•	⟦неразборчиво⟧
+	•	⟦возможн.: ...⟧ (1–3 варианта)
+	3.	Сохраняй структуру: переносы строк, абзацы, заголовки, нумерацию, списки.
+	4.	Таблицы: если видна таблица — оформляй в Markdown-таблицу. Если границы колонок сомнительны — всё равно делай таблицу и добавляй предупреждение.
+	5.	Отмечай специальные элементы:
+	•	подпись: помечай строкой *[Подпись]*: ... (если читается) или *[Подпись]*: ⟦неразборчиво⟧
+	•	печать/штамп: *[Печать]*: ... или *[Печать]*: ⟦текст неразборчиво⟧
+	6.	Если на фото несколько фрагментов/страниц — распознавай всё подряд, разделяя в text_markdown заметными разделителями ---.
+
+Формат ответа
+	•	Верни только валидный JSON
+	•	Поля заполняй в таком смысле:
+	•	summary: 2–4 предложения по факту того, что реально видно в тексте (тип документа, даты/место/лица, если читается).
+	•	document_title: заголовок/шапка, если есть, иначе пустая строка.
+	•	text_markdown: полный текст документа в Markdown.
+	•	notes: сомнения/варианты чтения, где именно проблемы (например: "строка 3 сверху, правый край обрезан").
+	•	warnings: список коротких предупреждений (качество, засвет, наклон, обрезано, размыто, курсив/скоропись, дореформенная орфография и т.д.).
+`
+
+// geminiMIMETypes — форматы, которые Gemini принимает как inline data.
+var geminiMIMETypes = []string{"image/jpeg", "image/png", "image/webp", "image/heic", "application/pdf"}
+
 type GeminiRepository struct {
 	apiKey   string
 	model    string
 	proxyURL string
+	prompt   string
 }
 
-func NewGeminiRepository(apiKey, model, proxyURL string) *GeminiRepository {
+func NewGeminiRepository(apiKey, model, proxyURL, prompt string) *GeminiRepository {
 	if model == "" {
-		model = "gemini-3-pro-preview"
+		model = config.DefaultGeminiModel
+	}
+	if prompt == "" {
+		prompt = DefaultGeminiPrompt
 	}
 	return &GeminiRepository{
 		apiKey:   apiKey,
 		model:    model,
 		proxyURL: proxyURL,
+		prompt:   prompt,
 	}
 }
 
-func (r *GeminiRepository) RecognizeFromBytes(data []byte) (string, error) {
+func (r *GeminiRepository) RecognizeFromBytes(ctx context.Context, data []byte, mimeType string) (string, error) {
+	text, _, err := r.RecognizeWithUsage(ctx, data, mimeType)
+	return text, err
+}
+
+func (r *GeminiRepository) RecognizeWithUsage(ctx context.Context, data []byte, mimeType string) (string, Usage, error) {
+	var usage Usage
+
 	if len(data) == 0 {
 		err := fmt.Errorf("empty data")
-		logger.Error(err)
-		return "", err
+		logger.ErrorCtx(ctx, err)
+		return "", usage, err
 	}
 
-	ctx := context.Background()
+	if !slices.Contains(geminiMIMETypes, mimeType) {
+		err := fmt.Errorf("gemini does not support %s", mimeType)
+		logger.ErrorCtx(ctx, err)
+		return "", usage, err
+	}
 
 	if r.apiKey == "" {
 		err := fmt.Errorf("gemini API key is empty")
-		logger.Error(err)
-		return "", err
+		logger.ErrorCtx(ctx, err)
+		return "", usage, err
 	}
 
 	clientConfig := &genai.ClientConfig{
 		APIKey: r.apiKey,
+		HTTPClient: &http.Client{
+			Transport: tracing.Transport(nil),
+		},
 	}
 
 	if r.proxyURL != "" {
 		proxyURL, err := url.Parse(r.proxyURL)
 		if err != nil {
-			err := fmt.Errorf("failed to parse proxy URL: %w", err)
-			logger.Error(err)
-			return "", err
+			err := logger.Errorf("failed to parse proxy URL: %w", err)
+			logger.ErrorCtx(ctx, err)
+			return "", usage, err
 		}
 
 		transport := &http.Transport{
@@ -66,26 +118,15 @@ func (r *GeminiRepository) RecognizeFromBytes(data []byte) (string, error) {
 		}
 
 		clientConfig.HTTPClient = &http.Client{
-			Transport: transport,
+			Transport: tracing.Transport(transport),
 		}
 	}
 
 	client, err := genai.NewClient(ctx, clientConfig)
 	if err != nil {
-		err := fmt.Errorf("failed to create Gemini client: %w", err)
-		logger.Error(err)
-		return "", err
-	}
-
-	mimeType := "image/jpeg"
-	if len(data) > 4 {
-		if data[0] == 0x89 && data[1] == 0x50 && data[2] == 0x4E && data[3] == 0x47 {
-			mimeType = "image/png"
-		}
-		if len(data) > 12 && data[0] == 0x52 && data[1] == 0x49 && data[2] == 0x46 && data[3] == 0x46 &&
-			data[8] == 0x57 && data[9] == 0x45 && data[10] == 0x42 && data[11] == 0x50 {
-			mimeType = "image/webp"
-		}
+		err := logger.Errorf("failed to create Gemini client: %w", err)
+		logger.ErrorCtx(ctx, err)
+		return "", usage, err
 	}
 
 	contents := []*genai.Content{
@@ -154,31 +195,7 @@ func (r *GeminiRepository) RecognizeFromBytes(data []byte) (string, error) {
 		}(),
 		SystemInstruction: &genai.Content{
 			Parts: []*genai.Part{
-				genai.NewPartFromText(`Ты — специалист по оцифровке старинных документов (архивные бумаги, рукописи, дореформенная орфография, бледные чернила, пятна, разрывы). Твоя задача — извлечь весь видимый текст с фотографии и вернуть его строго в JSON, соответствующий указанной схеме.
-
-Правила распознавания
-	1.	Не выдумывай отсутствующий текст. Если символ/слово не читается — помечай это в notes и/или warnings.
-	2.	Сохраняй орфографию оригинала (включая дореформенные буквы/написания), как на документе.
-	•	Если уверен — пиши как есть.
-	•	Если сомневаешься — используй маркеры:
-	•	⟦неразборчиво⟧
-	•	⟦возможн.: ...⟧ (1–3 варианта)
-	3.	Сохраняй структуру: переносы строк, абзацы, заголовки, нумерацию, списки.
-	4.	Таблицы: если видна таблица — оформляй в Markdown-таблицу. Если границы колонок сомнительны — всё равно делай таблицу и добавляй предупреждение.
-	5.	Отмечай специальные элементы:
-	•	подпись: помечай строкой *[Подпись]*: ... (если читается) или *[Подпись]*: ⟦неразборчиво⟧
-	•	печать/штамп: *[Печать]*: ... или *[Печать]*: ⟦текст неразборчиво⟧
-	6.	Если на фото несколько фрагментов/страниц — распознавай всё подряд, разделяя в text_markdown заметными разделителями ---.
-
-Формат ответа
-	•	Верни только валидный JSON
-	•	Поля заполняй в таком смысле:
-	•	summary: 2–4 предложения по факту того, что реально видно в тексте (тип документа, даты/место/лица, если читается).
-	•	document_title: заголовок/шапка, если есть, иначе пустая строка.
-	•	text_markdown: полный текст документа в Markdown.
-	•	notes: сомнения/варианты чтения, где именно проблемы (например: "строка 3 сверху, правый край обрезан").
-	•	warnings: список коротких предупреждений (качество, засвет, наклон, обрезано, размыто, курсив/скоропись, дореформенная орфография и т.д.).
-`),
+				genai.NewPartFromText(r.prompt),
 			},
 		},
 	}
@@ -187,9 +204,13 @@ func (r *GeminiRepository) RecognizeFromBytes(data []byte) (string, error) {
 
 	for result, err := range client.Models.GenerateContentStream(ctx, r.model, contents, config) {
 		if err != nil {
-			err := fmt.Errorf("failed to generate content: %w", err)
-			logger.Error(err)
-			return "", err
+			err := logger.Errorf("failed to generate content: %w", err)
+			logger.ErrorCtx(ctx, err)
+			return "", usage, err
+		}
+
+		if result.UsageMetadata != nil && result.UsageMetadata.TotalTokenCount > 0 {
+			usage.Tokens = int64(result.UsageMetadata.TotalTokenCount)
 		}
 
 		if len(result.Candidates) == 0 || result.Candidates[0].Content == nil || len(result.Candidates[0].Content.Parts) == 0 {
@@ -206,9 +227,9 @@ func (r *GeminiRepository) RecognizeFromBytes(data []byte) (string, error) {
 
 	text := resultText.String()
 	if text == "" {
-		logger.Warn("empty result from Gemini API")
-		return "", nil
+		logger.WarnCtx(ctx, "empty result from Gemini API")
+		return "", usage, nil
 	}
 
-	return text, nil
+	return text, usage, nil
 }
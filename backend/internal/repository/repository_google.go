@@ -2,6 +2,7 @@ package repository
 
 import (
 	"bytes"
+	"context"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
@@ -12,20 +13,27 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/airsss993/ocr-history/internal/tracing"
 	"github.com/airsss993/ocr-history/pkg/logger"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// googleMIMETypes — форматы, которые images:annotate принимает inline.
+var googleMIMETypes = []string{"image/jpeg", "image/png", "image/gif", "image/bmp", "image/webp"}
+
 type GoogleVisionRepository struct {
 	credentialsPath string // Путь к JSON файлу с credentials
+	client          *http.Client
 }
 
 func NewGoogleVisionRepository(credentialsPath string) *GoogleVisionRepository {
 	return &GoogleVisionRepository{
 		credentialsPath: credentialsPath,
+		client:          &http.Client{Transport: tracing.Transport(nil)},
 	}
 }
 
@@ -147,8 +155,14 @@ type serviceAccountKey struct {
 	ClientX509CertURL       string `json:"client_x509_cert_url"`
 }
 
+// Ping проверяет, что по учётным данным сервисного аккаунта выдаётся токен доступа.
+func (r *GoogleVisionRepository) Ping(ctx context.Context) error {
+	_, err := r.getAccessToken(ctx)
+	return err
+}
+
 // getAccessToken получает access token используя service account
-func (r *GoogleVisionRepository) getAccessToken() (string, error) {
+func (r *GoogleVisionRepository) getAccessToken(ctx context.Context) (string, error) {
 	// Читаем файл с credentials
 	credData, err := os.ReadFile(r.credentialsPath)
 	if err != nil {
@@ -197,9 +211,15 @@ func (r *GoogleVisionRepository) getAccessToken() (string, error) {
 	data.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
 	data.Set("assertion", signedToken)
 
-	resp, err := http.PostForm("https://oauth2.googleapis.com/token", data)
+	tokenReq, err := http.NewRequestWithContext(ctx, "POST", "https://oauth2.googleapis.com/token", strings.NewReader(data.Encode()))
 	if err != nil {
-		return "", fmt.Errorf("failed to exchange JWT for token: %w", err)
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := r.client.Do(tokenReq)
+	if err != nil {
+		return "", logger.Errorf("failed to exchange JWT for token: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -209,7 +229,7 @@ func (r *GoogleVisionRepository) getAccessToken() (string, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("token exchange failed: %s", string(body))
+		return "", fmt.Errorf("token exchange failed (status %d): %s", resp.StatusCode, logger.RedactBody(body))
 	}
 
 	var tokenResp struct {
@@ -225,10 +245,16 @@ func (r *GoogleVisionRepository) getAccessToken() (string, error) {
 	return tokenResp.AccessToken, nil
 }
 
-func (r *GoogleVisionRepository) RecognizeFromBytes(data []byte) (string, error) {
+func (r *GoogleVisionRepository) RecognizeFromBytes(ctx context.Context, data []byte, mimeType string) (string, error) {
 	if len(data) == 0 {
 		err := fmt.Errorf("empty data")
-		logger.Error(err)
+		logger.ErrorCtx(ctx, err)
+		return "", err
+	}
+
+	if !slices.Contains(googleMIMETypes, mimeType) {
+		err := fmt.Errorf("google vision does not support %s", mimeType)
+		logger.ErrorCtx(ctx, err)
 		return "", err
 	}
 
@@ -255,28 +281,29 @@ func (r *GoogleVisionRepository) RecognizeFromBytes(data []byte) (string, error)
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
 		err := fmt.Errorf("failed to marshal request: %w", err)
-		logger.Error(err)
+		logger.ErrorCtx(ctx, err)
 		return "", err
 	}
 
 	// Получаем access token
-	accessToken, err := r.getAccessToken()
+	accessToken, err := r.getAccessToken(ctx)
 	if err != nil {
 		err := fmt.Errorf("failed to get access token: %w", err)
-		logger.Error(err)
+		logger.ErrorCtx(ctx, err)
 		return "", err
 	}
 
 	// Создаем HTTP запрос
 	apiURL := "https://vision.googleapis.com/v1/images:annotate"
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"POST",
 		apiURL,
 		bytes.NewBuffer(jsonData),
 	)
 	if err != nil {
 		err := fmt.Errorf("failed to create request: %w", err)
-		logger.Error(err)
+		logger.ErrorCtx(ctx, err)
 		return "", err
 	}
 
@@ -285,11 +312,10 @@ func (r *GoogleVisionRepository) RecognizeFromBytes(data []byte) (string, error)
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 
 	// Отправляем запрос
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := r.client.Do(req)
 	if err != nil {
-		err := fmt.Errorf("failed to send request: %w", err)
-		logger.Error(err)
+		err := logger.Errorf("failed to send request: %w", err)
+		logger.ErrorCtx(ctx, err)
 		return "", err
 	}
 	defer resp.Body.Close()
@@ -298,7 +324,7 @@ func (r *GoogleVisionRepository) RecognizeFromBytes(data []byte) (string, error)
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		err := fmt.Errorf("failed to read response: %w", err)
-		logger.Error(err)
+		logger.ErrorCtx(ctx, err)
 		return "", err
 	}
 
@@ -309,12 +335,12 @@ func (r *GoogleVisionRepository) RecognizeFromBytes(data []byte) (string, error)
 			Error googleError `json:"error"`
 		}
 		if json.Unmarshal(body, &apiError) == nil && apiError.Error.Message != "" {
-			err := fmt.Errorf("google vision API error (status %d): %s", resp.StatusCode, apiError.Error.Message)
-			logger.Error(err)
+			err := fmt.Errorf("google vision API error (status %d): %s", resp.StatusCode, logger.Redact(apiError.Error.Message))
+			logger.ErrorCtx(ctx, err)
 			return "", err
 		}
-		err := fmt.Errorf("google vision API returned status %d: %s", resp.StatusCode, string(body))
-		logger.Error(err)
+		err := fmt.Errorf("google vision API returned status %d: %s", resp.StatusCode, logger.RedactBody(body))
+		logger.ErrorCtx(ctx, err)
 		return "", err
 	}
 
@@ -322,20 +348,20 @@ func (r *GoogleVisionRepository) RecognizeFromBytes(data []byte) (string, error)
 	var visionResp googleVisionResponse
 	if err := json.Unmarshal(body, &visionResp); err != nil {
 		err := fmt.Errorf("failed to unmarshal response: %w", err)
-		logger.Error(err)
+		logger.ErrorCtx(ctx, err)
 		return "", err
 	}
 
 	// Проверяем наличие результата
 	if len(visionResp.Responses) == 0 {
-		logger.Warn("empty response from Google Vision API")
+		logger.WarnCtx(ctx, "empty response from Google Vision API")
 		return "", nil
 	}
 
 	// Проверяем на ошибки в ответе
 	if visionResp.Responses[0].Error != nil {
-		err := fmt.Errorf("google vision API error: %s", visionResp.Responses[0].Error.Message)
-		logger.Error(err)
+		err := fmt.Errorf("google vision API error: %s", logger.Redact(visionResp.Responses[0].Error.Message))
+		logger.ErrorCtx(ctx, err)
 		return "", err
 	}
 
@@ -8,37 +8,92 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/airsss993/ocr-history/internal/filetype"
+	"github.com/airsss993/ocr-history/internal/preprocess"
 	"github.com/airsss993/ocr-history/internal/ratelimiter"
+	"github.com/airsss993/ocr-history/internal/tracing"
 	"github.com/airsss993/ocr-history/pkg/logger"
 )
 
 const (
-	yandexSyncOCREndpoint = "https://ocr.api.cloud.yandex.net/ocr/v1/recognizeText"
+	yandexSyncOCREndpoint     = "https://ocr.api.cloud.yandex.net/ocr/v1/recognizeText"
+	yandexAsyncOCREndpoint    = "https://ocr.api.cloud.yandex.net/ocr/v1/recognizeTextAsync"
+	yandexOperationsEndpoint  = "https://operation.api.cloud.yandex.net/operations"
+	yandexRecognitionEndpoint = "https://ocr.api.cloud.yandex.net/ocr/v1/getRecognition"
 )
 
+// YandexEndpoints — адреса API Vision OCR; пустые поля заменяются адресами Yandex Cloud.
+type YandexEndpoints struct {
+	Sync        string
+	Async       string
+	Operations  string // к адресу добавляется /<id> операции
+	Recognition string
+}
+
+// YandexAsyncOptions — когда и как использовать асинхронный API.
+type YandexAsyncOptions struct {
+	Enabled      bool
+	MinSize      int64 // изображения от этого размера в байтах — асинхронно; 0 — только PDF
+	MaxPages     int   // страниц PDF в одной операции
+	PollInterval time.Duration
+	Timeout      time.Duration
+}
+
 type YandexOCRRepository struct {
 	apiKey      string
+	iam         *YandexIAMTokenSource // задан — вместо apiKey передаётся IAM-токен
 	folderID    string
 	model       string
+	endpoints   YandexEndpoints
+	async       YandexAsyncOptions
 	rateLimiter *ratelimiter.YandexRateLimiter
 	client      *http.Client
 }
 
+// NewYandexOCRRepository создаёт клиент Vision OCR. Если iam не nil,
+// запросы подписываются IAM-токеном сервисного аккаунта, а apiKey не используется.
 func NewYandexOCRRepository(
-	apiKey, folderID, model string,
+	apiKey string,
+	iam *YandexIAMTokenSource,
+	folderID, model string,
+	endpoints YandexEndpoints,
+	async YandexAsyncOptions,
 	rateLimiter *ratelimiter.YandexRateLimiter,
 ) *YandexOCRRepository {
 	if model == "" {
 		model = "page"
 	}
+	if endpoints.Sync == "" {
+		endpoints.Sync = yandexSyncOCREndpoint
+	}
+	if endpoints.Async == "" {
+		endpoints.Async = yandexAsyncOCREndpoint
+	}
+	if endpoints.Operations == "" {
+		endpoints.Operations = yandexOperationsEndpoint
+	}
+	if endpoints.Recognition == "" {
+		endpoints.Recognition = yandexRecognitionEndpoint
+	}
+	if async.PollInterval <= 0 {
+		async.PollInterval = 2 * time.Second
+	}
+	if async.Timeout <= 0 {
+		async.Timeout = 10 * time.Minute
+	}
 	return &YandexOCRRepository{
 		apiKey:      apiKey,
+		iam:         iam,
 		folderID:    folderID,
 		model:       model,
+		endpoints:   endpoints,
+		async:       async,
 		rateLimiter: rateLimiter,
-		client:      &http.Client{Timeout: 240 * time.Second},
+		client:      &http.Client{Timeout: 240 * time.Second, Transport: tracing.Transport(nil)},
 	}
 }
 
@@ -105,105 +160,263 @@ type yandexEntity struct {
 	Text string `json:"text"`
 }
 
+// yandexDocumentModels — модель Vision OCR по типу документа из запроса.
+var yandexDocumentModels = map[string]string{
+	"printed":                    "page",
+	"columns":                    "page-column-sort",
+	"newspaper":                  "page-column-sort",
+	"handwritten":                "handwritten",
+	"letter":                     "handwritten",
+	"manuscript":                 "handwritten",
+	"table":                      "table",
+	"register":                   "table", // метрические и ревизские книги
+	"census":                     "table", // переписные листы
+	"markdown":                   "markdown",
+	"formula":                    "math-markdown",
+	"passport":                   "passport",
+	"driver-license-front":       "driver-license-front",
+	"driver-license-back":        "driver-license-back",
+	"vehicle-registration-front": "vehicle-registration-front",
+	"vehicle-registration-back":  "vehicle-registration-back",
+	"license-plate":              "license-plates",
+}
+
+// YandexModelForDocument подбирает модель по типу документа.
+func YandexModelForDocument(documentType string) (string, bool) {
+	model, ok := yandexDocumentModels[strings.ToLower(strings.TrimSpace(documentType))]
+	return model, ok
+}
+
+// YandexDocumentTypes возвращает известные типы документов.
+func YandexDocumentTypes() []string {
+	types := make([]string, 0, len(yandexDocumentModels))
+	for t := range yandexDocumentModels {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// yandexMimeTypes — MIME-типы и их обозначения в поле mimeType Yandex Vision OCR.
+// Остальные растровые форматы перед отправкой перекодируются в PNG.
+var yandexMimeTypes = map[string]string{
+	"image/jpeg":      "JPEG",
+	"image/png":       "PNG",
+	"application/pdf": "PDF",
+}
+
+// MaxDocumentPages: синхронный API Yandex Vision OCR принимает PDF
+// только из одной страницы, многостраничные документы разбиваются сервисом.
+// С асинхронным API PDF отправляется целиком, до async.MaxPages страниц.
+func (r *YandexOCRRepository) MaxDocumentPages(mimeType string) int {
+	if mimeType != "application/pdf" {
+		return 0
+	}
+	if r.async.Enabled && r.async.MaxPages > 1 {
+		return r.async.MaxPages
+	}
+	return 1
+}
+
+// bearerToken возвращает IAM-токен сервисного аккаунта или статический ключ.
+func (r *YandexOCRRepository) bearerToken(ctx context.Context) (string, error) {
+	if r.iam != nil {
+		return r.iam.Token(ctx)
+	}
+	return r.apiKey, nil
+}
+
 type yandexError struct {
 	Code    int           `json:"code"`
 	Message string        `json:"message"`
 	Details []interface{} `json:"details,omitempty"`
 }
 
-func (r *YandexOCRRepository) RecognizeFromBytes(data []byte) (string, error) {
-	if len(data) == 0 {
-		err := fmt.Errorf("empty data")
-		logger.Error(err)
+func (r *YandexOCRRepository) RecognizeFromBytes(ctx context.Context, data []byte, mimeType string) (string, error) {
+	body, err := r.requestBody(ctx, data, mimeType)
+	if err != nil {
 		return "", err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	if r.useAsync(data, mimeType) {
+		pages, err := r.recognizeAsync(ctx, body)
+		if err != nil {
+			return "", err
+		}
+		if len(pages) != 1 {
+			err := fmt.Errorf("yandex async OCR returned %d pages for a single image", len(pages))
+			logger.ErrorCtx(ctx, err)
+			return "", err
+		}
+		return pages[0], nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 120*time.Second)
 	defer cancel()
 
-	if err := r.rateLimiter.Acquire(ctx); err != nil {
-		err := fmt.Errorf("rate limiter timeout: %w", err)
-		logger.Error(err)
+	if err := r.acquire(ctx); err != nil {
 		return "", err
 	}
 
-	encodedImage := base64.StdEncoding.EncodeToString(data)
+	respBody, err := r.call(ctx, "POST", r.endpoints.Sync, body)
+	if err != nil {
+		return "", err
+	}
 
-	mimeType := "JPEG"
-	if len(data) > 4 {
-		if data[0] == 0x89 && data[1] == 0x50 && data[2] == 0x4E && data[3] == 0x47 {
-			mimeType = "PNG"
+	var ocrResp yandexOCRResponse
+	if err := json.Unmarshal(respBody, &ocrResp); err != nil {
+		err := fmt.Errorf("failed to unmarshal response: %w", err)
+		logger.ErrorCtx(ctx, err)
+		return "", err
+	}
+
+	if ocrResp.Result == nil || ocrResp.Result.TextAnnotation == nil {
+		logger.WarnCtx(ctx, "empty result from Yandex OCR API")
+		return "", nil
+	}
+
+	return string(respBody), nil
+}
+
+// RecognizePages распознаёт документ асинхронным API и возвращает ответ
+// по каждой странице в том же виде, что и синхронный API. Без асинхронного
+// режима возвращает ErrPagesUnsupported.
+func (r *YandexOCRRepository) RecognizePages(ctx context.Context, data []byte, mimeType string) ([]string, error) {
+	if !r.useAsync(data, mimeType) {
+		return nil, ErrPagesUnsupported
+	}
+
+	body, err := r.requestBody(ctx, data, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	return r.recognizeAsync(ctx, body)
+}
+
+// useAsync: асинхронно отправляются PDF и изображения не меньше async.MinSize.
+func (r *YandexOCRRepository) useAsync(data []byte, mimeType string) bool {
+	if !r.async.Enabled {
+		return false
+	}
+	return mimeType == "application/pdf" || (r.async.MinSize > 0 && int64(len(data)) >= r.async.MinSize)
+}
+
+// requestBody проверяет данные и собирает тело запроса распознавания,
+// общее для синхронного и асинхронного API.
+func (r *YandexOCRRepository) requestBody(ctx context.Context, data []byte, mimeType string) ([]byte, error) {
+	if len(data) == 0 {
+		err := fmt.Errorf("empty data")
+		logger.ErrorCtx(ctx, err)
+		return nil, err
+	}
+
+	yandexMimeType, ok := yandexMimeTypes[mimeType]
+	if !ok {
+		// WebP, GIF, BMP и TIFF Yandex не принимает — отправляем их в PNG.
+		format, known := filetype.ByMIME(mimeType)
+		if !known {
+			err := fmt.Errorf("yandex OCR does not support %s", mimeType)
+			logger.ErrorCtx(ctx, err)
+			return nil, err
+		}
+		converted, err := preprocess.ToPNG(data, format)
+		if err != nil {
+			err := fmt.Errorf("yandex OCR does not support %s: %w", mimeType, err)
+			logger.ErrorCtx(ctx, err)
+			return nil, err
 		}
+		data, yandexMimeType = converted, yandexMimeTypes[filetype.PNG.MIME]
 	}
 
 	reqBody := yandexOCRRequest{
-		MimeType:      mimeType,
+		MimeType:      yandexMimeType,
 		LanguageCodes: []string{"ru", "en"},
-		Model:         r.model,
-		Content:       encodedImage,
+		Model:         ModelFrom(ctx, r.model),
+		Content:       base64.StdEncoding.EncodeToString(data),
 	}
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
 		err := fmt.Errorf("failed to marshal request: %w", err)
-		logger.Error(err)
-		return "", err
+		logger.ErrorCtx(ctx, err)
+		return nil, err
+	}
+	return jsonData, nil
+}
+
+// acquire ждёт разрешения лимитера запросов к Yandex.
+func (r *YandexOCRRepository) acquire(ctx context.Context) error {
+	waitCtx, waitSpan := tracing.Start(ctx, "YandexRateLimiter.Acquire")
+	err := r.rateLimiter.Acquire(waitCtx)
+	tracing.End(waitSpan, err)
+	if err != nil {
+		err := fmt.Errorf("rate limiter timeout: %w", err)
+		logger.ErrorCtx(ctx, err)
+		return err
+	}
+	return nil
+}
+
+// call выполняет запрос к API с авторизацией и возвращает тело ответа 200 OK.
+// body == nil — запрос без тела.
+func (r *YandexOCRRepository) call(ctx context.Context, method, url string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
 	}
 
-	req, err := http.NewRequest(
-		"POST",
-		yandexSyncOCREndpoint,
-		bytes.NewBuffer(jsonData),
-	)
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
 	if err != nil {
 		err := fmt.Errorf("failed to create request: %w", err)
-		logger.Error(err)
-		return "", err
+		logger.ErrorCtx(ctx, err)
+		return nil, err
+	}
+
+	token, err := r.bearerToken(ctx)
+	if err != nil {
+		err := fmt.Errorf("failed to get Yandex IAM token: %w", err)
+		logger.ErrorCtx(ctx, err)
+		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", r.apiKey))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	req.Header.Set("x-folder-id", r.folderID)
 	req.Header.Set("x-data-logging-enabled", "false")
 
 	resp, err := r.client.Do(req)
 	if err != nil {
-		err := fmt.Errorf("failed to send request: %w", err)
-		logger.Error(err)
-		return "", err
+		err := logger.Errorf("failed to send request: %w", err)
+		logger.ErrorCtx(ctx, err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		err := fmt.Errorf("failed to read response: %w", err)
-		logger.Error(err)
-		return "", err
+		logger.ErrorCtx(ctx, err)
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && r.iam != nil {
+		// Токен могли отозвать раньше срока — следующий запрос получит новый.
+		r.iam.Invalidate()
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		var apiError yandexError
-		if json.Unmarshal(body, &apiError) == nil && apiError.Message != "" {
-			err := fmt.Errorf("yandex API error (status %d): %s", resp.StatusCode, apiError.Message)
-			logger.Error(err)
-			return "", err
+		if json.Unmarshal(respBody, &apiError) == nil && apiError.Message != "" {
+			err := fmt.Errorf("yandex API error (status %d): %s", resp.StatusCode, logger.Redact(apiError.Message))
+			logger.ErrorCtx(ctx, err)
+			return nil, err
 		}
-		err := fmt.Errorf("yandex API returned status %d: %s", resp.StatusCode, string(body))
-		logger.Error(err)
-		return "", err
-	}
-
-	var ocrResp yandexOCRResponse
-	if err := json.Unmarshal(body, &ocrResp); err != nil {
-		err := fmt.Errorf("failed to unmarshal response: %w", err)
-		logger.Error(err)
-		return "", err
-	}
-
-	if ocrResp.Result == nil || ocrResp.Result.TextAnnotation == nil {
-		logger.Warn("empty result from Yandex OCR API")
-		return "", nil
+		err := fmt.Errorf("yandex API returned status %d: %s", resp.StatusCode, logger.RedactBody(respBody))
+		logger.ErrorCtx(ctx, err)
+		return nil, err
 	}
 
-	return string(body), nil
+	return respBody, nil
 }
@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/tracing"
+	"github.com/airsss993/ocr-history/pkg/logger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// yandexOperation — длительная операция Yandex Cloud (recognizeTextAsync).
+type yandexOperation struct {
+	ID    string       `json:"id"`
+	Done  bool         `json:"done"`
+	Error *yandexError `json:"error,omitempty"`
+}
+
+// recognizeAsync запускает операцию recognizeTextAsync, опрашивает её до
+// завершения и забирает результат getRecognition — по ответу на страницу.
+// Лимитер запросов учитывает запуск операции и получение результата;
+// опрос идёт к API операций с интервалом async.PollInterval. Пока операция
+// идёт, её ID известен подписчику WithOperationHook.
+func (r *YandexOCRRepository) recognizeAsync(ctx context.Context, body []byte) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.async.Timeout)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "YandexOCR.recognizeAsync")
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	if err = r.acquire(ctx); err != nil {
+		return nil, err
+	}
+
+	respBody, err := r.call(ctx, "POST", r.endpoints.Async, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var op yandexOperation
+	if err = json.Unmarshal(respBody, &op); err != nil || op.ID == "" {
+		err = fmt.Errorf("invalid operation in recognizeTextAsync response: %s", logger.RedactBody(respBody))
+		logger.ErrorCtx(ctx, err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.String("yandex.operation_id", op.ID))
+	defer trackOperation(ctx, op.ID)()
+
+	var pages []string
+	pages, err = r.operationResult(ctx, op)
+	return pages, err
+}
+
+// ResumeOperation дожидается операции recognizeTextAsync, запущенной
+// до перезапуска сервиса, и забирает её результат.
+func (r *YandexOCRRepository) ResumeOperation(ctx context.Context, id string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.async.Timeout)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "YandexOCR.ResumeOperation",
+		attribute.String("yandex.operation_id", id),
+	)
+	pages, err := r.operationResult(ctx, yandexOperation{ID: id})
+	tracing.End(span, err)
+	return pages, err
+}
+
+// operationResult ждёт завершения операции и забирает её результат getRecognition.
+func (r *YandexOCRRepository) operationResult(ctx context.Context, op yandexOperation) ([]string, error) {
+	op, err := r.waitOperation(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	if op.Error != nil {
+		err := fmt.Errorf("yandex async operation %s failed (code %d): %s", op.ID, op.Error.Code, logger.Redact(op.Error.Message))
+		logger.ErrorCtx(ctx, err)
+		return nil, err
+	}
+
+	if err := r.acquire(ctx); err != nil {
+		return nil, err
+	}
+
+	respBody, err := r.call(ctx, "GET", r.endpoints.Recognition+"?operationId="+url.QueryEscape(op.ID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	pages, err := yandexRecognitionPages(respBody)
+	if err != nil {
+		logger.ErrorCtx(ctx, err)
+		return nil, err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("ocr.pages", len(pages)))
+	return pages, nil
+}
+
+// waitOperation опрашивает операцию, пока она не завершится или не истечёт ctx.
+// Опросы идут в ту же квоту запросов Yandex, что и распознавание, поэтому
+// каждый занимает токен лимитера: иначе долгие операции, опрашиваемые
+// параллельно, выбирали бы квоту мимо него.
+func (r *YandexOCRRepository) waitOperation(ctx context.Context, op yandexOperation) (yandexOperation, error) {
+	ticker := time.NewTicker(r.async.PollInterval)
+	defer ticker.Stop()
+
+	for !op.Done {
+		select {
+		case <-ctx.Done():
+			err := fmt.Errorf("yandex async operation %s did not finish: %w", op.ID, ctx.Err())
+			logger.ErrorCtx(ctx, err)
+			return op, err
+		case <-ticker.C:
+		}
+
+		if err := r.acquire(ctx); err != nil {
+			return op, err
+		}
+		respBody, err := r.call(ctx, "GET", strings.TrimSuffix(r.endpoints.Operations, "/")+"/"+url.PathEscape(op.ID), nil)
+		if err != nil {
+			return op, err
+		}
+		id := op.ID
+		if err := json.Unmarshal(respBody, &op); err != nil {
+			err := fmt.Errorf("failed to unmarshal operation: %w", err)
+			logger.ErrorCtx(ctx, err)
+			return op, err
+		}
+		if op.ID == "" {
+			op.ID = id
+		}
+	}
+	return op, nil
+}
+
+// yandexRecognitionPages разбирает ответ getRecognition: поток JSON-объектов
+// вида {"result": {...}}, по одному на страницу. Объекты упорядочиваются
+// по result.page, если он указан.
+func yandexRecognitionPages(body []byte) ([]string, error) {
+	type page struct {
+		index int
+		raw   json.RawMessage
+	}
+	var pages []page
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to unmarshal recognition result: %w", err)
+		}
+
+		var header struct {
+			Result *struct {
+				Page json.RawMessage `json:"page"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(raw, &header); err != nil || header.Result == nil {
+			return nil, fmt.Errorf("recognition result has no result field")
+		}
+		index := len(pages)
+		if p, err := strconv.Atoi(strings.Trim(string(header.Result.Page), `"`)); err == nil {
+			index = p
+		}
+		pages = append(pages, page{index: index, raw: raw})
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("recognition result is empty")
+	}
+
+	sort.SliceStable(pages, func(i, j int) bool { return pages[i].index < pages[j].index })
+	texts := make([]string, len(pages))
+	for i, p := range pages {
+		texts[i] = string(p.raw)
+	}
+	return texts, nil
+}
@@ -0,0 +1,312 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/ratelimiter"
+)
+
+// fakeYandexAsync — заглушка recognizeTextAsync, API операций и getRecognition.
+// Операция завершается после pollsUntilDone опросов.
+type fakeYandexAsync struct {
+	t              *testing.T
+	server         *httptest.Server
+	pollsUntilDone int32
+	opError        string // не пусто — операция завершается ошибкой
+	recognition    string
+
+	mu       sync.Mutex
+	requests []string
+	started  []yandexOCRRequest
+	polls    atomic.Int32
+}
+
+func newFakeYandexAsync(t *testing.T) *fakeYandexAsync {
+	f := &fakeYandexAsync{t: t, pollsUntilDone: 2}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeYandexAsync) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.RequestURI())
+	f.mu.Unlock()
+
+	if auth := r.Header.Get("Authorization"); auth != "Bearer test-key" {
+		f.t.Errorf("%s: Authorization = %q", r.URL.Path, auth)
+	}
+	if folder := r.Header.Get("x-folder-id"); folder != "folder" {
+		f.t.Errorf("%s: x-folder-id = %q", r.URL.Path, folder)
+	}
+
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/ocr/v1/recognizeTextAsync":
+		var req yandexOCRRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			f.t.Errorf("recognizeTextAsync body is not JSON: %v", err)
+		}
+		f.mu.Lock()
+		f.started = append(f.started, req)
+		f.mu.Unlock()
+		fmt.Fprint(w, `{"id":"op-1","done":false}`)
+
+	case r.Method == "GET" && r.URL.Path == "/operations/op-1":
+		done := f.polls.Add(1) >= f.pollsUntilDone
+		switch {
+		case !done:
+			fmt.Fprint(w, `{"id":"op-1","done":false}`)
+		case f.opError != "":
+			fmt.Fprintf(w, `{"id":"op-1","done":true,"error":{"code":3,"message":%q}}`, f.opError)
+		default:
+			fmt.Fprint(w, `{"done":true}`)
+		}
+
+	case r.Method == "GET" && r.URL.Path == "/ocr/v1/getRecognition":
+		if id := r.URL.Query().Get("operationId"); id != "op-1" {
+			f.t.Errorf("getRecognition operationId = %q, want op-1", id)
+		}
+		if f.polls.Load() < f.pollsUntilDone {
+			f.t.Error("getRecognition called before the operation is done")
+		}
+		fmt.Fprint(w, f.recognition)
+
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeYandexAsync) repository(t *testing.T, minSize int64) *YandexOCRRepository {
+	return f.repositoryWithRate(t, minSize, 100)
+}
+
+func (f *fakeYandexAsync) repositoryWithRate(t *testing.T, minSize int64, requestsPerSecond int) *YandexOCRRepository {
+	limiter := ratelimiter.NewYandexRateLimiter(requestsPerSecond)
+	t.Cleanup(limiter.Stop)
+
+	return NewYandexOCRRepository("test-key", nil, "folder", "page",
+		YandexEndpoints{
+			Sync:        f.server.URL + "/ocr/v1/recognizeText",
+			Async:       f.server.URL + "/ocr/v1/recognizeTextAsync",
+			Operations:  f.server.URL + "/operations/",
+			Recognition: f.server.URL + "/ocr/v1/getRecognition",
+		},
+		YandexAsyncOptions{
+			Enabled:      true,
+			MinSize:      minSize,
+			PollInterval: 5 * time.Millisecond,
+			Timeout:      5 * time.Second,
+		},
+		limiter)
+}
+
+func recognitionPage(page, text string) string {
+	return fmt.Sprintf(`{"result":{"textAnnotation":{"fullText":%q},"page":%q}}`, text, page)
+}
+
+func TestYandexRecognizePagesAsync(t *testing.T) {
+	fake := newFakeYandexAsync(t)
+	fake.recognition = recognitionPage("1", "second") + "\n" + recognitionPage("0", "first") + "\n"
+	repo := fake.repository(t, 0)
+
+	var (
+		started  []string
+		finished atomic.Bool
+	)
+	ctx := WithOperationHook(WithModel(context.Background(), "handwritten"), func(id string) func() {
+		started = append(started, id)
+		return func() { finished.Store(true) }
+	})
+
+	pdf := []byte("%PDF-1.4 test")
+	pages, err := repo.RecognizePages(ctx, pdf, "application/pdf")
+	if err != nil {
+		t.Fatalf("RecognizePages: %v", err)
+	}
+
+	if len(pages) != 2 || !strings.Contains(pages[0], `"first"`) || !strings.Contains(pages[1], `"second"`) {
+		t.Errorf("pages = %q, want first then second", pages)
+	}
+	if n := fake.polls.Load(); n != fake.pollsUntilDone {
+		t.Errorf("operation polled %d times, want %d", n, fake.pollsUntilDone)
+	}
+	want := []string{
+		"POST /ocr/v1/recognizeTextAsync",
+		"GET /operations/op-1",
+		"GET /operations/op-1",
+		"GET /ocr/v1/getRecognition?operationId=op-1",
+	}
+	if fmt.Sprint(fake.requests) != fmt.Sprint(want) {
+		t.Errorf("requests = %v, want %v", fake.requests, want)
+	}
+
+	if len(fake.started) != 1 {
+		t.Fatalf("got %d recognizeTextAsync calls, want 1", len(fake.started))
+	}
+	req := fake.started[0]
+	if req.MimeType != "PDF" || req.Model != "handwritten" || req.Content != base64.StdEncoding.EncodeToString(pdf) {
+		t.Errorf("recognizeTextAsync request = %+v", req)
+	}
+
+	if len(started) != 1 || started[0] != "op-1" || !finished.Load() {
+		t.Errorf("operation hook saw %v, finished = %v; want op-1 reported and finished", started, finished.Load())
+	}
+}
+
+func TestYandexRecognizeLargeImageAsync(t *testing.T) {
+	fake := newFakeYandexAsync(t)
+	fake.pollsUntilDone = 1
+	fake.recognition = recognitionPage("0", "image")
+	repo := fake.repository(t, 4)
+
+	text, err := repo.RecognizeFromBytes(context.Background(), []byte("large image"), "image/jpeg")
+	if err != nil {
+		t.Fatalf("RecognizeFromBytes: %v", err)
+	}
+	if !strings.Contains(text, `"image"`) {
+		t.Errorf("text = %q, want the recognition of the only page", text)
+	}
+	if fake.started[0].MimeType != "JPEG" {
+		t.Errorf("mimeType = %q, want JPEG", fake.started[0].MimeType)
+	}
+}
+
+func TestYandexRecognizePagesSyncFallback(t *testing.T) {
+	fake := newFakeYandexAsync(t)
+	repo := fake.repository(t, 1<<20)
+
+	if _, err := repo.RecognizePages(context.Background(), []byte("small image"), "image/jpeg"); !errors.Is(err, ErrPagesUnsupported) {
+		t.Errorf("RecognizePages for a small image = %v, want ErrPagesUnsupported", err)
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("small image reached the async API: %v", fake.requests)
+	}
+}
+
+func TestYandexAsyncOperationError(t *testing.T) {
+	fake := newFakeYandexAsync(t)
+	fake.opError = "unsupported document"
+	repo := fake.repository(t, 0)
+
+	_, err := repo.RecognizePages(context.Background(), []byte("%PDF-1.4"), "application/pdf")
+	if err == nil || !strings.Contains(err.Error(), "op-1") || !strings.Contains(err.Error(), "unsupported document") {
+		t.Errorf("err = %v, want the operation error", err)
+	}
+	for _, req := range fake.requests {
+		if strings.Contains(req, "getRecognition") {
+			t.Error("getRecognition called for a failed operation")
+		}
+	}
+}
+
+// TestYandexPollsUseLimiter: опрос операции ждёт токен лимитера, как и
+// остальные запросы к Yandex.
+func TestYandexPollsUseLimiter(t *testing.T) {
+	fake := newFakeYandexAsync(t)
+	repo := fake.repositoryWithRate(t, 0, 1) // единственный токен уходит на запуск операции
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err := repo.RecognizePages(ctx, []byte("%PDF-1.4"), "application/pdf")
+	if err == nil || !strings.Contains(err.Error(), "rate limiter") {
+		t.Errorf("err = %v, want the poll to wait for the limiter", err)
+	}
+	if n := fake.polls.Load(); n != 0 {
+		t.Errorf("operation polled %d times without a limiter token", n)
+	}
+}
+
+func TestYandexResumeOperation(t *testing.T) {
+	fake := newFakeYandexAsync(t)
+	fake.recognition = recognitionPage("0", "resumed")
+	repo := fake.repository(t, 0)
+
+	pages, err := repo.ResumeOperation(context.Background(), "op-1")
+	if err != nil {
+		t.Fatalf("ResumeOperation: %v", err)
+	}
+	if len(pages) != 1 || !strings.Contains(pages[0], `"resumed"`) {
+		t.Errorf("pages = %q, want the resumed result", pages)
+	}
+	if len(fake.started) != 0 {
+		t.Error("ResumeOperation started a new operation")
+	}
+}
+
+func TestYandexRecognitionPages(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []string // fullText страниц по порядку
+		wantErr bool
+	}{
+		{
+			name: "ordered by page",
+			body: recognitionPage("2", "c") + recognitionPage("0", "a") + "\n" + recognitionPage("1", "b"),
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "numeric page",
+			body: `{"result":{"textAnnotation":{"fullText":"b"},"page":1}} {"result":{"textAnnotation":{"fullText":"a"},"page":0}}`,
+			want: []string{"a", "b"},
+		},
+		{
+			name: "page beyond ten",
+			body: recognitionPage("10", "k") + recognitionPage("9", "j"),
+			want: []string{"j", "k"},
+		},
+		{
+			name: "no page keeps stream order",
+			body: `{"result":{"textAnnotation":{"fullText":"a"}}}{"result":{"textAnnotation":{"fullText":"b"}}}`,
+			want: []string{"a", "b"},
+		},
+		{name: "empty", body: "", wantErr: true},
+		{name: "no result", body: `{"error":"x"}`, wantErr: true},
+		{name: "broken stream", body: recognitionPage("0", "a") + `{"result":`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages, err := yandexRecognitionPages([]byte(tt.body))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %d pages, want an error", len(pages))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("yandexRecognitionPages: %v", err)
+			}
+
+			got := make([]string, len(pages))
+			for i, page := range pages {
+				var resp struct {
+					Result struct {
+						TextAnnotation struct {
+							FullText string `json:"fullText"`
+						} `json:"textAnnotation"`
+					} `json:"result"`
+				}
+				if err := json.Unmarshal([]byte(page), &resp); err != nil {
+					t.Fatalf("page %d is not a recognition response: %s", i, page)
+				}
+				got[i] = resp.Result.TextAnnotation.FullText
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("pages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
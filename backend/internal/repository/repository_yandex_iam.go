@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/tracing"
+	"github.com/airsss993/ocr-history/pkg/logger"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// YandexIAMTokenEndpoint — адрес обмена JWT на IAM-токен по умолчанию.
+	YandexIAMTokenEndpoint = "https://iam.api.cloud.yandex.net/iam/v1/tokens"
+
+	// yandexIAMRefreshAfter: IAM-токен живёт до 12 часов, Yandex Cloud
+	// советует получать новый не реже раза в час.
+	yandexIAMRefreshAfter = time.Hour
+	// yandexIAMExpiryMargin — запас до истечения, после которого токен не используется.
+	yandexIAMExpiryMargin = 5 * time.Minute
+)
+
+// yandexAuthorizedKey — авторизованный ключ сервисного аккаунта
+// (yc iam key create --output key.json).
+type yandexAuthorizedKey struct {
+	ID               string `json:"id"`
+	ServiceAccountID string `json:"service_account_id"`
+	PrivateKey       string `json:"private_key"`
+}
+
+// YandexIAMTokenSource выдаёт IAM-токены по авторизованному ключу сервисного
+// аккаунта: подписывает JWT (PS256), обменивает его на токен и кеширует токен
+// до планового обновления. Ключ читается при первом обращении. Одновременно
+// идёт не больше одного обмена, и он не держит блокировку кеша.
+type YandexIAMTokenSource struct {
+	keyFile  string
+	tokenURL string
+	client   *http.Client
+	refresh  singleflight.Group
+
+	// Ключ читается и используется только внутри refresh.
+	key       *rsa.PrivateKey
+	keyID     string
+	accountID string
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	refreshAt time.Time
+}
+
+// NewYandexIAMTokenSource создаёт источник токенов; пустой tokenURL —
+// YandexIAMTokenEndpoint (другой адрес нужен для локальной заглушки IAM).
+func NewYandexIAMTokenSource(keyFile, tokenURL string) *YandexIAMTokenSource {
+	if tokenURL == "" {
+		tokenURL = YandexIAMTokenEndpoint
+	}
+	return &YandexIAMTokenSource{
+		keyFile:  keyFile,
+		tokenURL: tokenURL,
+		client:   &http.Client{Timeout: 30 * time.Second, Transport: tracing.Transport(nil)},
+	}
+}
+
+// Token возвращает действующий IAM-токен, при необходимости получая новый.
+// Пока старый токен не истёк, плановое обновление идёт в фоне, а вызывающий
+// сразу получает старый токен; без действующего токена ждёт обмена.
+func (s *YandexIAMTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	token, now := s.token, time.Now()
+	fresh := token != "" && now.Before(s.refreshAt)
+	valid := token != "" && now.Before(s.expiresAt)
+	s.mu.Unlock()
+
+	if fresh {
+		return token, nil
+	}
+
+	// Обмен общий для всех ожидающих, поэтому не отменяется вместе с ctx
+	// одного из них; его ограничивает таймаут s.client.
+	result := s.refresh.DoChan("token", func() (interface{}, error) {
+		return s.update(context.WithoutCancel(ctx))
+	})
+	if valid {
+		return token, nil
+	}
+
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return res.Val.(string), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// update получает новый токен и кладёт его в кеш. Если обмен не удался,
+// кешированный токен остаётся в силе до своего истечения.
+func (s *YandexIAMTokenSource) update(ctx context.Context) (string, error) {
+	token, expiresAt, err := s.exchange(ctx)
+	if err != nil {
+		logger.WarnCtx(ctx, fmt.Sprintf("failed to refresh Yandex IAM token: %v", err))
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.token = token
+	s.expiresAt = expiresAt.Add(-yandexIAMExpiryMargin)
+	s.refreshAt = time.Now().Add(yandexIAMRefreshAfter)
+	if s.refreshAt.After(s.expiresAt) {
+		s.refreshAt = s.expiresAt
+	}
+	return token, nil
+}
+
+// Invalidate сбрасывает кешированный токен, например после ответа 401.
+func (s *YandexIAMTokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.token = ""
+	s.expiresAt, s.refreshAt = time.Time{}, time.Time{}
+}
+
+// Ping проверяет, что по ключу выдаётся IAM-токен.
+func (s *YandexIAMTokenSource) Ping(ctx context.Context) error {
+	_, err := s.Token(ctx)
+	return err
+}
+
+func (s *YandexIAMTokenSource) exchange(ctx context.Context) (string, time.Time, error) {
+	if err := s.loadKey(); err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": s.accountID,
+		"aud": s.tokenURL,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodPS256, claims)
+	token.Header["kid"] = s.keyID
+	signedToken, err := token.SignedString(s.key)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign JWT: %w", err)
+	}
+
+	reqBody, err := json.Marshal(map[string]string{"jwt": signedToken})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to marshal token request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.tokenURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", time.Time{}, logger.Errorf("failed to exchange JWT for IAM token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("IAM token exchange failed (status %d): %s", resp.StatusCode, logger.RedactBody(body))
+	}
+
+	var tokenResp struct {
+		IAMToken  string    `json:"iamToken"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if tokenResp.IAMToken == "" {
+		return "", time.Time{}, fmt.Errorf("token response contains no iamToken")
+	}
+	if tokenResp.ExpiresAt.IsZero() {
+		tokenResp.ExpiresAt = now.Add(12 * time.Hour)
+	}
+
+	return tokenResp.IAMToken, tokenResp.ExpiresAt, nil
+}
+
+// loadKey читает и разбирает файл ключа; вызывается только внутри s.refresh.
+func (s *YandexIAMTokenSource) loadKey() error {
+	if s.key != nil {
+		return nil
+	}
+
+	keyData, err := os.ReadFile(s.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read Yandex key file: %w", err)
+	}
+
+	var authorizedKey yandexAuthorizedKey
+	if err := json.Unmarshal(keyData, &authorizedKey); err != nil {
+		return fmt.Errorf("failed to parse Yandex key file: %w", err)
+	}
+	if authorizedKey.ID == "" || authorizedKey.ServiceAccountID == "" {
+		return fmt.Errorf("yandex key file must contain id and service_account_id")
+	}
+
+	// Перед PEM в private_key идёт строка-предупреждение, pem.Decode её пропускает.
+	block, _ := pem.Decode([]byte(authorizedKey.PrivateKey))
+	if block == nil {
+		return fmt.Errorf("failed to decode private key")
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	rsaKey, ok := privateKey.(*rsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("private key is not RSA key")
+	}
+
+	s.key, s.keyID, s.accountID = rsaKey, authorizedKey.ID, authorizedKey.ServiceAccountID
+	return nil
+}
@@ -0,0 +1,272 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/ratelimiter"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testKeyID     = "ajeTestKeyID"
+	testAccountID = "ajeTestServiceAccount"
+)
+
+// fakeIAM — заглушка обмена JWT на IAM-токен: проверяет подпись и claims
+// и выдаёт токены t1.token-1, t1.token-2, ...
+type fakeIAM struct {
+	t      *testing.T
+	server *httptest.Server
+	public *rsa.PublicKey
+
+	exchanges atomic.Int32
+	ttl       time.Duration
+	release   chan struct{} // не nil — обмен ждёт, пока канал не закроют
+}
+
+func newFakeIAM(t *testing.T) (*fakeIAM, string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile, err := json.Marshal(yandexAuthorizedKey{
+		ID:               testKeyID,
+		ServiceAccountID: testAccountID,
+		PrivateKey: "PLEASE DO NOT REMOVE THIS LINE! Yandex.Cloud SA Key ID <" + testKeyID + ">\n" +
+			string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(path, keyFile, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeIAM{t: t, public: &key.PublicKey, ttl: 12 * time.Hour}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f, path
+}
+
+func (f *fakeIAM) serve(w http.ResponseWriter, r *http.Request) {
+	if f.release != nil {
+		<-f.release
+	}
+
+	var req struct {
+		JWT string `json:"jwt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		f.t.Errorf("token request is not JSON: %v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(req.JWT, claims, func(token *jwt.Token) (interface{}, error) {
+		return f.public, nil
+	}, jwt.WithValidMethods([]string{"PS256"}), jwt.WithIssuedAt())
+	if err != nil {
+		f.t.Errorf("JWT does not verify: %v", err)
+		http.Error(w, "invalid jwt", http.StatusUnauthorized)
+		return
+	}
+	if kid := token.Header["kid"]; kid != testKeyID {
+		f.t.Errorf("kid = %v, want %s", kid, testKeyID)
+	}
+	if iss, _ := claims.GetIssuer(); iss != testAccountID {
+		f.t.Errorf("iss = %q, want %s", iss, testAccountID)
+	}
+	if aud, _ := claims.GetAudience(); len(aud) != 1 || aud[0] != f.server.URL {
+		f.t.Errorf("aud = %v, want %s", aud, f.server.URL)
+	}
+	iat, _ := claims.GetIssuedAt()
+	exp, _ := claims.GetExpirationTime()
+	if iat == nil || exp == nil || exp.Sub(iat.Time) != time.Hour {
+		f.t.Errorf("iat = %v, exp = %v, want a one hour JWT", iat, exp)
+	}
+
+	n := f.exchanges.Add(1)
+	fmt.Fprintf(w, `{"iamToken":"t1.token-%d","expiresAt":%q}`, n, time.Now().Add(f.ttl).Format(time.RFC3339Nano))
+}
+
+func TestYandexIAMTokenCaching(t *testing.T) {
+	iam, keyFile := newFakeIAM(t)
+	source := NewYandexIAMTokenSource(keyFile, iam.server.URL)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		token, err := source.Token(ctx)
+		if err != nil {
+			t.Fatalf("Token: %v", err)
+		}
+		if token != "t1.token-1" {
+			t.Errorf("Token = %q, want the cached t1.token-1", token)
+		}
+	}
+	if n := iam.exchanges.Load(); n != 1 {
+		t.Errorf("got %d exchanges, want 1", n)
+	}
+
+	source.mu.Lock()
+	expiresAt, refreshAt := source.expiresAt, source.refreshAt
+	source.mu.Unlock()
+	if d := time.Until(refreshAt); d > yandexIAMRefreshAfter || d < yandexIAMRefreshAfter-time.Minute {
+		t.Errorf("refresh in %s, want about %s", d, yandexIAMRefreshAfter)
+	}
+	if d := time.Until(expiresAt); d > 12*time.Hour-yandexIAMExpiryMargin {
+		t.Errorf("token is used until %s before expiry, want at least %s margin", 12*time.Hour-d, yandexIAMExpiryMargin)
+	}
+}
+
+func TestYandexIAMTokenRefresh(t *testing.T) {
+	iam, keyFile := newFakeIAM(t)
+	source := NewYandexIAMTokenSource(keyFile, iam.server.URL)
+	ctx := context.Background()
+
+	if _, err := source.Token(ctx); err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+
+	// Плановое обновление: старый токен ещё действует и отдаётся сразу,
+	// новый приходит в фоне.
+	iam.release = make(chan struct{})
+	source.mu.Lock()
+	source.refreshAt = time.Now().Add(-time.Second)
+	source.mu.Unlock()
+
+	token, err := source.Token(ctx)
+	if err != nil || token != "t1.token-1" {
+		t.Fatalf("Token during refresh = %q, %v, want the current t1.token-1", token, err)
+	}
+	close(iam.release)
+	waitFor(t, func() bool {
+		token, _ := source.Token(ctx)
+		return token == "t1.token-2"
+	})
+
+	// Истёкший токен не используется: вызывающий ждёт обмена.
+	iam.release = nil
+	source.mu.Lock()
+	source.expiresAt = time.Now().Add(-time.Second)
+	source.refreshAt = source.expiresAt
+	source.mu.Unlock()
+
+	token, err = source.Token(ctx)
+	if err != nil || token != "t1.token-3" {
+		t.Errorf("Token after expiry = %q, %v, want t1.token-3", token, err)
+	}
+}
+
+// TestYandexIAMTokenSingleRefresh: медленный обмен не блокирует кеш,
+// а ожидающие токена вызывающие делят один обмен.
+func TestYandexIAMTokenSingleRefresh(t *testing.T) {
+	iam, keyFile := newFakeIAM(t)
+	iam.release = make(chan struct{})
+	source := NewYandexIAMTokenSource(keyFile, iam.server.URL)
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 5)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], _ = source.Token(context.Background())
+		}(i)
+	}
+
+	// Пока обмен висит, отменённый вызывающий не ждёт его.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := source.Token(ctx); err == nil {
+		t.Error("Token returned without a token and without an error")
+	}
+	source.Invalidate() // не должен ждать обмена
+
+	close(iam.release)
+	wg.Wait()
+
+	for i, token := range tokens {
+		if token != "t1.token-1" {
+			t.Errorf("caller %d got %q, want t1.token-1", i, token)
+		}
+	}
+	if n := iam.exchanges.Load(); n != 1 {
+		t.Errorf("got %d exchanges, want 1", n)
+	}
+}
+
+func TestYandexIAMInvalidateOn401(t *testing.T) {
+	iam, keyFile := newFakeIAM(t)
+	source := NewYandexIAMTokenSource(keyFile, iam.server.URL)
+
+	var authorizations []string
+	var mu sync.Mutex
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		mu.Lock()
+		authorizations = append(authorizations, auth)
+		mu.Unlock()
+
+		if auth == "Bearer t1.token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"code":16,"message":"The token is invalid"}`)
+			return
+		}
+		fmt.Fprint(w, `{"result":{"textAnnotation":{"fullText":"ok"}}}`)
+	}))
+	defer api.Close()
+
+	limiter := ratelimiter.NewYandexRateLimiter(100)
+	defer limiter.Stop()
+	repo := NewYandexOCRRepository("", source, "folder", "page",
+		YandexEndpoints{Sync: api.URL}, YandexAsyncOptions{}, limiter)
+
+	ctx := context.Background()
+	if _, err := repo.RecognizeFromBytes(ctx, []byte("image"), "image/jpeg"); err == nil {
+		t.Fatal("request with a revoked token succeeded")
+	}
+	if _, err := repo.RecognizeFromBytes(ctx, []byte("image"), "image/jpeg"); err != nil {
+		t.Fatalf("request after 401 did not get a new token: %v", err)
+	}
+
+	want := []string{"Bearer t1.token-1", "Bearer t1.token-2"}
+	if fmt.Sprint(authorizations) != fmt.Sprint(want) {
+		t.Errorf("Authorization headers = %v, want %v", authorizations, want)
+	}
+	if n := iam.exchanges.Load(); n != 2 {
+		t.Errorf("got %d exchanges, want 2", n)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition was not met in 5s")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,45 +1,203 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/airsss993/ocr-history/internal/domain"
+	"github.com/airsss993/ocr-history/internal/filetype"
+	"github.com/airsss993/ocr-history/internal/layout"
+	"github.com/airsss993/ocr-history/internal/multipage"
+	"github.com/airsss993/ocr-history/internal/pdf"
+	"github.com/airsss993/ocr-history/internal/preprocess"
+	"github.com/airsss993/ocr-history/internal/quota"
 	"github.com/airsss993/ocr-history/internal/repository"
+	"github.com/airsss993/ocr-history/internal/tracing"
+	"github.com/airsss993/ocr-history/pkg/logger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type OCRService struct {
+	provider    string
+	repoMu      sync.RWMutex
 	repo        repository.OCRRepository
+	quota       *quota.Manager
 	workerSlots chan struct{}
+
+	jobsMu   sync.RWMutex
+	jobs     map[string]*job
+	inflight sync.WaitGroup
+	closed   bool // после Drain новые пакеты не принимаются
+}
+
+// ErrShuttingDown возвращается ProcessImages после начала остановки сервиса.
+var ErrShuttingDown = errors.New("service is shutting down")
+
+// JobInfo — состояние пакета изображений, который сейчас обрабатывается.
+type JobInfo struct {
+	ID           string           `json:"id"`
+	Provider     string           `json:"provider"`
+	Owner        string           `json:"owner"`
+	HistoryOwner string           `json:"history_owner,omitempty"` // чья история получит результаты, досчитанные после перезапуска
+	ResumedFrom  string           `json:"resumed_from,omitempty"`  // пакет, прерванный прошлой остановкой, который досчитывается
+	TotalImages  int              `json:"total_images"`
+	Processed    int64            `json:"processed"`
+	StartedAt    time.Time        `json:"started_at"`
+	Operations   []AsyncOperation `json:"operations,omitempty"` // идущие асинхронные операции провайдера
+	Pending      []string         `json:"pending,omitempty"`    // файлы, до которых не дошла очередь; только в Unfinished
+}
+
+// AsyncOperation — асинхронная операция провайдера, запущенная для файла
+// или одной его страницы.
+type AsyncOperation struct {
+	ID        string    `json:"id"`
+	File      string    `json:"file"`
+	Page      int       `json:"page,omitempty"` // 0 — файл целиком
+	StartedAt time.Time `json:"started_at"`
+}
+
+var jobSeq atomic.Uint64
+
+type job struct {
+	info      JobInfo
+	processed atomic.Int64
+
+	mu         sync.Mutex
+	pending    map[int]string
+	operations map[string]AsyncOperation
 }
 
-func NewOCRService(repo repository.OCRRepository, maxWorkers int) *OCRService {
+// NewOCRService создаёт сервис провайдера; quota может быть nil.
+func NewOCRService(provider string, repo repository.OCRRepository, maxWorkers int, quota *quota.Manager) *OCRService {
 	return &OCRService{
+		provider:    provider,
 		repo:        repo,
+		quota:       quota,
 		workerSlots: make(chan struct{}, maxWorkers),
+		jobs:        make(map[string]*job),
+	}
+}
+
+func (s *OCRService) Provider() string {
+	return s.provider
+}
+
+// SetRepository подменяет клиент провайдера при перезагрузке конфигурации.
+// Уже запущенные пакеты дорабатывают со старым клиентом.
+func (s *OCRService) SetRepository(repo repository.OCRRepository) {
+	s.repoMu.Lock()
+	s.repo = repo
+	s.repoMu.Unlock()
+}
+
+func (s *OCRService) repository() repository.OCRRepository {
+	s.repoMu.RLock()
+	defer s.repoMu.RUnlock()
+	return s.repo
+}
+
+// WorkerSlots возвращает число занятых и всего рабочих слотов.
+func (s *OCRService) WorkerSlots() (inUse, capacity int) {
+	return len(s.workerSlots), cap(s.workerSlots)
+}
+
+// Jobs возвращает пакеты, которые сейчас обрабатываются.
+func (s *OCRService) Jobs() []JobInfo {
+	s.jobsMu.RLock()
+	defer s.jobsMu.RUnlock()
+
+	result := make([]JobInfo, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		result = append(result, j.snapshot(false))
 	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+	return result
+}
+
+// ProcessOptions — параметры обработки пакета, снятые с конфигурации на момент запроса.
+type ProcessOptions struct {
+	MaxSizeMB        int
+	SupportedFormats []string
+	MaxPages         int    // предел страниц в одном PDF или TIFF
+	KeepImages       bool   // сохранить в результатах изображения для выгрузки
+	Model            string // модель провайдера для этого пакета; пусто — из конфигурации
+	HistoryOwner     string // чья история получит результаты, досчитанные после перезапуска
+	Preprocess       preprocess.Options
 }
 
-func (s *OCRService) ProcessImages(files []*multipart.FileHeader, maxSizeMB int, supportedFormats []string) (*domain.OCRResponse, error) {
+// ProcessImages распознаёт пакет изображений; owner — на кого списывается квота.
+func (s *OCRService) ProcessImages(ctx context.Context, owner string, files []*multipart.FileHeader, opts ProcessOptions) (*domain.OCRResponse, error) {
+	ctx, span := tracing.Start(ctx, "OCRService.ProcessImages",
+		attribute.String("ocr.provider", s.provider),
+		attribute.Int("ocr.images", len(files)),
+	)
+	defer span.End()
+
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	results := make([]domain.OCRResult, len(files))
 
+	repo := s.repository()
+	if s.quota != nil {
+		repo = quota.NewGuardedRepository(repo, s.quota, owner, s.provider)
+	}
+
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Filename
+	}
+	j := s.newJob(owner, names)
+	j.info.HistoryOwner = opts.HistoryOwner
+	if err := s.startJob(j); err != nil {
+		return nil, err
+	}
+	defer s.finishJob(j)
+
+	if opts.Model != "" {
+		ctx = repository.WithModel(ctx, opts.Model)
+		span.SetAttributes(attribute.String("ocr.model", opts.Model))
+	}
+
 	for i, file := range files {
 		wg.Add(1)
 		go func(idx int, f *multipart.FileHeader) {
 			defer wg.Done()
 
-			s.workerSlots <- struct{}{}
-			defer func() { <-s.workerSlots }()
+			ctx := logger.WithFields(ctx, "provider", s.provider, "filename", f.Filename)
+			ctx = trackOperations(withJobFile(ctx, j, f.Filename), 0)
+			ctx, span := tracing.Start(ctx, "OCRService.processImage",
+				attribute.String("ocr.filename", f.Filename),
+				attribute.Int64("ocr.size_bytes", f.Size),
+			)
+			defer span.End()
 
-			result := s.processImage(f, maxSizeMB, supportedFormats)
+			result := domain.OCRResult{Filename: f.Filename}
+			func() {
+				defer recoverPanic(ctx, &result.Error)
+				result = s.processFile(ctx, repo, f, opts)
+				addStructure(&result)
+			}()
+			result.Model = opts.Model
+			if result.Error != "" {
+				span.SetStatus(codes.Error, result.Error)
+			}
+			j.done(idx)
 
 			mu.Lock()
 			results[idx] = result
@@ -49,65 +207,569 @@ func (s *OCRService) ProcessImages(files []*multipart.FileHeader, maxSizeMB int,
 
 	wg.Wait()
 
-	successful, failed := 0, 0
+	successful, failed, pages := 0, 0, 0
 	for _, r := range results {
+		pages += max(len(r.Pages), 1)
 		if r.Error == "" {
 			successful++
 		} else {
 			failed++
 		}
 	}
+	span.SetAttributes(
+		attribute.Int("ocr.successful", successful),
+		attribute.Int("ocr.failed", failed),
+		attribute.Int("ocr.pages", pages),
+	)
 
 	return &domain.OCRResponse{
 		Results:     results,
 		TotalImages: len(files),
+		TotalPages:  pages,
 		Successful:  successful,
 		Failed:      failed,
 		ProcessedAt: time.Now(),
 	}, nil
 }
 
-func (s *OCRService) processImage(file *multipart.FileHeader, maxSizeMB int, supportedFormats []string) domain.OCRResult {
+func (s *OCRService) newJob(owner string, files []string) *job {
+	j := &job{
+		info: JobInfo{
+			ID:          s.provider + "-" + strconv.FormatUint(jobSeq.Add(1), 10),
+			Provider:    s.provider,
+			Owner:       owner,
+			TotalImages: len(files),
+			StartedAt:   time.Now(),
+		},
+		pending:    make(map[int]string, len(files)),
+		operations: make(map[string]AsyncOperation),
+	}
+	for i, f := range files {
+		j.pending[i] = f
+	}
+	return j
+}
+
+func (s *OCRService) startJob(j *job) error {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	if s.closed {
+		return ErrShuttingDown
+	}
+	s.jobs[j.info.ID] = j
+	s.inflight.Add(1)
+
+	return nil
+}
+
+func (s *OCRService) finishJob(j *job) {
+	s.jobsMu.Lock()
+	delete(s.jobs, j.info.ID)
+	s.jobsMu.Unlock()
+	s.inflight.Done()
+}
+
+func (j *job) done(idx int) {
+	j.processed.Add(1)
+	j.mu.Lock()
+	delete(j.pending, idx)
+	j.mu.Unlock()
+}
+
+// track запоминает асинхронную операцию до вызова возвращённой функции.
+func (j *job) track(op AsyncOperation) (done func()) {
+	j.mu.Lock()
+	j.operations[op.ID] = op
+	j.mu.Unlock()
+
+	return func() {
+		j.mu.Lock()
+		delete(j.operations, op.ID)
+		j.mu.Unlock()
+	}
+}
+
+// snapshot возвращает состояние пакета; withPending — вместе со списком
+// необработанных файлов.
+func (j *job) snapshot(withPending bool) JobInfo {
+	info := j.info
+	info.Processed = j.processed.Load()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, op := range j.operations {
+		info.Operations = append(info.Operations, op)
+	}
+	sort.Slice(info.Operations, func(a, b int) bool {
+		return info.Operations[a].StartedAt.Before(info.Operations[b].StartedAt)
+	})
+
+	if withPending {
+		idx := make([]int, 0, len(j.pending))
+		for i := range j.pending {
+			idx = append(idx, i)
+		}
+		sort.Ints(idx)
+		for _, i := range idx {
+			info.Pending = append(info.Pending, j.pending[i])
+		}
+	}
+	return info
+}
+
+type jobFileKey struct{}
+
+type jobFile struct {
+	job  *job
+	file string
+}
+
+// withJobFile отмечает в ctx пакет и файл, к которым относятся
+// асинхронные операции провайдера.
+func withJobFile(ctx context.Context, j *job, file string) context.Context {
+	return context.WithValue(ctx, jobFileKey{}, jobFile{job: j, file: file})
+}
+
+// trackOperations записывает асинхронные операции, запущенные в ctx,
+// в пакет из withJobFile; page — страница файла, 0 — файл целиком.
+func trackOperations(ctx context.Context, page int) context.Context {
+	scope, ok := ctx.Value(jobFileKey{}).(jobFile)
+	if !ok {
+		return ctx
+	}
+	return repository.WithOperationHook(ctx, func(id string) func() {
+		return scope.job.track(AsyncOperation{ID: id, File: scope.file, Page: page, StartedAt: time.Now()})
+	})
+}
+
+// Drain перестаёт принимать новые пакеты и ждёт завершения текущих
+// до отмены ctx. Возвращает ошибку ctx, если не все пакеты успели завершиться.
+func (s *OCRService) Drain(ctx context.Context) error {
+	s.jobsMu.Lock()
+	s.closed = true
+	s.jobsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Unfinished возвращает незавершённые пакеты со списком необработанных файлов.
+func (s *OCRService) Unfinished() []JobInfo {
+	s.jobsMu.RLock()
+	defer s.jobsMu.RUnlock()
+
+	result := make([]JobInfo, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		result = append(result, j.snapshot(true))
+	}
+	return result
+}
+
+// Resume досчитывает пакет, прерванный прошлой остановкой: дожидается его
+// асинхронных операций у провайдера и передаёт результат каждой в save.
+// Пока операции идут, пакет виден в Jobs, а при новой остановке снова
+// попадает в Unfinished. Файлы без операций заново не распознаются —
+// их данные не сохраняются между запусками.
+func (s *OCRService) Resume(ctx context.Context, interrupted JobInfo, save func(domain.OCRResult)) error {
+	resumer, ok := s.repository().(repository.OperationResumer)
+	if !ok {
+		return repository.ErrResumeUnsupported
+	}
+	if len(interrupted.Operations) == 0 {
+		return nil
+	}
+
+	files := make([]string, len(interrupted.Operations))
+	for i, op := range interrupted.Operations {
+		files[i] = op.File
+	}
+	j := s.newJob(interrupted.Owner, files)
+	j.info.HistoryOwner = interrupted.HistoryOwner
+	j.info.ResumedFrom = interrupted.ID
+
+	done := make([]func(), len(interrupted.Operations))
+	for i, op := range interrupted.Operations {
+		done[i] = j.track(op)
+	}
+	if err := s.startJob(j); err != nil {
+		return err
+	}
+	defer s.finishJob(j)
+
+	var wg sync.WaitGroup
+	for i, op := range interrupted.Operations {
+		wg.Add(1)
+		go func(idx int, op AsyncOperation) {
+			defer wg.Done()
+
+			ctx := logger.WithFields(ctx, "provider", s.provider, "filename", op.File, "operation_id", op.ID)
+			release := s.acquireWorker(ctx)
+			defer release()
+
+			result := domain.OCRResult{Filename: op.File}
+			func() {
+				defer recoverPanic(ctx, &result.Error)
+				texts, err := resumer.ResumeOperation(ctx, op.ID)
+				result = resumedResult(op, texts, err)
+			}()
+			save(result)
+			done[idx]()
+			j.done(idx)
+		}(i, op)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// resumedResult раскладывает ответ досчитанной операции так же, как
+// ProcessImages: страница файла, документ целиком или одно изображение.
+func resumedResult(op AsyncOperation, texts []string, err error) domain.OCRResult {
+	result := domain.OCRResult{Filename: op.File}
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+	case op.Page > 0 && len(texts) == 1:
+		result.Pages = []domain.PageResult{{Page: op.Page, Text: rawText(texts[0])}}
+	case op.Page == 0 && len(texts) == 1:
+		result.Text = rawText(texts[0])
+	default:
+		for i, text := range texts {
+			result.Pages = append(result.Pages, domain.PageResult{Page: i + 1, Text: rawText(text)})
+		}
+	}
+	addStructure(&result)
+	return result
+}
+
+// processFile распознаёт один загруженный файл; PDF и многостраничные TIFF
+// разбиваются на страницы, и каждая страница занимает свой рабочий слот.
+func (s *OCRService) processFile(ctx context.Context, repo repository.OCRRepository, file *multipart.FileHeader, opts ProcessOptions) domain.OCRResult {
 	result := domain.OCRResult{Filename: file.Filename}
 
-	if err := validateImageSize(file, maxSizeMB); err != nil {
+	data, format, err := readFile(file, opts)
+	if err != nil {
 		result.Error = err.Error()
 		return result
 	}
 
-	if err := validateImageFormat(file.Filename, supportedFormats); err != nil {
+	if !multipage.IsMultipage(format) {
+		release := s.acquireWorker(ctx)
+		defer release()
+
+		var prepared preprocess.Result
+		result.Text, prepared, err = s.recognize(ctx, repo, data, format, opts.Preprocess)
+		result.Preprocessing = prepared.Applied
+		if opts.KeepImages {
+			result.Image = sourceImage(prepared)
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		return result
+	}
+
+	pages, whole, err := s.splitPages(ctx, data, format, opts.MaxPages)
+	if err != nil {
 		result.Error = err.Error()
 		return result
 	}
 
+	if whole {
+		result.Pages = s.recognizeDocument(ctx, repo, data, format, opts)
+	} else {
+		result.Pages = s.recognizePages(ctx, repo, pages, opts)
+	}
+	failed := 0
+	for _, page := range result.Pages {
+		if page.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		result.Error = fmt.Sprintf("%d of %d pages failed", failed, len(result.Pages))
+	}
+	return result
+}
+
+// recoverPanic, вызванная через defer в рабочей горутине, превращает панику
+// разборщика файла или клиента провайдера в ошибку файла или страницы:
+// один испорченный файл не должен останавливать весь сервис.
+func recoverPanic(ctx context.Context, errMsg *string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	logger.ErrorCtx(ctx, fmt.Errorf("panic while processing file: %v\n%s", r, debug.Stack()))
+	*errMsg = fmt.Sprintf("internal error while processing file: %v", r)
+	trace.SpanFromContext(ctx).SetStatus(codes.Error, *errMsg)
+}
+
+// acquireWorker занимает рабочий слот и возвращает функцию его освобождения.
+func (s *OCRService) acquireWorker(ctx context.Context) func() {
+	s.workerSlots <- struct{}{}
+	trace.SpanFromContext(ctx).AddEvent("worker slot acquired")
+	return func() { <-s.workerSlots }
+}
+
+// splitPages разбивает файл на страницы. PDF, который провайдер принимает
+// целиком (см. repository.DocumentRecognizer), не разбивается: whole = true.
+func (s *OCRService) splitPages(ctx context.Context, data []byte, format filetype.Format, maxPages int) (pages []multipage.Page, whole bool, err error) {
+	release := s.acquireWorker(ctx)
+	defer release()
+
+	_, span := tracing.Start(ctx, "OCRService.splitPages",
+		attribute.String("ocr.format", format.Name),
+	)
+
+	if format.Name == filetype.PDF.Name {
+		pages, whole, err = s.splitPDF(data, format, maxPages)
+	} else {
+		pages, err = multipage.Split(data, format, maxPages)
+	}
+	span.SetAttributes(attribute.Int("ocr.pages", len(pages)), attribute.Bool("ocr.whole_document", whole))
+	tracing.End(span, err)
+
+	return pages, whole, err
+}
+
+func (s *OCRService) splitPDF(data []byte, format filetype.Format, maxPages int) ([]multipage.Page, bool, error) {
+	native := 0
+	if doc, ok := s.repository().(repository.DocumentRecognizer); ok {
+		native = doc.MaxDocumentPages(format.MIME)
+	}
+	if maxPages > 0 {
+		native = min(native, maxPages)
+	}
+	whole := []multipage.Page{{Number: 1, Data: data, Format: format}}
+
+	doc, err := pdf.Open(data)
+	if err != nil {
+		if native > 0 && !errors.Is(err, pdf.ErrEncrypted) {
+			return whole, true, nil // пусть документ разберёт провайдер
+		}
+		return nil, false, fmt.Errorf("failed to read PDF: %w", err)
+	}
+	if doc.NumPages() <= native {
+		return whole, true, nil
+	}
+	pages, err := multipage.SplitPDF(doc, maxPages)
+	return pages, false, err
+}
+
+// recognizeDocument отправляет документ провайдеру целиком и раскладывает
+// ответ по страницам (см. repository.PageRecognizer); если провайдер этого
+// не умеет, весь ответ относится к первой странице. Документ занимает один
+// рабочий слот на всё время распознавания, включая ожидание асинхронной операции.
+func (s *OCRService) recognizeDocument(ctx context.Context, repo repository.OCRRepository, data []byte, format filetype.Format, opts ProcessOptions) []domain.PageResult {
+	release := s.acquireWorker(ctx)
+	defer release()
+
+	ctx, span := tracing.Start(ctx, "OCRService.recognizeDocument")
+	defer span.End()
+
+	failed := func(err error) []domain.PageResult {
+		span.SetStatus(codes.Error, err.Error())
+		return []domain.PageResult{{Page: 1, Error: err.Error()}}
+	}
+
+	prepared, err := s.preprocess(ctx, data, format, opts.Preprocess)
+	if err != nil {
+		return failed(err)
+	}
+
+	err = repository.ErrPagesUnsupported
+	var texts []string
+	if pager, ok := repo.(repository.PageRecognizer); ok {
+		texts, err = pager.RecognizePages(ctx, prepared.Data, prepared.MIME)
+	}
+	if errors.Is(err, repository.ErrPagesUnsupported) {
+		var text string
+		text, err = repo.RecognizeFromBytes(ctx, prepared.Data, prepared.MIME)
+		texts = []string{text}
+	}
+	if err != nil {
+		return failed(err)
+	}
+	span.SetAttributes(attribute.Int("ocr.pages", len(texts)))
+
+	var images []multipage.Page
+	if opts.KeepImages && len(texts) > 1 {
+		if doc, err := pdf.Open(data); err == nil {
+			images, _ = multipage.SplitPDF(doc, 0)
+		}
+	}
+
+	results := make([]domain.PageResult, len(texts))
+	for i, text := range texts {
+		results[i] = domain.PageResult{Page: i + 1, Text: rawText(text), Preprocessing: prepared.Applied}
+		switch {
+		case !opts.KeepImages:
+		case len(texts) == 1:
+			results[i].Image = sourceImage(prepared)
+		case i < len(images) && images[i].Err == nil:
+			results[i].Image = &domain.SourceImage{Data: images[i].Data, MIME: images[i].Format.MIME}
+		}
+	}
+	return results
+}
+
+func (s *OCRService) recognizePages(ctx context.Context, repo repository.OCRRepository, pages []multipage.Page, opts ProcessOptions) []domain.PageResult {
+	results := make([]domain.PageResult, len(pages))
+
+	var wg sync.WaitGroup
+	for i, page := range pages {
+		results[i].Page = page.Number
+		if page.Err != nil {
+			results[i].Error = page.Err.Error()
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *domain.PageResult, page multipage.Page) {
+			defer wg.Done()
+
+			ctx := logger.WithFields(ctx, "page", page.Number)
+			ctx = trackOperations(ctx, page.Number)
+			ctx, span := tracing.Start(ctx, "OCRService.processPage",
+				attribute.Int("ocr.page", page.Number),
+			)
+			defer span.End()
+			defer recoverPanic(ctx, &result.Error)
+
+			release := s.acquireWorker(ctx)
+			defer release()
+
+			text, prepared, err := s.recognize(ctx, repo, page.Data, page.Format, opts.Preprocess)
+			result.Text, result.Preprocessing = text, prepared.Applied
+			if opts.KeepImages {
+				result.Image = sourceImage(prepared)
+			}
+			if err != nil {
+				result.Error = err.Error()
+				span.SetStatus(codes.Error, result.Error)
+			}
+		}(&results[i], page)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// recognize выполняет предобработку и распознавание одного изображения
+// и возвращает вместе с текстом изображение, отправленное провайдеру.
+func (s *OCRService) recognize(ctx context.Context, repo repository.OCRRepository, data []byte, format filetype.Format, opts preprocess.Options) (json.RawMessage, preprocess.Result, error) {
+	prepared, err := s.preprocess(ctx, data, format, opts)
+	if err != nil {
+		return nil, prepared, err
+	}
+
+	text, err := repo.RecognizeFromBytes(ctx, prepared.Data, prepared.MIME)
+	if err != nil {
+		return nil, prepared, err
+	}
+	return rawText(text), prepared, nil
+}
+
+// rawText возвращает ответ провайдера как есть, если это JSON, иначе — JSON-строкой.
+func rawText(text string) json.RawMessage {
+	var jsonCheck interface{}
+	if json.Unmarshal([]byte(text), &jsonCheck) == nil {
+		return json.RawMessage(text)
+	}
+	textJSON, _ := json.Marshal(text)
+	return textJSON
+}
+
+// addStructure раскладывает в результат таблицы и поля документа,
+// найденные в ответе провайдера; сам ответ остаётся в text как есть.
+func addStructure(result *domain.OCRResult) {
+	result.Tables, result.Entities = structure(result.Text)
+	for i := range result.Pages {
+		result.Pages[i].Tables, result.Pages[i].Entities = structure(result.Pages[i].Text)
+	}
+}
+
+func structure(text json.RawMessage) ([]domain.Table, []domain.Entity) {
+	if len(text) == 0 {
+		return nil, nil
+	}
+	page := layout.Parse(text)
+
+	var tables []domain.Table
+	for _, t := range page.Tables {
+		table := domain.Table{Rows: t.Rows, Columns: t.Columns, Cells: make([]domain.TableCell, 0, len(t.Cells))}
+		for _, c := range t.Cells {
+			table.Cells = append(table.Cells, domain.TableCell{
+				Row: c.Row, Column: c.Column, RowSpan: c.RowSpan, ColSpan: c.ColSpan, Text: c.Text,
+			})
+		}
+		tables = append(tables, table)
+	}
+
+	var entities []domain.Entity
+	for _, e := range page.Entities {
+		entities = append(entities, domain.Entity{Name: e.Name, Text: e.Text})
+	}
+	return tables, entities
+}
+
+func sourceImage(prepared preprocess.Result) *domain.SourceImage {
+	if len(prepared.Data) == 0 {
+		return nil
+	}
+	return &domain.SourceImage{Data: prepared.Data, MIME: prepared.MIME}
+}
+
+// readFile проверяет размер и формат файла и читает его содержимое.
+func readFile(file *multipart.FileHeader, opts ProcessOptions) ([]byte, filetype.Format, error) {
+	if err := validateImageSize(file, opts.MaxSizeMB); err != nil {
+		return nil, filetype.Format{}, err
+	}
+
 	f, err := file.Open()
 	if err != nil {
-		result.Error = fmt.Sprintf("failed to open file: %v", err)
-		return result
+		return nil, filetype.Format{}, fmt.Errorf("failed to open file: %v", err)
 	}
 	defer f.Close()
 
 	data, err := io.ReadAll(f)
 	if err != nil {
-		result.Error = fmt.Sprintf("failed to read file: %v", err)
-		return result
+		return nil, filetype.Format{}, fmt.Errorf("failed to read file: %v", err)
 	}
 
-	text, err := s.repo.RecognizeFromBytes(data)
+	format, err := detectFormat(file.Filename, data, opts.SupportedFormats)
 	if err != nil {
-		result.Error = err.Error()
-		return result
+		return nil, filetype.Format{}, err
 	}
+	return data, format, nil
+}
 
-	var jsonCheck interface{}
-	if json.Unmarshal([]byte(text), &jsonCheck) == nil {
-		result.Text = json.RawMessage(text)
-	} else {
-		textJSON, _ := json.Marshal(text)
-		result.Text = textJSON
+func (s *OCRService) preprocess(ctx context.Context, data []byte, format filetype.Format, opts preprocess.Options) (preprocess.Result, error) {
+	if !opts.Enabled() {
+		return preprocess.Result{Data: data, MIME: format.MIME}, nil
 	}
 
-	return result
+	_, span := tracing.Start(ctx, "OCRService.preprocess",
+		attribute.StringSlice("ocr.preprocess.steps", opts.Steps),
+	)
+	result, err := preprocess.Process(data, format, opts)
+	span.SetAttributes(attribute.StringSlice("ocr.preprocess.applied", result.Applied))
+	tracing.End(span, err)
+
+	return result, err
 }
 
 func validateImageSize(file *multipart.FileHeader, maxSizeMB int) error {
@@ -118,12 +780,28 @@ func validateImageSize(file *multipart.FileHeader, maxSizeMB int) error {
 	return nil
 }
 
-func validateImageFormat(filename string, supportedFormats []string) error {
+// detectFormat определяет формат по содержимому и отклоняет файлы
+// с незнакомым расширением, расширением, не совпадающим с содержимым,
+// или неразрешённым форматом.
+func detectFormat(filename string, data []byte, supportedFormats []string) (filetype.Format, error) {
 	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
-	for _, format := range supportedFormats {
-		if ext == format {
-			return nil
+	byExt, known := filetype.ByExtension(ext)
+	if ext != "" && !known {
+		return filetype.Format{}, fmt.Errorf("unsupported format: %s", ext)
+	}
+
+	format, ok := filetype.Detect(data)
+	if !ok {
+		return filetype.Format{}, fmt.Errorf("unrecognized file content")
+	}
+	if known && byExt.Name != format.Name {
+		return filetype.Format{}, fmt.Errorf("file extension .%s does not match its content (%s)", ext, format.MIME)
+	}
+
+	for _, supported := range supportedFormats {
+		if format.HasExtension(supported) {
+			return format, nil
 		}
 	}
-	return fmt.Errorf("unsupported format: %s", ext)
+	return filetype.Format{}, fmt.Errorf("unsupported format: %s", format.Name)
 }
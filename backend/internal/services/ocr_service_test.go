@@ -0,0 +1,174 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/airsss993/ocr-history/internal/domain"
+	"github.com/airsss993/ocr-history/internal/ratelimiter"
+	"github.com/airsss993/ocr-history/internal/repository"
+)
+
+func uploadPNG(t *testing.T, name string) []*multipart.FileHeader {
+	t.Helper()
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("images", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(img.Bytes())
+	w.Close()
+
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File["images"]
+}
+
+// TestAsyncOperationsSurviveShutdown: асинхронная операция Yandex видна
+// в Jobs, попадает в Unfinished при прерванной остановке, и Resume
+// после перезапуска забирает её результат.
+func TestAsyncOperationsSurviveShutdown(t *testing.T) {
+	var done atomic.Bool
+	yandex := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/async":
+			fmt.Fprint(w, `{"id":"op-1","done":false}`)
+		case "/operations/op-1":
+			fmt.Fprintf(w, `{"id":"op-1","done":%v}`, done.Load())
+		case "/recognition":
+			fmt.Fprint(w, `{"result":{"textAnnotation":{"fullText":"resumed"},"page":"0"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer yandex.Close()
+
+	limiter := ratelimiter.NewYandexRateLimiter(100)
+	defer limiter.Stop()
+	repo := repository.NewYandexOCRRepository("key", nil, "folder", "page",
+		repository.YandexEndpoints{
+			Async:       yandex.URL + "/async",
+			Operations:  yandex.URL + "/operations",
+			Recognition: yandex.URL + "/recognition",
+		},
+		repository.YandexAsyncOptions{Enabled: true, MinSize: 1, PollInterval: 5 * time.Millisecond, Timeout: 5 * time.Second},
+		limiter)
+
+	svc := NewOCRService("yandex", repo, 2, nil)
+	opts := ProcessOptions{MaxSizeMB: 1, SupportedFormats: []string{"png"}, HistoryOwner: "client-1"}
+	go svc.ProcessImages(context.Background(), "ip:127.0.0.1", uploadPNG(t, "page.png"), opts)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		jobs := svc.Jobs()
+		if len(jobs) == 1 && len(jobs[0].Operations) == 1 {
+			if op := jobs[0].Operations[0]; op.ID != "op-1" || op.File != "page.png" {
+				t.Errorf("job operation = %+v, want op-1 for page.png", op)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("async operation is not visible in Jobs: %+v", jobs)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := svc.Drain(ctx); err == nil {
+		t.Fatal("Drain finished while the operation is still running")
+	}
+
+	unfinished := svc.Unfinished()
+	if len(unfinished) != 1 {
+		t.Fatalf("got %d unfinished jobs, want 1", len(unfinished))
+	}
+	interrupted := unfinished[0]
+	if interrupted.HistoryOwner != "client-1" || len(interrupted.Operations) != 1 || len(interrupted.Pending) != 1 {
+		t.Fatalf("unfinished job = %+v, want the operation, the pending file and the history owner", interrupted)
+	}
+
+	// Перезапуск: новый сервис досчитывает операцию прерванного пакета.
+	done.Store(true)
+	restarted := NewOCRService("yandex", repo, 2, nil)
+
+	var results []domain.OCRResult
+	if err := restarted.Resume(context.Background(), interrupted, func(result domain.OCRResult) {
+		results = append(results, result)
+	}); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+
+	if len(results) != 1 || results[0].Filename != "page.png" || results[0].Error != "" ||
+		!strings.Contains(string(results[0].Text), "resumed") {
+		t.Errorf("resumed results = %+v", results)
+	}
+	if jobs := restarted.Jobs(); len(jobs) != 0 {
+		t.Errorf("resumed job is still listed: %+v", jobs)
+	}
+}
+
+func TestResumeUnsupported(t *testing.T) {
+	svc := NewOCRService("gemini", repository.NewGeminiRepository("key", "", "", ""), 1, nil)
+
+	err := svc.Resume(context.Background(), JobInfo{Operations: []AsyncOperation{{ID: "op-1"}}}, func(domain.OCRResult) {})
+	if !errors.Is(err, repository.ErrResumeUnsupported) {
+		t.Errorf("Resume = %v, want ErrResumeUnsupported", err)
+	}
+}
+
+// panickingRepository паникует со значением panicKey из контекста.
+type panickingRepository struct{}
+
+func (panickingRepository) RecognizeFromBytes(ctx context.Context, data []byte, mimeType string) (string, error) {
+	if v := ctx.Value(panicKey{}); v != nil {
+		panic(v)
+	}
+	return `{"text":"ok"}`, nil
+}
+
+type panicKey struct{}
+
+// TestProcessImagesRecoversPanic: паника провайдера на одном файле
+// становится ошибкой этого файла и не роняет процесс.
+func TestProcessImagesRecoversPanic(t *testing.T) {
+	svc := NewOCRService("gemini", panickingRepository{}, 2, nil)
+	opts := ProcessOptions{MaxSizeMB: 1, SupportedFormats: []string{"png"}}
+
+	ctx := context.WithValue(context.Background(), panicKey{}, "index out of range")
+	resp, err := svc.ProcessImages(ctx, "ip:127.0.0.1", uploadPNG(t, "page.png"), opts)
+	if err != nil {
+		t.Fatalf("ProcessImages: %v", err)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].Filename != "page.png" ||
+		!strings.Contains(resp.Results[0].Error, "index out of range") {
+		t.Errorf("results = %+v, want the panic reported as the file error", resp.Results)
+	}
+	if resp.Failed != 1 {
+		t.Errorf("Failed = %d, want 1", resp.Failed)
+	}
+	if jobs := svc.Jobs(); len(jobs) != 0 {
+		t.Errorf("job is still listed after the panic: %+v", jobs)
+	}
+}
@@ -1,8 +1,15 @@
 package storage
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,18 +23,28 @@ type HistoryEntry struct {
 type clientData struct {
 	entries    []HistoryEntry
 	lastAccess time.Time
+	claimToken string // выдаётся при создании истории, подтверждает владение client ID
 }
 
 type HistoryStorage struct {
 	data map[string]*clientData
 	mu   sync.RWMutex
 	ttl  time.Duration
+
+	hits   atomic.Int64
+	misses atomic.Int64
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
 }
 
 func NewHistoryStorage(ttl time.Duration) *HistoryStorage {
 	s := &HistoryStorage{
-		data: make(map[string]*clientData),
-		ttl:  ttl,
+		data:   make(map[string]*clientData),
+		ttl:    ttl,
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
 	}
 	go s.cleanup()
 	return s
@@ -39,28 +56,84 @@ func (s *HistoryStorage) Get(clientID string) []HistoryEntry {
 
 	cd, ok := s.data[clientID]
 	if !ok {
+		s.misses.Add(1)
 		return []HistoryEntry{}
 	}
+	s.hits.Add(1)
 
 	result := make([]HistoryEntry, len(cd.entries))
 	copy(result, cd.entries)
 	return result
 }
 
-func (s *HistoryStorage) Add(clientID string, entry HistoryEntry) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// Find возвращает запись истории клиента по ID.
+func (s *HistoryStorage) Find(clientID, entryID string) (HistoryEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
 	cd, ok := s.data[clientID]
 	if !ok {
-		cd = &clientData{
-			entries: []HistoryEntry{},
+		s.misses.Add(1)
+		return HistoryEntry{}, false
+	}
+	s.hits.Add(1)
+
+	for _, entry := range cd.entries {
+		if entry.ID == entryID {
+			return entry, true
 		}
-		s.data[clientID] = cd
 	}
+	return HistoryEntry{}, false
+}
 
+// Add добавляет запись в начало истории. Если история клиента создаётся этим
+// вызовом, возвращается её claim-токен; в остальных случаях — пустая строка.
+func (s *HistoryStorage) Add(clientID string, entry HistoryEntry) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cd, token := s.client(clientID)
 	cd.entries = append([]HistoryEntry{entry}, cd.entries...)
 	cd.lastAccess = time.Now()
+	return token
+}
+
+// client возвращает историю клиента, создавая её при необходимости вместе
+// с claim-токеном. Вызывать под s.mu.
+func (s *HistoryStorage) client(clientID string) (*clientData, string) {
+	if cd, ok := s.data[clientID]; ok {
+		return cd, ""
+	}
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	cd := &clientData{
+		entries:    []HistoryEntry{},
+		claimToken: hex.EncodeToString(buf),
+	}
+	s.data[clientID] = cd
+	return cd, cd.claimToken
+}
+
+// UpdateResult заменяет результат OCR записи истории, например исправленной
+// транскрипцией. Возвращает false, если записи нет.
+func (s *HistoryStorage) UpdateResult(clientID, entryID string, result json.RawMessage) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cd, ok := s.data[clientID]
+	if !ok {
+		return false
+	}
+
+	for i := range cd.entries {
+		if cd.entries[i].ID == entryID {
+			cd.entries[i].OcrResult = result
+			cd.lastAccess = time.Now()
+			return true
+		}
+	}
+	return false
 }
 
 func (s *HistoryStorage) Delete(clientID, entryID string) bool {
@@ -89,18 +162,151 @@ func (s *HistoryStorage) Clear(clientID string) {
 	delete(s.data, clientID)
 }
 
+// ErrInvalidClaim — claim-токен не совпадает с токеном истории client ID
+// или истории с таким client ID нет.
+var ErrInvalidClaim = errors.New("claim token does not match the client id's history")
+
+// Claim переносит историю clientID к владельцу to, если token совпадает с её
+// claim-токеном. bind вызывается под s.mu между проверкой и переносом, и его
+// ошибка отменяет перенос: конкурентный запрос не вклинится между проверкой
+// токена, закреплением client ID и переносом. Возвращает число перенесённых записей.
+func (s *HistoryStorage) Claim(clientID, token, to string, bind func() error) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, ok := s.data[clientID]
+	if !ok || src.claimToken == "" || subtle.ConstantTimeCompare([]byte(src.claimToken), []byte(token)) != 1 {
+		return 0, ErrInvalidClaim
+	}
+	if err := bind(); err != nil {
+		return 0, err
+	}
+	delete(s.data, clientID)
+
+	dst, _ := s.client(to)
+	dst.entries = append(dst.entries, src.entries...)
+	sort.SliceStable(dst.entries, func(i, j int) bool {
+		return dst.entries[i].CreatedAt.After(dst.entries[j].CreatedAt)
+	})
+	dst.lastAccess = time.Now()
+
+	return len(src.entries), nil
+}
+
+// MaxImportedEntries ограничивает историю клиента, пополняемую импортом.
+const MaxImportedEntries = 1000
+
+// ErrHistoryFull — импорт превысил бы MaxImportedEntries.
+var ErrHistoryFull = fmt.Errorf("history cannot hold more than %d entries", MaxImportedEntries)
+
+// Import добавляет записи в историю клиента, сохраняя ID и время создания,
+// и упорядочивает историю от новых к старым. Claim-токен возвращается, как в Add.
+// Если записей стало бы больше MaxImportedEntries, ничего не добавляется.
+func (s *HistoryStorage) Import(clientID string, entries []HistoryEntry) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := 0
+	if cd, ok := s.data[clientID]; ok {
+		existing = len(cd.entries)
+	}
+	if existing+len(entries) > MaxImportedEntries {
+		return "", ErrHistoryFull
+	}
+
+	cd, token := s.client(clientID)
+
+	cd.entries = append(cd.entries, entries...)
+	sort.SliceStable(cd.entries, func(i, j int) bool {
+		return cd.entries[i].CreatedAt.After(cd.entries[j].CreatedAt)
+	})
+	cd.lastAccess = time.Now()
+	return token, nil
+}
+
+// ClientStats — сводка по истории одного клиента.
+type ClientStats struct {
+	ClientID   string    `json:"client_id"`
+	Entries    int       `json:"entries"`
+	SizeBytes  int64     `json:"size_bytes"`
+	LastAccess time.Time `json:"last_access"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Stats возвращает сводку по всем клиентам, от самых больших к меньшим.
+func (s *HistoryStorage) Stats() []ClientStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]ClientStats, 0, len(s.data))
+	for clientID, cd := range s.data {
+		var size int64
+		for _, entry := range cd.entries {
+			size += int64(len(entry.ImageBase64) + len(entry.OcrResult))
+		}
+		result = append(result, ClientStats{
+			ClientID:   clientID,
+			Entries:    len(cd.entries),
+			SizeBytes:  size,
+			LastAccess: cd.lastAccess,
+			ExpiresAt:  cd.lastAccess.Add(s.ttl),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].SizeBytes > result[j].SizeBytes
+	})
+	return result
+}
+
+// Backend — название хранилища для метрик и диагностики.
+func (s *HistoryStorage) Backend() string {
+	return "memory"
+}
+
+// CacheStats возвращает число чтений истории, которые застали данные клиента
+// в памяти (hits), и тех, где данные уже вытеснены или не создавались (misses).
+func (s *HistoryStorage) CacheStats() (hits, misses int64) {
+	return s.hits.Load(), s.misses.Load()
+}
+
+// Expire удаляет клиентов, к истории которых не обращались дольше idle,
+// и возвращает их число. Фоновая очистка вызывает его с idle = ttl.
+func (s *HistoryStorage) Expire(idle time.Duration) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	now := time.Now()
+	for clientID, cd := range s.data {
+		if now.Sub(cd.lastAccess) > idle {
+			delete(s.data, clientID)
+			removed++
+		}
+	}
+	return removed
+}
+
 func (s *HistoryStorage) cleanup() {
+	defer close(s.done)
+
 	ticker := time.NewTicker(10 * time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		s.mu.Lock()
-		now := time.Now()
-		for clientID, cd := range s.data {
-			if now.Sub(cd.lastAccess) > s.ttl {
-				delete(s.data, clientID)
-			}
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.Expire(s.ttl)
 		}
-		s.mu.Unlock()
 	}
 }
+
+// Stop останавливает фоновую очистку.
+func (s *HistoryStorage) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+		<-s.done
+	})
+}
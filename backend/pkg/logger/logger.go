@@ -1,46 +1,132 @@
 package logger
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
 )
 
-var Logger zerolog.Logger
+// current — общий логгер. Configure подменяет его при перезагрузке
+// конфигурации, пока запросы пишут в лог, поэтому он хранится атомарно.
+var current atomic.Pointer[zerolog.Logger]
+
+type ctxKey struct{}
 
 func init() {
-	consoleWriter := zerolog.ConsoleWriter{
-		Out:        os.Stdout,
+	// Формат времени задаётся один раз: zerolog читает его без синхронизации.
+	// Консольный вывод всё равно печатает время в TimeFormat.
+	zerolog.TimeFieldFormat = time.RFC3339Nano
+	store(newLogger(consoleWriter()))
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+}
+
+func store(l zerolog.Logger) {
+	current.Store(&l)
+}
+
+// get возвращает общий логгер.
+func get() *zerolog.Logger {
+	return current.Load()
+}
+
+func consoleWriter() io.Writer {
+	return zerolog.ConsoleWriter{
+		Out:        redactWriter{w: os.Stdout},
 		TimeFormat: time.DateTime,
 		NoColor:    false,
 	}
+}
+
+// Writer возвращает stderr с маскированием секретов — для пакетов, которые
+// пишут мимо логгера (например, gin.Recovery со дампом запроса).
+func Writer() io.Writer {
+	return redactWriter{w: os.Stderr}
+}
 
-	Logger = zerolog.New(consoleWriter).
+func newLogger(w io.Writer) zerolog.Logger {
+	return zerolog.New(w).
 		With().
 		Timestamp().
-		Caller().
 		Logger()
+}
 
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+// Configure задаёт уровень ("debug", "info", "warn", "error") и формат
+// вывода: "console" — человекочитаемый, "json" — одна запись JSON на строку.
+func Configure(level, format string) error {
+	if level != "" {
+		lvl, err := zerolog.ParseLevel(strings.ToLower(level))
+		if err != nil {
+			return fmt.Errorf("invalid log level %q: %w", level, err)
+		}
+		zerolog.SetGlobalLevel(lvl)
+	}
+
+	switch strings.ToLower(format) {
+	case "", "console":
+		store(newLogger(consoleWriter()))
+	case "json":
+		store(newLogger(redactWriter{w: os.Stdout}))
+	default:
+		return fmt.Errorf("invalid log format %q, expected console or json", format)
+	}
+	return nil
+}
+
+// WithContext сохраняет логгер в контексте запроса.
+func WithContext(ctx context.Context, l zerolog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// WithFields добавляет к логгеру из контекста поля, переданные парами ключ-значение.
+func WithFields(ctx context.Context, keyvals ...interface{}) context.Context {
+	l := Ctx(ctx).With().Fields(keyvals).Logger()
+	return WithContext(ctx, l)
+}
+
+// Ctx возвращает логгер из контекста или общий логгер, если его там нет.
+func Ctx(ctx context.Context) *zerolog.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(ctxKey{}).(zerolog.Logger); ok {
+			return &l
+		}
+	}
+	return get()
 }
 
 func Debug(msg string) {
-	Logger.Debug().Msg(msg)
+	get().Debug().Caller(1).Msg(msg)
 }
 
 func Info(msg string) {
-	Logger.Info().Msg(msg)
+	get().Info().Caller(1).Msg(msg)
 }
 
 func Fatal(err error) {
-	Logger.Fatal().Err(err).Msg("Fatal error occurred")
+	get().Fatal().Caller(1).Err(err).Msg("Fatal error occurred")
 }
 
 func Error(err error) {
-	Logger.Error().Err(err).Msg("Error occurred")
+	get().Error().Caller(1).Err(err).Msg("Error occurred")
 }
 
 func Warn(msg string) {
-	Logger.Warn().Msg(msg)
+	get().Warn().Caller(1).Msg(msg)
+}
+
+func InfoCtx(ctx context.Context, msg string) {
+	Ctx(ctx).Info().Caller(1).Msg(msg)
+}
+
+func ErrorCtx(ctx context.Context, err error) {
+	Ctx(ctx).Error().Caller(1).Err(err).Msg("Error occurred")
+}
+
+func WarnCtx(ctx context.Context, msg string) {
+	Ctx(ctx).Warn().Caller(1).Msg(msg)
 }
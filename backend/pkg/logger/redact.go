@@ -0,0 +1,128 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	redactedMask = "[REDACTED]"
+
+	// maxErrorBodyLen ограничивает тело ответа провайдера, попадающее в ошибку.
+	maxErrorBodyLen = 512
+)
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+
+	redactRules = []struct {
+		re   *regexp.Regexp
+		repl string
+	}{
+		// Authorization: Bearer <token>, Api-Key <key>
+		{regexp.MustCompile(`(?i)\b(bearer|api-key)(\s+)[A-Za-z0-9\-._~+/]+=*`), "${1}${2}" + redactedMask},
+		// user:password@ в URL (прокси, DSN)
+		{regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.\-]*://)[^/\s:@"']+:[^/\s@"']*@`), "${1}" + redactedMask + "@"},
+		// ключи в query string
+		{regexp.MustCompile(`(?i)([?&](?:key|api_key|apikey|access_token|token)=)[^&\s"']+`), "${1}" + redactedMask},
+		// секретные поля JSON
+		{regexp.MustCompile(`(?i)("(?:access_token|id_token|refresh_token|private_key|api_key|apiKey|password|token|secret|assertion)"\s*:\s*")(?:[^"\\]|\\.)*"`), "${1}" + redactedMask + `"`},
+		// заголовки X-API-Key, X-Gemini-API-Key, x-goog-api-key в дампах запросов
+		{regexp.MustCompile(`(?i)\b(x-(?:gemini-)?api-key|x-goog-api-key)(["']?\s*[:=]\s*["']?)[^\s"',]+`), "${1}${2}" + redactedMask},
+		// Google API keys, ключи сервиса, IAM-токены Яндекса
+		{regexp.MustCompile(`\bAIza[0-9A-Za-z\-_]{35}\b`), redactedMask},
+		{regexp.MustCompile(`\bocr_[0-9A-Za-z\-_]{20,}\b`), redactedMask},
+		{regexp.MustCompile(`\b(?:t1\.|y[0-3]_)[0-9A-Za-z\-_.]{20,}`), redactedMask},
+		// JWT (сессии пользователей, assertion для Google и Yandex IAM)
+		{regexp.MustCompile(`\beyJ[A-Za-z0-9\-_]+\.eyJ[A-Za-z0-9\-_]+\.[A-Za-z0-9\-_]+`), redactedMask},
+		// base64 изображений: data URI и длинные непрерывные последовательности
+		{regexp.MustCompile(`data:[a-z]+/[a-z0-9.+\-]+;base64,[A-Za-z0-9+/]+=*`), "[base64 omitted]"},
+		{regexp.MustCompile(`[A-Za-z0-9+/]{200,}={0,2}`), "[base64 omitted]"},
+	}
+)
+
+// RegisterSecrets запоминает значения из конфигурации (ключи, пароли),
+// которые должны маскироваться в любом выводе дословно.
+func RegisterSecrets(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	for _, v := range values {
+		// короткие значения дадут ложные срабатывания
+		if len(v) < 6 {
+			continue
+		}
+		secrets = append(secrets, v)
+	}
+	// длинные сначала, чтобы секрет не маскировался частично
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+}
+
+// Redact маскирует в строке ключи API, bearer-токены, учётные данные
+// в URL и base64-содержимое изображений.
+func Redact(s string) string {
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redactedMask)
+	}
+	secretsMu.RUnlock()
+
+	for _, rule := range redactRules {
+		s = rule.re.ReplaceAllString(s, rule.repl)
+	}
+	return s
+}
+
+// RedactBody готовит тело ответа провайдера для текста ошибки:
+// маскирует секреты и обрезает до разумной длины.
+func RedactBody(body []byte) string {
+	s := Redact(string(body))
+	if len(s) > maxErrorBodyLen {
+		s = s[:maxErrorBodyLen] + "...(truncated)"
+	}
+	return s
+}
+
+// RedactError возвращает ошибку с замаскированным текстом. Цепочка
+// errors.Is/errors.As сохраняется.
+func RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := Redact(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{msg: msg, err: err}
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// Errorf — fmt.Errorf с маскированием секретов в итоговом тексте.
+func Errorf(format string, args ...interface{}) error {
+	return RedactError(fmt.Errorf(format, args...))
+}
+
+// redactWriter маскирует секреты в каждой записи логгера, поэтому защита
+// действует и для полей, добавленных в обход Redact.
+type redactWriter struct {
+	w io.Writer
+}
+
+func (rw redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}